@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	alertAttempts    = 4
+	alertSignature   = "X-Newsagg-Signature"
+	alertDeliveryLog = 50
+	alertQueueSize   = 1000
+)
+
+// alertMatcher reports whether the news item text matches an alert rule.
+type alertMatcher func(text string) bool
+
+// compileAlertRule compiles the query of the rule according to its kind.
+func compileAlertRule(rule repository.AlertRule) (alertMatcher, error) {
+	return compileMatcher(rule.Kind, rule.Query)
+}
+
+// compileMatcher compiles the query of an alert rule or a site filter of the kind.
+func compileMatcher(kind string, query string) (alertMatcher, error) {
+	switch kind {
+	case repository.AlertKeyword:
+		var words []string
+		for _, word := range strings.Split(query, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				words = append(words, word)
+			}
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("no keywords")
+		}
+		re := wordsRegexp(words)
+
+		return re.MatchString, nil
+	case repository.AlertRegex:
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, err
+		}
+
+		return re.MatchString, nil
+	case repository.AlertQuery:
+		return compileQuery(query)
+	}
+
+	return nil, fmt.Errorf("unknown rule kind %q", kind)
+}
+
+// wordsRegexp matches any of the words or phrases as whole words ignoring case.
+func wordsRegexp(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, regexp.QuoteMeta(word))
+	}
+
+	return regexp.MustCompile(`(?i)(^|[^\pL\pN])(` + strings.Join(quoted, "|") + `)($|[^\pL\pN])`)
+}
+
+type queryTerm struct {
+	re     *regexp.Regexp
+	negate bool
+}
+
+// compileQuery compiles a full-text query: all words and "quoted phrases" must be present,
+// words prefixed with - must be absent, OR separates alternative groups of terms.
+func compileQuery(query string) (alertMatcher, error) {
+	var groups [][]queryTerm
+	var group []queryTerm
+	for _, token := range queryTokens(query) {
+		if token == "OR" {
+			if len(group) > 0 {
+				groups = append(groups, group)
+			}
+			group = nil
+			continue
+		}
+		term := queryTerm{}
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			term.negate = true
+			token = token[1:]
+		}
+		term.re = wordsRegexp([]string{strings.Trim(token, `"`)})
+		group = append(group, term)
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	return func(text string) bool {
+		for _, group := range groups {
+			matched := true
+			for _, term := range group {
+				if term.re.MatchString(text) == term.negate {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// queryTokens splits query by spaces keeping quoted phrases together.
+func queryTokens(query string) (tokens []string) {
+	var token strings.Builder
+	flush := func() {
+		if strings.Trim(token.String(), `"-`) != "" {
+			tokens = append(tokens, token.String())
+		}
+		token.Reset()
+	}
+
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t'):
+			flush()
+		default:
+			token.WriteRune(r)
+		}
+	}
+	flush()
+
+	return
+}
+
+// alertText is the text of the news item alert rules are matched against.
+func alertText(item repository.NewsItem) string {
+	return item.Title + "\n" + item.Description
+}
+
+type alertMatch struct {
+	rule repository.AlertRule
+	item repository.NewsItem
+}
+
+// matchAlerts returns news matched by alert rules, skipping rules that fail to compile.
+func (app *application) matchAlerts(rules []repository.AlertRule, news []repository.NewsItem) (matches []alertMatch) {
+	for _, rule := range rules {
+		matcher, err := compileAlertRule(rule)
+		if err != nil {
+			app.log.WithError(err).WithField("rule_id", rule.ID).Warn("Skip invalid alert rule")
+			continue
+		}
+		for _, item := range news {
+			if rule.SiteID != 0 && rule.SiteID != item.SiteID {
+				continue
+			}
+			if matcher(alertText(item)) {
+				matches = append(matches, alertMatch{rule, item})
+			}
+		}
+	}
+
+	return
+}
+
+// alert evaluates alert rules against news inserted in a parse cycle and queues deliveries of matches.
+func (app *application) alert(news []repository.NewsItem) {
+	if len(news) == 0 {
+		return
+	}
+
+	rules, err := app.repository.GetAlertRules()
+	if err != nil {
+		app.log.WithError(err).Error("Failed get alert rules from repository")
+
+		return
+	}
+
+	matches := app.matchAlerts(rules, news)
+	if len(matches) == 0 {
+		return
+	}
+	for _, match := range matches {
+		if match.rule.TelegramChat != "" {
+			app.queueTelegram(telegramPost{chatID: match.rule.TelegramChat, item: match.item, rule: match.rule.Name})
+		}
+		if match.rule.WebhookUrl != "" {
+			app.queueAlert(match)
+		}
+	}
+}
+
+// queueAlert queues the webhook delivery of the match dropping it when the queue is full.
+func (app *application) queueAlert(match alertMatch) {
+	if app.alertQueue == nil {
+		return
+	}
+
+	select {
+	case app.alertQueue <- match:
+	default:
+		app.log.WithFields(logrus.Fields{"rule_id": match.rule.ID, "news_item_id": match.item.ID}).Warn("Drop alert delivery, queue is full")
+	}
+}
+
+// delivering posts queued alert matches to webhooks one by one.
+func (app *application) delivering() {
+	go func() {
+		for {
+			select {
+			case <-app.stop:
+				return
+			case match := <-app.alertQueue:
+				app.deliverAlert(match.rule, match.item)
+			}
+		}
+	}()
+}
+
+type alertPayload struct {
+	Rule struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"rule"`
+	NewsItem apiNewsItem `json:"news_item"`
+}
+
+// signPayload returns the signature of the webhook body with the rule secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverAlert posts the matched news item to the rule webhook, retrying with exponential backoff
+// on network errors and 5xx or 429 responses, and records the delivery.
+func (app *application) deliverAlert(rule repository.AlertRule, item repository.NewsItem) {
+	alertLog := app.log.WithFields(logrus.Fields{"rule_id": rule.ID, "news_item_id": item.ID})
+	delivery := &repository.AlertDelivery{RuleID: rule.ID, NewsItemID: item.ID}
+	defer func() {
+		if err := app.repository.AddAlertDelivery(delivery); err != nil {
+			alertLog.WithError(err).Error("Failed add alert delivery to repository")
+		}
+	}()
+
+	payload := alertPayload{NewsItem: newApiNewsItem(item)}
+	payload.Rule.ID = rule.ID
+	payload.Rule.Name = rule.Name
+	body, err := json.Marshal(payload)
+	if err != nil {
+		delivery.Error = err.Error()
+		alertLog.WithError(err).Error("Failed encode alert payload")
+
+		return
+	}
+
+	backoff := app.alertBackoff
+	for delivery.Attempts < alertAttempts {
+		if delivery.Attempts > 0 {
+			select {
+			case <-app.stop:
+				alertLog.WithField("attempts", delivery.Attempts).Warn("Stop retrying alert delivery")
+
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		delivery.Attempts++
+
+		retry := app.postAlert(rule, body, delivery)
+		if delivery.Delivered || !retry {
+			break
+		}
+	}
+
+	if delivery.Delivered {
+		alertLog.WithField("attempts", delivery.Attempts).Info("Delivered alert")
+	} else {
+		alertLog.WithField("attempts", delivery.Attempts).WithField("error", delivery.Error).Warn("Failed deliver alert")
+	}
+}
+
+// postAlert makes one delivery attempt and reports whether it may be retried.
+func (app *application) postAlert(rule repository.AlertRule, body []byte, delivery *repository.AlertDelivery) bool {
+	req, err := http.NewRequest(http.MethodPost, rule.WebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(alertSignature, signPayload(rule.Secret, body))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+
+		return true
+	}
+	res.Body.Close()
+
+	delivery.StatusCode = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		delivery.Delivered = true
+		delivery.Error = ""
+
+		return false
+	}
+	delivery.Error = "webhook responded with status code " + strconv.Itoa(res.StatusCode)
+
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+}
+
+func (app *application) alertsHandler(res http.ResponseWriter, req *http.Request) {
+	user := app.user(req)
+	form := repository.AlertRule{Kind: repository.AlertKeyword}
+	var errors map[string]string
+
+	if req.Method == http.MethodPost {
+		form.Name = strings.TrimSpace(req.FormValue("name"))
+		form.Kind = req.FormValue("kind")
+		form.Query = strings.TrimSpace(req.FormValue("query"))
+		form.SiteID, _ = strconv.Atoi(req.FormValue("site_id"))
+		form.WebhookUrl = strings.TrimSpace(req.FormValue("webhook_url"))
+		form.TelegramChat = strings.TrimSpace(req.FormValue("telegram_chat"))
+
+		errors = validateAlertRule(form)
+		if len(errors) == 0 {
+			secret, err := newToken()
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail generate alert secret")
+
+				return
+			}
+			form.UserID = user.ID
+			form.Secret = secret
+			err = app.repository.AddAlertRule(&form)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail insert alert rule to repository")
+
+				return
+			}
+			http.Redirect(res, req, "/alerts", http.StatusSeeOther)
+
+			return
+		}
+	}
+
+	rules, err := app.repository.GetUserAlertRules(user.ID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get alert rules from repository")
+
+		return
+	}
+
+	deliveries, err := app.repository.GetAlertDeliveries(user.ID, alertDeliveryLog)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get alert deliveries from repository")
+
+		return
+	}
+
+	sites, err := app.repository.GetSites()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get sites from repository")
+
+		return
+	}
+
+	if len(errors) > 0 {
+		res.WriteHeader(http.StatusBadRequest)
+	}
+	err = app.render(
+		res,
+		"alerts.tmpl",
+		struct {
+			CSRF       string
+			User       *repository.User
+			Rules      []repository.AlertRule
+			Deliveries []repository.AlertDelivery
+			Sites      []repository.Site
+			Form       repository.AlertRule
+			Errors     map[string]string
+		}{
+			app.csrfToken(req), user, rules, deliveries, sites, form, errors,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
+
+func (app *application) alertDeleteHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, "/alerts", http.StatusSeeOther)
+
+		return
+	}
+
+	err = app.repository.DeleteAlertRule(app.user(req).ID, id)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail delete alert rule from repository")
+
+		return
+	}
+
+	http.Redirect(res, req, "/alerts", http.StatusSeeOther)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCompileAlertRule(t *testing.T) {
+	matcher, err := compileAlertRule(repository.AlertRule{Kind: repository.AlertKeyword, Query: "Газпром, SBER ,"})
+	assert.NoError(t, err)
+	assert.True(t, matcher("Акции газпрома и ГАЗПРОМ растут"))
+	assert.True(t, matcher("sber: отчет"))
+	assert.False(t, matcher("SBERBANK"))
+
+	matcher, err = compileAlertRule(repository.AlertRule{Kind: repository.AlertRegex, Query: `\bIPO\b`})
+	assert.NoError(t, err)
+	assert.True(t, matcher("Компания готовит IPO"))
+	assert.False(t, matcher("Компания готовит ipo"))
+
+	_, err = compileAlertRule(repository.AlertRule{Kind: repository.AlertRegex, Query: `(`})
+	assert.Error(t, err)
+	_, err = compileAlertRule(repository.AlertRule{Kind: repository.AlertKeyword, Query: " , "})
+	assert.Error(t, err)
+	_, err = compileAlertRule(repository.AlertRule{Kind: "unknown", Query: "test"})
+	assert.Error(t, err)
+
+	matcher, err = compileAlertRule(repository.AlertRule{Kind: repository.AlertQuery, Query: `"центральный банк" ставка -прогноз OR ЦБ`})
+	assert.NoError(t, err)
+	assert.True(t, matcher("Центральный банк повысил ставку и ставка выросла"))
+	assert.False(t, matcher("Центральный банк повысил ставку"))
+	assert.False(t, matcher("Центральный банк: прогноз, ставка"))
+	assert.True(t, matcher("ЦБ опубликовал прогноз"))
+	assert.False(t, matcher("банк центральный, ставка"))
+
+	_, err = compileAlertRule(repository.AlertRule{Kind: repository.AlertQuery, Query: ` OR "" - `})
+	assert.Error(t, err)
+}
+
+func TestQueryTokens(t *testing.T) {
+	assert.Equal(t, []string{`"a b"`, "-c", "OR", "d"}, queryTokens(` "a b"  -c OR d - ""`))
+}
+
+func TestMatchAlerts(t *testing.T) {
+	app := getApplication()
+	rules := []repository.AlertRule{
+		{ID: 1, Kind: repository.AlertKeyword, Query: "нефть"},
+		{ID: 2, Kind: repository.AlertKeyword, Query: "нефть", SiteID: 2},
+		{ID: 3, Kind: repository.AlertRegex, Query: "("},
+	}
+	news := []repository.NewsItem{
+		{ID: 10, SiteID: 1, Title: "Цены на нефть"},
+		{ID: 11, SiteID: 2, Title: "Погода", Description: "Нефть дешевеет"},
+		{ID: 12, SiteID: 2, Title: "Погода"},
+	}
+
+	matches := app.matchAlerts(rules, news)
+	assert.Len(t, matches, 3)
+	assert.Equal(t, 1, matches[0].rule.ID)
+	assert.Equal(t, 10, matches[0].item.ID)
+	assert.Equal(t, 11, matches[1].item.ID)
+	assert.Equal(t, 2, matches[2].rule.ID)
+	assert.Equal(t, 11, matches[2].item.ID)
+}
+
+func TestDeliverAlert(t *testing.T) {
+	var calls int32
+	var payload alertPayload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		signature = req.Header.Get(alertSignature)
+		assert.Equal(t, signPayload("secret", body), signature)
+		json.Unmarshal(body, &payload)
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	app := getApplication()
+	app.webhookClient = server.Client()
+	app.alertBackoff = time.Millisecond
+	app.repository.(*mockedRepository).
+		On("AddAlertDelivery", mock.MatchedBy(func(delivery *repository.AlertDelivery) bool {
+			return delivery.RuleID == 1 && delivery.NewsItemID == 10
+		})).
+		Return(nil)
+
+	rule := repository.AlertRule{ID: 1, Name: "Нефть", WebhookUrl: server.URL, Secret: "secret"}
+	app.deliverAlert(rule, repository.NewsItem{ID: 10, SiteID: 1, Title: "Цены на нефть"})
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.Equal(t, "Нефть", payload.Rule.Name)
+	assert.Equal(t, "Цены на нефть", payload.NewsItem.Title)
+	delivery := app.repository.(*mockedRepository).Calls[0].Arguments.Get(0).(*repository.AlertDelivery)
+	assert.True(t, delivery.Delivered)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusNoContent, delivery.StatusCode)
+	assert.Empty(t, delivery.Error)
+}
+
+func TestDeliverAlertFailure(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		status := http.StatusInternalServerError
+		if req.URL.Path == "/gone" {
+			status = http.StatusGone
+		}
+		atomic.AddInt32(&calls, 1)
+		res.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	app := getApplication()
+	app.webhookClient = server.Client()
+	app.alertBackoff = time.Millisecond
+	app.repository.(*mockedRepository).
+		On("AddAlertDelivery", mock.Anything).
+		Return(nil)
+
+	app.deliverAlert(repository.AlertRule{ID: 1, WebhookUrl: server.URL}, repository.NewsItem{ID: 10})
+	assert.EqualValues(t, alertAttempts, atomic.LoadInt32(&calls))
+	delivery := app.repository.(*mockedRepository).Calls[0].Arguments.Get(0).(*repository.AlertDelivery)
+	assert.False(t, delivery.Delivered)
+	assert.Equal(t, alertAttempts, delivery.Attempts)
+	assert.Equal(t, "webhook responded with status code 500", delivery.Error)
+
+	app.deliverAlert(repository.AlertRule{ID: 1, WebhookUrl: server.URL + "/gone"}, repository.NewsItem{ID: 10})
+	assert.EqualValues(t, alertAttempts+1, atomic.LoadInt32(&calls))
+	delivery = app.repository.(*mockedRepository).Calls[1].Arguments.Get(0).(*repository.AlertDelivery)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusGone, delivery.StatusCode)
+}
+
+func TestAlertQueue(t *testing.T) {
+	delivered := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var payload alertPayload
+		json.NewDecoder(req.Body).Decode(&payload)
+		delivered <- payload.NewsItem.Title
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	app := getApplication()
+	app.webhookClient = server.Client()
+	app.alertQueue = make(chan alertMatch, alertQueueSize)
+	app.repository.(*mockedRepository).
+		On("GetAlertRules").
+		Return([]repository.AlertRule{{ID: 1, Kind: repository.AlertKeyword, Query: "нефть", WebhookUrl: server.URL}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddAlertDelivery", mock.Anything).
+		Return(nil)
+
+	app.alert([]repository.NewsItem{{ID: 1, Title: "Нефть дорожает"}, {ID: 2, Title: "Погода"}})
+	assert.Len(t, app.alertQueue, 1)
+
+	app.delivering()
+	defer app.Stop()
+	select {
+	case title := <-delivered:
+		assert.Equal(t, "Нефть дорожает", title)
+	case <-time.After(time.Second):
+		t.Fatal("alert is not delivered")
+	}
+
+	app = getApplication()
+	app.alertQueue = make(chan alertMatch, alertQueueSize)
+	for i := 0; i < alertQueueSize+1; i++ {
+		app.queueAlert(alertMatch{})
+	}
+	assert.Len(t, app.alertQueue, alertQueueSize)
+}
+
+func TestDeliverAlertStop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	app := getApplication()
+	app.webhookClient = server.Client()
+	app.alertBackoff = time.Hour
+	app.repository.(*mockedRepository).
+		On("AddAlertDelivery", mock.Anything).
+		Return(nil)
+
+	done := make(chan bool)
+	go func() {
+		app.deliverAlert(repository.AlertRule{ID: 1, WebhookUrl: server.URL}, repository.NewsItem{ID: 10})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	app.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("delivery does not stop retrying")
+	}
+	delivery := app.repository.(*mockedRepository).Calls[0].Arguments.Get(0).(*repository.AlertDelivery)
+	assert.False(t, delivery.Delivered)
+	assert.Equal(t, 1, delivery.Attempts)
+}
+
+func TestAlertsHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1, Login: "user"}
+
+	app.repository.(*mockedRepository).
+		On("GetUserAlertRules", 1).
+		Return([]repository.AlertRule{{ID: 1, Name: "Нефть", Kind: repository.AlertKeyword, Query: "нефть", WebhookUrl: "http://hooks.test/1", Secret: "abc"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetAlertDeliveries", 1, alertDeliveryLog).
+		Return([]repository.AlertDelivery{{RuleID: 1, NewsItemID: 10, Attempts: 4, Error: "webhook responded with status code 500"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 2, Url: "http://test2.ru"}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddAlertRule", mock.MatchedBy(func(rule *repository.AlertRule) bool {
+			return rule.UserID == 1 && rule.Kind == repository.AlertRegex && rule.SiteID == 2 && len(rule.Secret) == 64
+		})).
+		Return(nil)
+	handler := http.HandlerFunc(app.alertsHandler)
+
+	req, _ := http.NewRequest("GET", "/alerts", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "http://hooks.test/1")
+	assert.Contains(t, rr.Body.String(), "webhook responded with status code 500")
+
+	reader := strings.NewReader("name=IPO&kind=regex&query=%5CbIPO%5Cb&site_id=2&webhook_url=https%3A%2F%2Fhooks.test%2F2")
+	req, _ = http.NewRequest("POST", "/alerts", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddAlertRule", 1)
+
+	reader = strings.NewReader("name=IPO&kind=regex&query=%28&site_id=2&webhook_url=ftp%3A%2F%2Fhooks.test")
+	req, _ = http.NewRequest("POST", "/alerts", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Некорректный запрос")
+	assert.Contains(t, rr.Body.String(), `<option value="2" selected>`)
+
+	reader = strings.NewReader("name=IPO&kind=regex&query=%3Cscript%3E%28&webhook_url=https%3A%2F%2Fhooks.test%2F2")
+	req, _ = http.NewRequest("POST", "/alerts", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "&lt;script&gt;(")
+	assert.NotContains(t, rr.Body.String(), "<script>")
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddAlertRule", 1)
+}
+
+func TestAlertDeleteHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("DeleteAlertRule", 1, 3).
+		Return(nil)
+
+	req, _ := http.NewRequest("POST", "/alerts/delete", strings.NewReader("id=3"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.alertDeleteHandler).ServeHTTP(rr, withUser(req, &repository.User{ID: 1}))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "DeleteAlertRule", 1)
+}
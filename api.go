@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const (
+	apiDefaultLimit = 20
+	apiMaxLimit     = 100
+)
+
+type apiNewsItem struct {
+	ID            int       `json:"id"`
+	SiteID        int       `json:"site_id"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Link          string    `json:"link"`
+	CanonicalLink string    `json:"canonical_link"`
+	Date          string    `json:"date"`
+	Image         string    `json:"image"`
+	PublishedAt   time.Time `json:"published_at"`
+	StoryID       int       `json:"story_id"`
+	Tags          []string  `json:"tags"`
+	// Sources is the number of other sites which reported the story, set for collapsed stories.
+	Sources int `json:"sources,omitempty"`
+}
+
+func newApiNewsItem(item repository.NewsItem) apiNewsItem {
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return apiNewsItem{
+		ID:            item.ID,
+		SiteID:        item.SiteID,
+		Title:         item.Title,
+		Description:   item.Description,
+		Link:          item.Link,
+		CanonicalLink: item.CanonicalLink,
+		Date:          item.Date,
+		Image:         item.Image,
+		PublishedAt:   item.PublishedAt,
+		StoryID:       item.Story(),
+		Tags:          tags,
+	}
+}
+
+type apiSite struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	// IsRss selects the rss or html type of created sites without a type, it is kept for older clients.
+	IsRss           bool   `json:"is_rss,omitempty"`
+	Url             string `json:"url"`
+	NewsItemPath    string `json:"news_item_path,omitempty"`
+	TitlePath       string `json:"title_path,omitempty"`
+	DescriptionPath string `json:"description_path,omitempty"`
+	LinkPath        string `json:"link_path,omitempty"`
+	DatePath        string `json:"date_path,omitempty"`
+	ImagePath       string `json:"image_path,omitempty"`
+	CategoryPath    string `json:"category_path,omitempty"`
+	DefaultTags     string `json:"default_tags,omitempty"`
+	FetchContent    bool   `json:"fetch_content,omitempty"`
+	ContentPath     string `json:"content_path,omitempty"`
+	Processors      string `json:"processors,omitempty"`
+	// Options are the settings of the source type without a field of their own.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func newApiSite(site repository.Site) apiSite {
+	return apiSite{
+		ID:              site.ID,
+		Type:            site.Type,
+		Url:             site.Url,
+		NewsItemPath:    site.NewsItemPath,
+		TitlePath:       site.TitlePath,
+		DescriptionPath: site.DescriptionPath,
+		LinkPath:        site.LinkPath,
+		DatePath:        site.DatePath,
+		ImagePath:       site.ImagePath,
+		CategoryPath:    site.CategoryPath,
+		DefaultTags:     site.DefaultTags,
+		FetchContent:    site.FetchContent,
+		ContentPath:     site.ContentPath,
+		Processors:      site.Processors,
+		Options:         site.OptionMap(),
+	}
+}
+
+func (site apiSite) site() repository.Site {
+	result := repository.Site{
+		Type:            site.Type,
+		Url:             site.Url,
+		NewsItemPath:    site.NewsItemPath,
+		TitlePath:       site.TitlePath,
+		DescriptionPath: site.DescriptionPath,
+		LinkPath:        site.LinkPath,
+		DatePath:        site.DatePath,
+		ImagePath:       site.ImagePath,
+		CategoryPath:    site.CategoryPath,
+		DefaultTags:     strings.Join(repository.SplitTags(site.DefaultTags), ", "),
+		FetchContent:    site.FetchContent,
+		ContentPath:     site.ContentPath,
+		Processors:      site.Processors,
+	}
+	if result.Type == "" && site.IsRss {
+		result.Type = parser.TypeRss
+	} else if result.Type == "" {
+		result.Type = parser.TypeHtml
+	}
+	for name, value := range site.Options {
+		result.SetOption(name, value)
+	}
+
+	return result
+}
+
+func writeJSON(res http.ResponseWriter, status int, data interface{}) {
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(data)
+}
+
+func writeJSONError(res http.ResponseWriter, status int, message string) {
+	writeJSON(res, status, map[string]string{"error": message})
+}
+
+func (app *application) apiNewsHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(res, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = apiDefaultLimit
+	} else if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+
+	user := app.user(req)
+	filter := userNewsFilter(newsFilterFromQuery(req.URL.Query()), req.URL.Query(), user)
+	if req.URL.Query().Get("starred") == "1" {
+		filter.StarredBy = user.ID
+	}
+	filter.Collapse = filter.Story == 0 && req.URL.Query().Get("collapse") != "0"
+	page, err := app.repository.GetNews(newsCursorFromQuery(req.URL.Query()), limit, filter)
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail get news from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	sources := map[int]int{}
+	if filter.Collapse {
+		sources, err = app.storySources(page.Items)
+		if err != nil {
+			app.requestLog(req).WithError(err).Error("Fail get story sources from repository")
+			writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+			return
+		}
+	}
+	items := make([]apiNewsItem, 0, len(page.Items))
+	for _, item := range page.Items {
+		apiItem := newApiNewsItem(item)
+		apiItem.Sources = sources[item.Story()]
+		items = append(items, apiItem)
+	}
+	result := struct {
+		Items      []apiNewsItem `json:"items"`
+		Total      int           `json:"total"`
+		HasNext    bool          `json:"has_next"`
+		HasPrev    bool          `json:"has_prev"`
+		NextBefore int           `json:"next_before,omitempty"`
+		PrevAfter  int           `json:"prev_after,omitempty"`
+	}{
+		Items:   items,
+		Total:   page.Total,
+		HasNext: page.HasNext,
+		HasPrev: page.HasPrev,
+	}
+	if page.HasNext {
+		result.NextBefore = page.NextCursor().Before
+	}
+	if page.HasPrev {
+		result.PrevAfter = page.PrevCursor().After
+	}
+
+	writeJSON(res, http.StatusOK, result)
+}
+
+func (app *application) apiSitesHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		app.requireScope(repository.ScopeRead, app.apiSiteListHandler)(res, req)
+	case http.MethodPost:
+		app.requireScope(repository.ScopeAdmin, app.apiSiteCreateHandler)(res, req)
+	default:
+		writeJSONError(res, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (app *application) apiSiteListHandler(res http.ResponseWriter, req *http.Request) {
+	sites, err := app.repository.GetSites()
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail get sites from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	result := make([]apiSite, 0, len(sites))
+	for _, site := range sites {
+		result = append(result, newApiSite(site))
+	}
+
+	writeJSON(res, http.StatusOK, result)
+}
+
+func (app *application) apiSiteCreateHandler(res http.ResponseWriter, req *http.Request) {
+	var data apiSite
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+		writeJSONError(res, http.StatusBadRequest, "invalid json: "+err.Error())
+
+		return
+	}
+
+	site := data.site()
+	if errors := validateSite(site); len(errors) > 0 {
+		writeJSON(res, http.StatusUnprocessableEntity, map[string]interface{}{"error": "invalid site", "fields": errors})
+
+		return
+	}
+
+	err := app.repository.AddSite(&site)
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail insert site to repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	writeJSON(res, http.StatusCreated, newApiSite(site))
+}
+
+func (app *application) apiSiteHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		writeJSONError(res, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/api/sites/"))
+	if err != nil {
+		writeJSONError(res, http.StatusNotFound, "site not found")
+
+		return
+	}
+
+	err = app.repository.DeleteSite(id)
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail delete site from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
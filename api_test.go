@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiNewsHandler(t *testing.T) {
+	app := getApplication()
+	published := time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{Before: 20}, 2, repository.NewsFilter{SiteIDs: []int{1}, Collapse: true}).
+		Return(repository.NewsPage{
+			Total:   5,
+			HasNext: true,
+			HasPrev: true,
+			Items: []repository.NewsItem{
+				{ID: 19, SiteID: 1, Title: "Заголовок 1", Link: "http://test1.ru/news/1", CanonicalLink: "https://test1.ru/news/1", PublishedAt: published, Tags: []string{"экономика"}},
+				{ID: 18, SiteID: 1, Title: "Заголовок 2", Link: "http://test1.ru/news/2", CanonicalLink: "https://test1.ru/news/2", PublishedAt: published},
+			},
+		}, nil)
+	app.repository.(*mockedRepository).
+		On("GetStorySources", []int{19, 18}).
+		Return([]repository.StorySources{{StoryID: 19, Sources: 1}, {StoryID: 18, Sources: 3}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, apiDefaultLimit, repository.NewsFilter{Story: 18}).
+		Return(repository.NewsPage{
+			Total: 1,
+			Items: []repository.NewsItem{{ID: 21, SiteID: 2, Title: "Заголовок 2", Link: "http://test2.ru/2", StoryID: 18, PublishedAt: published}},
+		}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/news?site=1&before=20&limit=2", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.apiNewsHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{
+		"items": [
+			{"id": 19, "site_id": 1, "title": "Заголовок 1", "description": "", "link": "http://test1.ru/news/1", "canonical_link": "https://test1.ru/news/1", "date": "", "image": "", "published_at": "2019-09-27T04:00:00Z", "story_id": 19, "tags": ["экономика"]},
+			{"id": 18, "site_id": 1, "title": "Заголовок 2", "description": "", "link": "http://test1.ru/news/2", "canonical_link": "https://test1.ru/news/2", "date": "", "image": "", "published_at": "2019-09-27T04:00:00Z", "story_id": 18, "sources": 2, "tags": []}
+		],
+		"total": 5,
+		"has_next": true,
+		"has_prev": true,
+		"next_before": 18,
+		"prev_after": 19
+	}`, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/news?story=18", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiNewsHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"story_id":18`)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetStorySources", 1)
+
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, apiDefaultLimit, repository.NewsFilter{Tag: "экономика"}).
+		Return(repository.NewsPage{Total: 1, Items: []repository.NewsItem{{ID: 21, StoryID: 18, Tags: []string{"экономика"}}}}, nil)
+	req, _ = http.NewRequest("GET", "/api/news?tag=%D0%AD%D0%BA%D0%BE%D0%BD%D0%BE%D0%BC%D0%B8%D0%BA%D0%B0&collapse=0", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiNewsHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"tags":["экономика"]`)
+}
+
+func TestApiSitesHandler(t *testing.T) {
+	app := getApplication()
+	admin := &repository.User{ID: 1, IsAdmin: true}
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 1, Url: "http://test1.ru", Type: parser.TypeRss}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddSite", &repository.Site{Type: parser.TypeHtml, Url: "http://test2.ru", NewsItemPath: "article", TitlePath: "h3", LinkPath: "a"}).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddSite", &repository.Site{Type: parser.TypeSitemap, Url: "http://test4.ru/sitemap.xml", Options: `{"link_pattern":"/news/"}`}).
+		Return(nil)
+	handler := http.HandlerFunc(app.apiSitesHandler)
+
+	req, _ := http.NewRequest("GET", "/api/sites", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 2}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"id": 1, "type": "rss", "url": "http://test1.ru"}]`, rr.Body.String())
+
+	body := `{"url": "http://test2.ru", "news_item_path": "article", "title_path": "h3", "link_path": "a"}`
+	req, _ = http.NewRequest("POST", "/api/sites", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 2}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/api/sites", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, admin))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 1)
+
+	req, _ = http.NewRequest("POST", "/api/sites", strings.NewReader(`{"url": "http://test4.ru/sitemap.xml", "type": "sitemap", "options": {"link_pattern": "/news/"}}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, admin))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"options":{"link_pattern":"/news/"}`)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 2)
+
+	req, _ = http.NewRequest("POST", "/api/sites", strings.NewReader(`{"url": "ftp://test3.ru", "is_rss": true}`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, admin))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"url"`)
+
+	req, _ = http.NewRequest("POST", "/api/sites", strings.NewReader(`{`))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, admin))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 2)
+}
+
+func TestApiSiteHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("DeleteSite", 3).
+		Return(nil)
+
+	req, _ := http.NewRequest("DELETE", "/api/sites/3", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.apiSiteHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/api/sites/3", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiSiteHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
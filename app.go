@@ -2,54 +2,215 @@ package main
 
 import (
 	"fmt"
+	"html/template"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
-	"text/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/onauryzbaev/go_news_final_/mailer"
+	"github.com/onauryzbaev/go_news_final_/parser"
 	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/telegram"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 )
 
 type Repository interface {
-	Migrate()
+	Migrate() error
+	Ping() error
 	GetSites() ([]repository.Site, error)
+	GetSite(id int) (repository.Site, error)
 	AddSite(site *repository.Site) error
 	DeleteSite(id int) error
-	GetNews(offset int, limit int, search string) ([]repository.NewsItem, error)
+	GetNews(cursor repository.NewsCursor, limit int, filter repository.NewsFilter) (repository.NewsPage, error)
+	GetNewsAfter(afterID int, limit int, filter repository.NewsFilter) ([]repository.NewsItem, error)
 	HasNewsItem(item repository.NewsItem) (bool, error)
 	AddNewsItem(item *repository.NewsItem) error
+	AddParseRun(run *repository.ParseRun) error
+	GetParseRuns(siteID int, limit int) ([]repository.ParseRun, error)
+	GetDailyStats(siteID int, since time.Time) ([]repository.DailyStat, error)
+	DeleteParseRunsBefore(before time.Time) error
+	CountUsers() (int, error)
+	AddUser(user *repository.User) error
+	GetUser(id int) (repository.User, error)
+	GetUserByLogin(login string) (repository.User, error)
+	AddSession(session *repository.Session) error
+	GetSession(id string) (repository.Session, error)
+	DeleteSession(id string) error
+	GetSubscriptions(userID int) ([]int, error)
+	Subscribe(userID int, siteID int) error
+	Unsubscribe(userID int, siteID int) error
+	AddApiToken(token *repository.ApiToken) error
+	GetApiTokens(userID int) ([]repository.ApiToken, error)
+	GetApiTokenByHash(hash string) (repository.ApiToken, error)
+	DeleteApiToken(userID int, id int) error
+	TouchApiToken(id int, usedAt time.Time) error
+	GetNewsItem(id int) (repository.NewsItem, error)
+	MarkRead(userID int, newsID int) error
+	MarkUnread(userID int, newsID int) error
+	MarkReadUpTo(userID int, newsID int, filter repository.NewsFilter) error
+	GetReadMarks(userID int, newsIDs []int) ([]int, error)
+	GetUnreadCounts(userID int) ([]repository.UnreadCount, error)
+	AddBookmark(userID int, newsID int) error
+	DeleteBookmark(userID int, newsID int) error
+	GetBookmarks(userID int, newsIDs []int) ([]int, error)
+	GetTags(limit int) ([]repository.TagCount, error)
+	GetExpiredNews(policy repository.RetentionPolicy, limit int) ([]repository.NewsItem, error)
+	DeleteNews(ids []int) error
+	DeletePrunedLinksBefore(before time.Time) error
+	GetSiteFilters(siteID int) ([]repository.SiteFilter, error)
+	AddSiteFilter(filter *repository.SiteFilter) error
+	DeleteSiteFilter(siteID int, id int) error
+	GetAlertRules() ([]repository.AlertRule, error)
+	GetUserAlertRules(userID int) ([]repository.AlertRule, error)
+	AddAlertRule(rule *repository.AlertRule) error
+	DeleteAlertRule(userID int, id int) error
+	AddAlertDelivery(delivery *repository.AlertDelivery) error
+	GetAlertDeliveries(userID int, limit int) ([]repository.AlertDelivery, error)
+	GetDigests() ([]repository.Digest, error)
+	GetUserDigests(userID int) ([]repository.Digest, error)
+	AddDigest(digest *repository.Digest) error
+	DeleteDigest(userID int, id int) error
+	MarkDigestSent(id int, lastNewsID int, sentAt time.Time) error
+	GetWebSubSubscription(siteID int) (repository.WebSubSubscription, error)
+	GetWebSubSubscriptions() ([]repository.WebSubSubscription, error)
+	SaveWebSubSubscription(sub *repository.WebSubSubscription) error
+	DeleteWebSubSubscription(siteID int) error
+	GetStoryFingerprints(since time.Time) ([]repository.StoryFingerprint, error)
+	GetStorySources(storyIDs []int) ([]repository.StorySources, error)
 }
 
 type Parser interface {
-	Parse(site repository.Site) ([]repository.NewsItem, error)
+	Parse(site repository.Site) ([]repository.NewsItem, parser.FetchInfo, error)
+	ParseFeed(site repository.Site, body []byte) ([]repository.NewsItem, error)
+	ParseArticle(site repository.Site, link string) (parser.Article, error)
+}
+
+type Mailer interface {
+	Send(message mailer.Message) error
+}
+
+type Telegram interface {
+	Send(chatID string, message telegram.Message) error
 }
 
 type application struct {
-	log        *log.Logger
+	log        *logrus.Logger
 	repository Repository
 	parser     Parser
+	mailer     Mailer
+	telegram   Telegram
 	stop       chan bool
 	interval   time.Duration
 	port       int
 	templates  *template.Template
+	// textTemplates are the plain text templates, named *_text.tmpl, that are not html escaped.
+	textTemplates *texttemplate.Template
+
+	// readyIntervals is the number of parse intervals after which
+	// the application is considered not ready without a completed parse cycle.
+	readyIntervals int
+	// runsRetention is how long parse runs are kept in the repository.
+	runsRetention time.Duration
+	// newsRetention is how long news are kept, newsPerSite is the number of the newest news
+	// kept for every site, both keep news forever if zero. Starred news are kept if keepStarred is set.
+	// Expired news are pruned every pruneInterval and archived to archiveDir if it is set.
+	// Links of pruned news are kept for linksRetention, so feeds still listing them do not insert them again.
+	newsRetention  time.Duration
+	newsPerSite    int
+	keepStarred    bool
+	pruneInterval  time.Duration
+	archiveDir     string
+	linksRetention time.Duration
+	// allowRegister allows anyone to register, otherwise only the first user can.
+	allowRegister bool
+	// webhookClient delivers alerts and WebSub subscription requests,
+	// failed alert deliveries are retried after alertBackoff doubling each time.
+	// Webhook deliveries of alert matches are queued in alertQueue.
+	webhookClient *http.Client
+	alertBackoff  time.Duration
+	alertQueue    chan alertMatch
+	// telegramChats receive all new news, posts are queued in telegramQueue.
+	telegramChats []string
+	telegramQueue chan telegramPost
+	// events publishes inserted news to the event stream.
+	events *eventBus
+	// publicURL is the external address of the application used for WebSub callbacks,
+	// WebSub is disabled if empty.
+	publicURL string
+
+	mu        sync.Mutex
+	started   time.Time
+	migrated  bool
+	lastParse time.Time
+	// polled is when sites were last polled, sites pushed by a WebSub hub are polled less often.
+	polled map[int]time.Time
+}
+
+// Config holds application settings.
+type Config struct {
+	Port           int
+	Interval       time.Duration
+	ReadyIntervals int
+	RunsRetention  time.Duration
+	NewsRetention  time.Duration
+	NewsPerSite    int
+	KeepStarred    bool
+	PruneInterval  time.Duration
+	ArchiveDir     string
+	LinksRetention time.Duration
+	AllowRegister  bool
+	PublicURL      string
 }
 
-func NewApplication(repository Repository, parser Parser, logger *log.Logger, port int, interval time.Duration) *application {
+func NewApplication(repository Repository, parser Parser, logger *logrus.Logger, config Config) *application {
 	return &application{
-		log:        logger,
-		repository: repository,
-		parser:     parser,
-		stop:       make(chan bool),
-		interval:   interval,
-		port:       port,
+		log:            logger,
+		repository:     repository,
+		parser:         parser,
+		stop:           make(chan bool),
+		interval:       config.Interval,
+		port:           config.Port,
+		readyIntervals: config.ReadyIntervals,
+		runsRetention:  config.RunsRetention,
+		newsRetention:  config.NewsRetention,
+		newsPerSite:    config.NewsPerSite,
+		keepStarred:    config.KeepStarred,
+		pruneInterval:  config.PruneInterval,
+		archiveDir:     config.ArchiveDir,
+		linksRetention: config.LinksRetention,
+		allowRegister:  config.AllowRegister,
+		webhookClient:  &http.Client{Timeout: 10 * time.Second},
+		alertBackoff:   time.Second,
+		alertQueue:     make(chan alertMatch, alertQueueSize),
+		events:         newEventBus(),
+		publicURL:      strings.TrimSuffix(config.PublicURL, "/"),
 	}
 }
 
 func (app *application) Serve() {
-	app.repository.Migrate()
+	app.mu.Lock()
+	app.started = time.Now()
+	app.mu.Unlock()
+
+	if err := app.repository.Migrate(); err != nil {
+		app.log.WithError(err).Error("Failed migrate repository")
+	} else {
+		app.mu.Lock()
+		app.migrated = true
+		app.mu.Unlock()
+	}
+	app.prepareTemplates()
 	app.parsing()
+	app.digesting()
+	app.pruning()
+	app.delivering()
+	app.notifying()
 	app.serveHttp()
 }
 
@@ -73,70 +234,238 @@ func (app *application) parsing() {
 }
 
 func (app *application) parseSites() {
+	start := time.Now()
+	defer func() {
+		parseCycleDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	sites, err := app.repository.GetSites()
 	if err != nil {
-		app.log.Printf("Failed get sites from repository: %v", err)
+		app.log.WithError(err).Error("Failed get sites from repository")
 
 		return
 	}
 
+	pushed := app.pushedSites()
+	var inserted []repository.NewsItem
 	for _, site := range sites {
-		news, err := app.parser.Parse(site)
-		if err != nil {
-			app.log.Printf("Failed parse site %s: %v", site.Url, err)
+		if pushed[site.ID] && !app.pollDue(site.ID, start) {
 			continue
 		}
+		app.setPolled(site.ID, start)
+		inserted = append(inserted, app.parseSite(site)...)
+	}
+	app.alert(inserted)
+	app.broadcast(inserted)
+
+	if app.runsRetention > 0 {
+		err = app.repository.DeleteParseRunsBefore(time.Now().Add(-app.runsRetention))
+		if err != nil {
+			app.log.WithError(err).Error("Failed delete old parse runs from repository")
+		}
+	}
+
+	app.mu.Lock()
+	app.lastParse = time.Now()
+	app.mu.Unlock()
+}
+
+// parseSite parses news of the site, inserts new ones and records the parse run.
+// It returns the inserted news.
+func (app *application) parseSite(site repository.Site) (inserted []repository.NewsItem) {
+	siteID := strconv.Itoa(site.ID)
+	siteLog := app.log.WithFields(logrus.Fields{"site_id": site.ID, "url": site.Url})
+	run := &repository.ParseRun{SiteID: site.ID, StartedAt: time.Now()}
+	defer func() {
+		run.FinishedAt = time.Now()
+		if err := app.repository.AddParseRun(run); err != nil {
+			siteLog.WithError(err).Error("Failed add parse run to repository")
+		}
+	}()
+
+	news, info, err := app.parser.Parse(site)
+	duration := time.Since(run.StartedAt)
+	run.StatusCode = info.StatusCode
+	run.Bytes = info.Bytes
+	siteFetchDuration.WithLabelValues(siteID).Observe(duration.Seconds())
+	siteFetchStatus.WithLabelValues(siteID, fetchStatus(info.StatusCode)).Inc()
+	if err != nil {
+		run.Error = err.Error()
+		siteParseErrors.WithLabelValues(siteID).Inc()
+		siteLog.WithError(err).WithField("duration", duration).Error("Failed parse site")
+
+		return
+	}
+	run.ItemsFound = len(news)
+	siteItemsFound.WithLabelValues(siteID).Add(float64(len(news)))
+
+	found := len(news)
+	news, run.Filtered, err = app.filterSiteNews(site, news)
+	if err != nil {
+		run.Error = err.Error()
+		siteLog.WithError(err).Error("Failed get site filters from repository")
+
+		return
+	}
+
+	inserted = app.insertNews(site, news, siteLog)
+	run.Inserted = len(inserted)
+
+	siteLog.WithFields(logrus.Fields{
+		"duration": duration,
+		"found":    found,
+		"filtered": run.Filtered,
+		"inserted": len(inserted),
+	}).Info("Complete parse site")
+
+	if info.Hub != "" {
+		app.subscribeWebSub(site, info, siteLog)
+	}
+
+	return
+}
 
-		insert := 0
-		for _, item := range news {
-			item.SiteID = site.ID
-			exist, err := app.repository.HasNewsItem(item)
+// insertNews inserts news of the site missing in the repository, grouping them into stories,
+// and publishes them to the event stream. It returns the inserted news.
+func (app *application) insertNews(site repository.Site, news []repository.NewsItem, siteLog *logrus.Entry) (inserted []repository.NewsItem) {
+	stories := app.newStoryMatcher()
+	now := time.Now()
+	for _, item := range news {
+		item.SiteID = site.ID
+		if app.expired(item, now) {
+			continue
+		}
+		exist, err := app.repository.HasNewsItem(item)
+		if err != nil {
+			siteLog.WithError(err).WithField("link", item.Link).Error("Failed to check exist news in repository")
+			continue
+		}
+		if exist {
+			continue
+		}
+		if site.FetchContent {
+			exist, err = app.fetchContent(site, &item)
 			if err != nil {
-				app.log.Printf("Failed to check exist news in repository: %v", err)
-				continue
+				siteLog.WithError(err).WithField("link", item.Link).Warn("Failed fetch news content")
 			}
 			if exist {
 				continue
 			}
-			err = app.repository.AddNewsItem(&item)
-			if err != nil {
-				app.log.Printf("Failed add news to repository: %v", err)
-				continue
-			}
-			insert++
 		}
-
-		app.log.Printf("Complete parse site %s - add %d news", site.Url, insert)
+		item.Tags = append(item.Tags, repository.SplitTags(site.DefaultTags)...)
+		err = stories.assign(&item)
+		if err != nil {
+			siteLog.WithError(err).WithField("link", item.Link).Error("Failed get story fingerprints from repository")
+		}
+		err = app.repository.AddNewsItem(&item)
+		if err != nil {
+			siteLog.WithError(err).WithField("link", item.Link).Error("Failed add news to repository")
+			continue
+		}
+		stories.add(item)
+		inserted = append(inserted, item)
+		app.events.publish(item)
 	}
+	siteItemsInserted.WithLabelValues(strconv.Itoa(site.ID)).Add(float64(len(inserted)))
+
+	return
 }
 
 func (app *application) prepareTemplates() {
-	var allFiles []string
+	var htmlFiles, textFiles []string
 	files, err := ioutil.ReadDir("./tmpl")
 	if err != nil {
-		app.log.Printf("Fail read tmpl dir: %v", err)
+		app.log.WithError(err).Error("Fail read tmpl dir")
 
 		return
 	}
 
 	for _, file := range files {
-		allFiles = append(allFiles, "./tmpl/"+file.Name())
+		if strings.HasSuffix(file.Name(), "_text.tmpl") {
+			textFiles = append(textFiles, "./tmpl/"+file.Name())
+		} else {
+			htmlFiles = append(htmlFiles, "./tmpl/"+file.Name())
+		}
 	}
-	app.templates, err = template.ParseFiles(allFiles...)
+	app.templates, err = template.ParseFiles(htmlFiles...)
 	if err != nil {
-		app.log.Printf("Fail parse templates: %v", err)
+		app.log.WithError(err).Error("Fail parse templates")
+	}
+	if len(textFiles) > 0 {
+		app.textTemplates, err = texttemplate.ParseFiles(textFiles...)
+		if err != nil {
+			app.log.WithError(err).Error("Fail parse text templates")
+		}
 	}
 }
 
-func (app *application) serveHttp() {
-	app.prepareTemplates()
+// render executes the named template, failing instead of panicking when templates are not loaded.
+func (app *application) render(res io.Writer, name string, data interface{}) error {
+	if strings.HasSuffix(name, "_text.tmpl") {
+		if app.textTemplates == nil {
+			return fmt.Errorf("templates are not loaded")
+		}
+		tmpl := app.textTemplates.Lookup(name)
+		if tmpl == nil {
+			return fmt.Errorf("template %s not found", name)
+		}
+
+		return tmpl.Execute(res, data)
+	}
+
+	if app.templates == nil {
+		return fmt.Errorf("templates are not loaded")
+	}
+	tmpl := app.templates.Lookup(name)
+	if tmpl == nil {
+		return fmt.Errorf("template %s not found", name)
+	}
+
+	return tmpl.Execute(res, data)
+}
 
-	http.HandleFunc("/", app.mainHandler)
-	http.HandleFunc("/sites", app.sitesHandler)
-	http.HandleFunc("/sites/add", app.siteAddHandler)
-	http.HandleFunc("/sites/delete", app.siteDeleteHandler)
+// handle registers handler wrapped with request logging, metrics, csrf protection and authentication.
+func (app *application) handle(pattern string, name string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, app.logRequests(app.instrument(name, app.csrf(app.authenticate(handler)))))
+}
 
-	app.log.Printf("Run server listen port :%d", app.port)
+func (app *application) serveHttp() {
+	app.handle("/", "main", app.mainHandler)
+	app.handle("/sites", "sites", app.sitesHandler)
+	app.handle("/sites/", "site", app.siteHandler)
+	app.handle("/sites/add", "site_add", app.requireAdmin(app.siteAddHandler))
+	app.handle("/sites/delete", "site_delete", app.requireAdmin(app.siteDeleteHandler))
+	app.handle("/sites/filters", "site_filters", app.requireAdmin(app.siteFiltersHandler))
+	app.handle("/sites/filters/delete", "site_filter_delete", app.requireAdmin(app.siteFilterDeleteHandler))
+	app.handle("/sites/subscription", "site_subscription", app.requireUser(app.subscriptionHandler))
+	app.handle("/login", "login", app.loginHandler)
+	app.handle("/register", "register", app.registerHandler)
+	app.handle("/logout", "logout", app.logoutHandler)
+	app.handle("/events", "events", app.eventsHandler)
+	app.handle("/go/", "go", app.goHandler)
+	app.handle("/news/", "news_item", app.newsItemHandler)
+	app.handle("/tags", "tags", app.tagsHandler)
+	app.handle("/news/read", "news_read", app.requireUserOrScope(repository.ScopeWrite, app.readHandler))
+	app.handle("/news/star", "news_star", app.requireUserOrScope(repository.ScopeWrite, app.starHandler))
+	app.handle("/starred", "starred", app.requireUser(app.starredHandler))
+	app.handle("/starred/rss", "starred_rss", app.requireFeedScope(repository.ScopeRead, app.starredFeedHandler))
+	app.handle("/alerts", "alerts", app.requireUser(app.alertsHandler))
+	app.handle("/alerts/delete", "alert_delete", app.requireUser(app.alertDeleteHandler))
+	app.handle("/digests", "digests", app.requireUser(app.digestsHandler))
+	app.handle("/digests/delete", "digest_delete", app.requireUser(app.digestDeleteHandler))
+	app.handle("/tokens", "tokens", app.requireUser(app.tokensHandler))
+	app.handle("/tokens/revoke", "token_revoke", app.requireUser(app.tokenRevokeHandler))
+	app.handle("/api/news", "api_news", app.requireScope(repository.ScopeRead, app.apiNewsHandler))
+	app.handle("/api/export", "api_export", app.requireScope(repository.ScopeRead, app.apiExportHandler))
+	app.handle("/api/sites", "api_sites", app.apiSitesHandler)
+	app.handle("/api/sites/", "api_site", app.requireScope(repository.ScopeAdmin, app.apiSiteHandler))
+	// hubs post to the callback without csrf token and session
+	http.HandleFunc("/websub/", app.logRequests(app.instrument("websub", app.websubHandler)))
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", app.healthHandler)
+	http.HandleFunc("/readyz", app.readyHandler)
+
+	app.log.WithField("port", app.port).Info("Run server")
 	app.log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", app.port), nil))
 }
 
@@ -148,36 +477,128 @@ func (app *application) mainHandler(res http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	search := req.URL.Query().Get("q")
-	page, err := strconv.Atoi(req.URL.Query().Get("page"))
-	if err != nil {
-		page = 1
+	user := app.user(req)
+	filter := newsFilterFromQuery(req.URL.Query())
+	var subscriptions []int
+	if user != nil {
+		var err error
+		subscriptions, err = app.repository.GetSubscriptions(user.ID)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get subscriptions from repository")
+
+			return
+		}
+		filter = userNewsFilter(filter, req.URL.Query(), user)
 	}
+	// the main page shows every story once unless the story is opened
+	filter.Collapse = filter.Story == 0
+
+	app.renderNews(res, req, "Новости", filter, subscriptions)
+}
+
+// renderNews renders a page of news matching the filter restricted to the subscriptions.
+func (app *application) renderNews(res http.ResponseWriter, req *http.Request, title string, filter repository.NewsFilter, subscriptions []int) {
+	user := app.user(req)
+	cursor := newsCursorFromQuery(req.URL.Query())
 	perpage := 10
-	news, err := app.repository.GetNews((page-1)*perpage, perpage, search)
+	page, err := app.repository.GetNews(cursor, perpage, restrictToSites(filter, subscriptions))
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+		return
+	}
+
+	sites, err := app.repository.GetSites()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get sites from repository")
+
+		return
+	}
+
+	rows, err := app.newsRows(user, page.Items)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail get news from repository: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail get read marks from repository")
 
 		return
 	}
+	if filter.Collapse {
+		sources, err := app.storySources(page.Items)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get story sources from repository")
+
+			return
+		}
+		for i := range rows {
+			rows[i].Sources = sources[rows[i].Story()]
+		}
+	}
+	for i := range rows {
+		rows[i].Permalink = newsItemLink(rows[i].NewsItem, req.URL.RequestURI())
+	}
+
+	type siteOption struct {
+		ID       int
+		Url      string
+		Selected bool
+	}
+	selected := make(map[int]bool, len(filter.SiteIDs))
+	for _, id := range filter.SiteIDs {
+		selected[id] = true
+	}
+	subscribed := make(map[int]bool, len(subscriptions))
+	for _, id := range subscriptions {
+		subscribed[id] = true
+	}
+	options := make([]siteOption, 0, len(sites))
+	for _, site := range sites {
+		if len(subscriptions) > 0 && !subscribed[site.ID] {
+			continue
+		}
+		options = append(options, siteOption{site.ID, site.Url, selected[site.ID]})
+	}
+	query := newsFilterQuery(filter)
+	var prevQuery, nextQuery template.URL
+	if page.HasPrev {
+		prevQuery = template.URL(newsPageQuery(query, page.PrevCursor()))
+	}
+	if page.HasNext {
+		nextQuery = template.URL(newsPageQuery(query, page.NextCursor()))
+	}
 
-	tmpl := app.templates.Lookup("main.tmpl")
-	err = tmpl.Execute(
+	err = app.render(
 		res,
+		"main.tmpl",
 		struct {
-			NewsItems []repository.NewsItem
+			CSRF      string
+			User      *repository.User
+			Title     string
+			Path      string
+			Current   string
+			NewsItems []newsRow
+			Total     int
 			Search    string
-			Page      int
-			PrevPage  int
-			NextPage  int
+			Tag       string
+			Sites     []siteOption
+			From      string
+			To        string
+			HasImage  bool
+			Unread    bool
+			Query     string
+			PrevQuery template.URL
+			NextQuery template.URL
 		}{
-			news, search, page, page - 1, page + 1,
+			app.csrfToken(req), user, title, req.URL.Path, req.URL.RequestURI(), rows, page.Total, filter.Search, filter.Tag, options,
+			query.Get("from"), query.Get("to"), filter.HasImage, filter.UnreadBy > 0, query.Encode(), prevQuery, nextQuery,
 		},
 	)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail execute template: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
 
 		return
 	}
@@ -188,21 +609,148 @@ func (app *application) sitesHandler(res http.ResponseWriter, req *http.Request)
 	sites, err := app.repository.GetSites()
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail get sites from repository: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail get sites from repository")
+
+		return
+	}
+
+	type siteRow struct {
+		repository.Site
+		Subscribed bool
+		Unread     int
+	}
+	user := app.user(req)
+	subscribed := map[int]bool{}
+	unread := map[int]int{}
+	if user != nil {
+		subscriptions, err := app.repository.GetSubscriptions(user.ID)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get subscriptions from repository")
+
+			return
+		}
+		for _, id := range subscriptions {
+			subscribed[id] = true
+		}
+
+		counts, err := app.repository.GetUnreadCounts(user.ID)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get unread counts from repository")
+
+			return
+		}
+		for _, count := range counts {
+			unread[count.SiteID] = count.Count
+		}
+	}
+	rows := make([]siteRow, 0, len(sites))
+	for _, site := range sites {
+		rows = append(rows, siteRow{site, subscribed[site.ID], unread[site.ID]})
+	}
+
+	err = app.render(
+		res,
+		"sites.tmpl",
+		struct {
+			CSRF  string
+			User  *repository.User
+			Sites []siteRow
+		}{
+			app.csrfToken(req), user, rows,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+func (app *application) siteHandler(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/sites/"))
+	if err != nil {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	}
+
+	site, err := app.repository.GetSite(id)
+	if err == repository.ErrNotFound {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get site from repository")
+
+		return
+	}
+
+	runs, err := app.repository.GetParseRuns(id, 50)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get parse runs from repository")
+
+		return
+	}
+
+	stats, err := app.repository.GetDailyStats(id, time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get daily stats from repository")
 
 		return
 	}
 
-	tmpl := app.templates.Lookup("sites.tmpl")
-	err = tmpl.Execute(
+	type dayBar struct {
+		Day      string
+		Inserted int
+		Height   int
+	}
+	var bars []dayBar
+	var totalRuns int
+	var totalDuration, maxInserted float64
+	for _, stat := range stats {
+		totalRuns += stat.Runs
+		totalDuration += stat.AvgDuration * float64(stat.Runs)
+		if float64(stat.Inserted) > maxInserted {
+			maxInserted = float64(stat.Inserted)
+		}
+	}
+	for _, stat := range stats {
+		bar := dayBar{Day: stat.Day.Format("02.01"), Inserted: stat.Inserted}
+		if maxInserted > 0 {
+			bar.Height = int(float64(stat.Inserted) / maxInserted * 100)
+		}
+		bars = append(bars, bar)
+	}
+	var avgDuration float64
+	if totalRuns > 0 {
+		avgDuration = totalDuration / float64(totalRuns)
+	}
+
+	err = app.render(
 		res,
-		struct{ Sites []repository.Site }{
-			sites,
+		"site.tmpl",
+		struct {
+			User        *repository.User
+			Site        repository.Site
+			Runs        []repository.ParseRun
+			Days        []dayBar
+			AvgDuration string
+		}{
+			app.user(req), site, runs, bars, fmt.Sprintf("%.2f", avgDuration),
 		},
 	)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail execute template: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
 
 		return
 	}
@@ -226,7 +774,7 @@ func (app *application) siteDeleteHandler(res http.ResponseWriter, req *http.Req
 	err = app.repository.DeleteSite(id)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail delete site from repository: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail delete site from repository")
 
 		return
 	}
@@ -234,6 +782,52 @@ func (app *application) siteDeleteHandler(res http.ResponseWriter, req *http.Req
 	http.Redirect(res, req, "/sites", http.StatusTemporaryRedirect)
 }
 
+// siteForm is the data of the add site page.
+type siteForm struct {
+	CSRF    string
+	Site    repository.Site
+	Errors  map[string]string
+	Sources []siteSourceForm
+}
+
+// siteSourceForm is the form adding a site of a source type, Active is set for the submitted one.
+type siteSourceForm struct {
+	Type   string
+	Title  string
+	Active bool
+	Fields []siteSourceField
+}
+
+// siteSourceField is a setting of the source type with its submitted value and error.
+type siteSourceField struct {
+	parser.ConfigField
+	Value string
+	Error string
+}
+
+// newSiteForm renders the forms of all source types, filled for the type of the submitted site.
+func newSiteForm(csrf string, site repository.Site, errors map[string]string) siteForm {
+	form := siteForm{CSRF: csrf, Site: site, Errors: errors}
+	for _, source := range parser.Sources() {
+		sourceForm := siteSourceForm{
+			Type:   source.Type(),
+			Title:  source.Title(),
+			Active: errors != nil && source.Type() == site.Type,
+		}
+		for _, field := range source.Schema() {
+			formField := siteSourceField{ConfigField: field}
+			if sourceForm.Active {
+				formField.Value = site.Option(field.Name)
+				formField.Error = errors[field.Name]
+			}
+			sourceForm.Fields = append(sourceForm.Fields, formField)
+		}
+		form.Sources = append(form.Sources, sourceForm)
+	}
+
+	return form
+}
+
 func (app *application) siteAddHandler(res http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodPost {
 		app.siteCreateHandler(res, req)
@@ -241,11 +835,10 @@ func (app *application) siteAddHandler(res http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	tmpl := app.templates.Lookup("site_add.tmpl")
-	err := tmpl.Execute(res, nil)
+	err := app.render(res, "site_add.tmpl", newSiteForm(app.csrfToken(req), repository.Site{}, nil))
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail execute template: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
 
 		return
 	}
@@ -255,19 +848,32 @@ func (app *application) siteAddHandler(res http.ResponseWriter, req *http.Reques
 
 func (app *application) siteCreateHandler(res http.ResponseWriter, req *http.Request) {
 	site := &repository.Site{}
-	site.IsRss, _ = strconv.ParseBool(req.FormValue("is_rss"))
+	site.Type = req.FormValue("type")
 	site.Url = req.FormValue("url")
-	site.NewsItemPath = req.FormValue("news_item_path")
-	site.TitlePath = req.FormValue("title_path")
-	site.LinkPath = req.FormValue("link_path")
-	site.DescriptionPath = req.FormValue("description_path")
-	site.DatePath = req.FormValue("date_path")
-	site.ImagePath = req.FormValue("image_path")
+	if source, ok := parser.Source(site.Type); ok {
+		for _, field := range source.Schema() {
+			site.SetOption(field.Name, strings.TrimSpace(req.FormValue(field.Name)))
+		}
+	}
+	site.DefaultTags = strings.Join(repository.SplitTags(req.FormValue("default_tags")), ", ")
+	site.FetchContent = req.FormValue("fetch_content") == "1"
+	site.ContentPath = req.FormValue("content_path")
+	site.Processors = strings.TrimSpace(req.FormValue("processors"))
+
+	if errors := validateSite(*site); len(errors) > 0 {
+		res.WriteHeader(http.StatusBadRequest)
+		err := app.render(res, "site_add.tmpl", newSiteForm(app.csrfToken(req), *site, errors))
+		if err != nil {
+			app.requestLog(req).WithError(err).Error("Fail execute template")
+		}
+
+		return
+	}
 
 	err := app.repository.AddSite(site)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		app.log.Printf("Fail insert site to repository: %v", err)
+		app.requestLog(req).WithError(err).Error("Fail insert site to repository")
 
 		return
 	}
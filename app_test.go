@@ -2,11 +2,15 @@ package main
 
 import (
 	"errors"
+	"github.com/onauryzbaev/go_news_final_/mailer"
+	"github.com/onauryzbaev/go_news_final_/parser"
 	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/telegram"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -18,18 +22,38 @@ type mockedParser struct {
 	mock.Mock
 }
 
-func (pars *mockedParser) Parse(site repository.Site) ([]repository.NewsItem, error) {
+func (pars *mockedParser) Parse(site repository.Site) ([]repository.NewsItem, parser.FetchInfo, error) {
 	args := pars.MethodCalled("Parse", site)
 
+	return args.Get(0).([]repository.NewsItem), args.Get(1).(parser.FetchInfo), args.Error(2)
+}
+
+func (pars *mockedParser) ParseFeed(site repository.Site, body []byte) ([]repository.NewsItem, error) {
+	args := pars.MethodCalled("ParseFeed", site, body)
+
 	return args.Get(0).([]repository.NewsItem), args.Error(1)
 }
 
+func (pars *mockedParser) ParseArticle(site repository.Site, link string) (parser.Article, error) {
+	args := pars.MethodCalled("ParseArticle", site, link)
+
+	return args.Get(0).(parser.Article), args.Error(1)
+}
+
 type mockedRepository struct {
 	mock.Mock
 }
 
-func (rep *mockedRepository) Migrate() {
-	rep.MethodCalled("Migrate")
+func (rep *mockedRepository) Migrate() error {
+	args := rep.MethodCalled("Migrate")
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) Ping() error {
+	args := rep.MethodCalled("Ping")
+
+	return args.Error(0)
 }
 
 func (rep *mockedRepository) GetSites() ([]repository.Site, error) {
@@ -38,6 +62,12 @@ func (rep *mockedRepository) GetSites() ([]repository.Site, error) {
 	return args.Get(0).([]repository.Site), args.Error(1)
 }
 
+func (rep *mockedRepository) GetSite(id int) (repository.Site, error) {
+	args := rep.MethodCalled("GetSite", id)
+
+	return args.Get(0).(repository.Site), args.Error(1)
+}
+
 func (rep *mockedRepository) AddSite(site *repository.Site) error {
 	args := rep.MethodCalled("AddSite", site)
 
@@ -50,10 +80,10 @@ func (rep *mockedRepository) DeleteSite(id int) error {
 	return args.Error(0)
 }
 
-func (rep *mockedRepository) GetNews(offset int, limit int, search string) ([]repository.NewsItem, error) {
-	args := rep.MethodCalled("GetNews", offset, limit, search)
+func (rep *mockedRepository) GetNews(cursor repository.NewsCursor, limit int, filter repository.NewsFilter) (repository.NewsPage, error) {
+	args := rep.MethodCalled("GetNews", cursor, limit, filter)
 
-	return args.Get(0).([]repository.NewsItem), args.Error(1)
+	return args.Get(0).(repository.NewsPage), args.Error(1)
 }
 
 func (rep *mockedRepository) HasNewsItem(item repository.NewsItem) (bool, error) {
@@ -68,12 +98,353 @@ func (rep *mockedRepository) AddNewsItem(item *repository.NewsItem) error {
 	return args.Error(0)
 }
 
+func (rep *mockedRepository) AddParseRun(run *repository.ParseRun) error {
+	args := rep.MethodCalled("AddParseRun", run)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetParseRuns(siteID int, limit int) ([]repository.ParseRun, error) {
+	args := rep.MethodCalled("GetParseRuns", siteID, limit)
+
+	return args.Get(0).([]repository.ParseRun), args.Error(1)
+}
+
+func (rep *mockedRepository) GetDailyStats(siteID int, since time.Time) ([]repository.DailyStat, error) {
+	args := rep.MethodCalled("GetDailyStats", siteID, since)
+
+	return args.Get(0).([]repository.DailyStat), args.Error(1)
+}
+
+func (rep *mockedRepository) DeleteParseRunsBefore(before time.Time) error {
+	args := rep.MethodCalled("DeleteParseRunsBefore", before)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) CountUsers() (int, error) {
+	args := rep.MethodCalled("CountUsers")
+
+	return args.Int(0), args.Error(1)
+}
+
+func (rep *mockedRepository) AddUser(user *repository.User) error {
+	args := rep.MethodCalled("AddUser", user)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetUser(id int) (repository.User, error) {
+	args := rep.MethodCalled("GetUser", id)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (rep *mockedRepository) GetUserByLogin(login string) (repository.User, error) {
+	args := rep.MethodCalled("GetUserByLogin", login)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (rep *mockedRepository) AddSession(session *repository.Session) error {
+	args := rep.MethodCalled("AddSession", session)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetSession(id string) (repository.Session, error) {
+	args := rep.MethodCalled("GetSession", id)
+
+	return args.Get(0).(repository.Session), args.Error(1)
+}
+
+func (rep *mockedRepository) DeleteSession(id string) error {
+	args := rep.MethodCalled("DeleteSession", id)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetSubscriptions(userID int) ([]int, error) {
+	args := rep.MethodCalled("GetSubscriptions", userID)
+
+	return args.Get(0).([]int), args.Error(1)
+}
+
+func (rep *mockedRepository) Subscribe(userID int, siteID int) error {
+	args := rep.MethodCalled("Subscribe", userID, siteID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) Unsubscribe(userID int, siteID int) error {
+	args := rep.MethodCalled("Unsubscribe", userID, siteID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) AddApiToken(token *repository.ApiToken) error {
+	args := rep.MethodCalled("AddApiToken", token)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetApiTokens(userID int) ([]repository.ApiToken, error) {
+	args := rep.MethodCalled("GetApiTokens", userID)
+
+	return args.Get(0).([]repository.ApiToken), args.Error(1)
+}
+
+func (rep *mockedRepository) GetApiTokenByHash(hash string) (repository.ApiToken, error) {
+	args := rep.MethodCalled("GetApiTokenByHash", hash)
+
+	return args.Get(0).(repository.ApiToken), args.Error(1)
+}
+
+func (rep *mockedRepository) DeleteApiToken(userID int, id int) error {
+	args := rep.MethodCalled("DeleteApiToken", userID, id)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) TouchApiToken(id int, usedAt time.Time) error {
+	args := rep.MethodCalled("TouchApiToken", id, usedAt)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetNewsItem(id int) (repository.NewsItem, error) {
+	args := rep.MethodCalled("GetNewsItem", id)
+
+	return args.Get(0).(repository.NewsItem), args.Error(1)
+}
+
+func (rep *mockedRepository) MarkRead(userID int, newsID int) error {
+	args := rep.MethodCalled("MarkRead", userID, newsID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) MarkUnread(userID int, newsID int) error {
+	args := rep.MethodCalled("MarkUnread", userID, newsID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) MarkReadUpTo(userID int, newsID int, filter repository.NewsFilter) error {
+	args := rep.MethodCalled("MarkReadUpTo", userID, newsID, filter)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetReadMarks(userID int, newsIDs []int) ([]int, error) {
+	args := rep.MethodCalled("GetReadMarks", userID, newsIDs)
+
+	return args.Get(0).([]int), args.Error(1)
+}
+
+func (rep *mockedRepository) GetUnreadCounts(userID int) ([]repository.UnreadCount, error) {
+	args := rep.MethodCalled("GetUnreadCounts", userID)
+
+	return args.Get(0).([]repository.UnreadCount), args.Error(1)
+}
+
+func (rep *mockedRepository) AddBookmark(userID int, newsID int) error {
+	args := rep.MethodCalled("AddBookmark", userID, newsID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteBookmark(userID int, newsID int) error {
+	args := rep.MethodCalled("DeleteBookmark", userID, newsID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetBookmarks(userID int, newsIDs []int) ([]int, error) {
+	args := rep.MethodCalled("GetBookmarks", userID, newsIDs)
+
+	return args.Get(0).([]int), args.Error(1)
+}
+
+func (rep *mockedRepository) GetTags(limit int) ([]repository.TagCount, error) {
+	args := rep.MethodCalled("GetTags", limit)
+
+	return args.Get(0).([]repository.TagCount), args.Error(1)
+}
+
+func (rep *mockedRepository) GetNewsAfter(afterID int, limit int, filter repository.NewsFilter) ([]repository.NewsItem, error) {
+	args := rep.MethodCalled("GetNewsAfter", afterID, limit, filter)
+
+	return args.Get(0).([]repository.NewsItem), args.Error(1)
+}
+
+func (rep *mockedRepository) GetExpiredNews(policy repository.RetentionPolicy, limit int) ([]repository.NewsItem, error) {
+	args := rep.MethodCalled("GetExpiredNews", policy, limit)
+
+	return args.Get(0).([]repository.NewsItem), args.Error(1)
+}
+
+func (rep *mockedRepository) DeletePrunedLinksBefore(before time.Time) error {
+	args := rep.MethodCalled("DeletePrunedLinksBefore", before)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteNews(ids []int) error {
+	args := rep.MethodCalled("DeleteNews", ids)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetSiteFilters(siteID int) ([]repository.SiteFilter, error) {
+	args := rep.MethodCalled("GetSiteFilters", siteID)
+
+	return args.Get(0).([]repository.SiteFilter), args.Error(1)
+}
+
+func (rep *mockedRepository) AddSiteFilter(filter *repository.SiteFilter) error {
+	args := rep.MethodCalled("AddSiteFilter", filter)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteSiteFilter(siteID int, id int) error {
+	args := rep.MethodCalled("DeleteSiteFilter", siteID, id)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetAlertRules() ([]repository.AlertRule, error) {
+	args := rep.MethodCalled("GetAlertRules")
+
+	return args.Get(0).([]repository.AlertRule), args.Error(1)
+}
+
+func (rep *mockedRepository) GetUserAlertRules(userID int) ([]repository.AlertRule, error) {
+	args := rep.MethodCalled("GetUserAlertRules", userID)
+
+	return args.Get(0).([]repository.AlertRule), args.Error(1)
+}
+
+func (rep *mockedRepository) AddAlertRule(rule *repository.AlertRule) error {
+	args := rep.MethodCalled("AddAlertRule", rule)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteAlertRule(userID int, id int) error {
+	args := rep.MethodCalled("DeleteAlertRule", userID, id)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) AddAlertDelivery(delivery *repository.AlertDelivery) error {
+	args := rep.MethodCalled("AddAlertDelivery", delivery)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetAlertDeliveries(userID int, limit int) ([]repository.AlertDelivery, error) {
+	args := rep.MethodCalled("GetAlertDeliveries", userID, limit)
+
+	return args.Get(0).([]repository.AlertDelivery), args.Error(1)
+}
+
+func (rep *mockedRepository) GetDigests() ([]repository.Digest, error) {
+	args := rep.MethodCalled("GetDigests")
+
+	return args.Get(0).([]repository.Digest), args.Error(1)
+}
+
+func (rep *mockedRepository) GetUserDigests(userID int) ([]repository.Digest, error) {
+	args := rep.MethodCalled("GetUserDigests", userID)
+
+	return args.Get(0).([]repository.Digest), args.Error(1)
+}
+
+func (rep *mockedRepository) AddDigest(digest *repository.Digest) error {
+	args := rep.MethodCalled("AddDigest", digest)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteDigest(userID int, id int) error {
+	args := rep.MethodCalled("DeleteDigest", userID, id)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) MarkDigestSent(id int, lastNewsID int, sentAt time.Time) error {
+	args := rep.MethodCalled("MarkDigestSent", id, lastNewsID, sentAt)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetWebSubSubscription(siteID int) (repository.WebSubSubscription, error) {
+	args := rep.MethodCalled("GetWebSubSubscription", siteID)
+
+	return args.Get(0).(repository.WebSubSubscription), args.Error(1)
+}
+
+func (rep *mockedRepository) GetWebSubSubscriptions() ([]repository.WebSubSubscription, error) {
+	args := rep.MethodCalled("GetWebSubSubscriptions")
+
+	return args.Get(0).([]repository.WebSubSubscription), args.Error(1)
+}
+
+func (rep *mockedRepository) SaveWebSubSubscription(sub *repository.WebSubSubscription) error {
+	args := rep.MethodCalled("SaveWebSubSubscription", sub)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) DeleteWebSubSubscription(siteID int) error {
+	args := rep.MethodCalled("DeleteWebSubSubscription", siteID)
+
+	return args.Error(0)
+}
+
+func (rep *mockedRepository) GetStoryFingerprints(since time.Time) ([]repository.StoryFingerprint, error) {
+	args := rep.MethodCalled("GetStoryFingerprints", since)
+
+	return args.Get(0).([]repository.StoryFingerprint), args.Error(1)
+}
+
+func (rep *mockedRepository) GetStorySources(storyIDs []int) ([]repository.StorySources, error) {
+	args := rep.MethodCalled("GetStorySources", storyIDs)
+
+	return args.Get(0).([]repository.StorySources), args.Error(1)
+}
+
+type mockedMailer struct {
+	mock.Mock
+}
+
+func (m *mockedMailer) Send(message mailer.Message) error {
+	args := m.MethodCalled("Send", message)
+
+	return args.Error(0)
+}
+
+type mockedTelegram struct {
+	mock.Mock
+}
+
+func (bot *mockedTelegram) Send(chatID string, message telegram.Message) error {
+	args := bot.MethodCalled("Send", chatID, message)
+
+	return args.Error(0)
+}
+
 func getApplication() *application {
 	return &application{
-		log:        log.New(ioutil.Discard, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
+		log:        &logrus.Logger{Out: ioutil.Discard, Formatter: new(logrus.TextFormatter), Level: logrus.InfoLevel},
 		repository: new(mockedRepository),
 		parser:     new(mockedParser),
 		stop:       make(chan bool),
+		events:     newEventBus(),
+
+		readyIntervals: 3,
 	}
 }
 
@@ -81,9 +452,9 @@ func TestParsing(t *testing.T) {
 	app := getApplication()
 	app.interval = time.Millisecond * 200
 
-	site1 := repository.Site{ID: 1, Url: "http://test1.ru", IsRss: true}
-	site2 := repository.Site{ID: 2, Url: "http://test2.ru", IsRss: false}
-	site3 := repository.Site{ID: 3, Url: "http://test3.ru", IsRss: false}
+	site1 := repository.Site{ID: 1, Url: "http://test1.ru", Type: parser.TypeRss}
+	site2 := repository.Site{ID: 2, Url: "http://test2.ru", Type: parser.TypeHtml}
+	site3 := repository.Site{ID: 3, Url: "http://test3.ru", Type: parser.TypeHtml}
 
 	news1 := []repository.NewsItem{
 		repository.NewsItem{Link: "http://test1.ru/news/1"},
@@ -96,17 +467,23 @@ func TestParsing(t *testing.T) {
 
 	app.parser.(*mockedParser).
 		On("Parse", site1).
-		Return(news1, nil)
+		Return(news1, parser.FetchInfo{StatusCode: http.StatusOK, Bytes: 100}, nil)
 	app.parser.(*mockedParser).
 		On("Parse", site2).
-		Return(news2, nil)
+		Return(news2, parser.FetchInfo{StatusCode: http.StatusOK, Bytes: 100}, nil)
 	app.parser.(*mockedParser).
 		On("Parse", site3).
-		Return([]repository.NewsItem{}, errors.New("test parser error"))
+		Return([]repository.NewsItem{}, parser.FetchInfo{}, errors.New("test parser error"))
 
 	app.repository.(*mockedRepository).
 		On("GetSites").
 		Return([]repository.Site{site1, site2, site3}, nil)
+	app.repository.(*mockedRepository).
+		On("GetAlertRules").
+		Return([]repository.AlertRule{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSiteFilters", mock.Anything).
+		Return([]repository.SiteFilter{}, nil)
 
 	app.repository.(*mockedRepository).
 		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.Link == news1[0].Link })).
@@ -128,12 +505,37 @@ func TestParsing(t *testing.T) {
 		On("AddNewsItem", mock.MatchedBy(func(item *repository.NewsItem) bool { return item.Link == news2[0].Link })).
 		Return(errors.New("test repository error"))
 
+	app.repository.(*mockedRepository).
+		On("AddParseRun", mock.MatchedBy(func(run *repository.ParseRun) bool {
+			return run.SiteID == site1.ID && run.StatusCode == http.StatusOK && run.ItemsFound == 2 && run.Inserted == 1
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddParseRun", mock.MatchedBy(func(run *repository.ParseRun) bool {
+			return run.SiteID == site2.ID && run.Bytes == 100 && run.ItemsFound == 2 && run.Inserted == 0
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddParseRun", mock.MatchedBy(func(run *repository.ParseRun) bool {
+			return run.SiteID == site3.ID && run.Error == "test parser error"
+		})).
+		Return(errors.New("test repository error"))
+
+	found := testutil.ToFloat64(siteItemsFound.WithLabelValues("1"))
+	inserted := testutil.ToFloat64(siteItemsInserted.WithLabelValues("1"))
+	parseErrors := testutil.ToFloat64(siteParseErrors.WithLabelValues("3"))
+
 	app.parsing()
 	time.Sleep(time.Millisecond * 100)
 
 	app.parser.(*mockedParser).AssertNumberOfCalls(t, "Parse", 3)
 	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "HasNewsItem", 4)
 	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddNewsItem", 2)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddParseRun", 3)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetAlertRules", 1)
+	assert.Equal(t, found+2, testutil.ToFloat64(siteItemsFound.WithLabelValues("1")))
+	assert.Equal(t, inserted+1, testutil.ToFloat64(siteItemsInserted.WithLabelValues("1")))
+	assert.Equal(t, parseErrors+1, testutil.ToFloat64(siteParseErrors.WithLabelValues("3")))
 
 	time.Sleep(app.interval)
 
@@ -161,10 +563,11 @@ func TestMainHandler(t *testing.T) {
 	app.prepareTemplates()
 
 	app.repository.(*mockedRepository).
-		On("GetNews", 0, 10, "").
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{Collapse: true}).
 		Return(
-			[]repository.NewsItem{
+			repository.NewsPage{Total: 12, HasNext: true, Items: []repository.NewsItem{
 				repository.NewsItem{
+					ID:          12,
 					Title:       "Заголовок 1",
 					Link:        "http://test1.ru/news/1",
 					Description: "описание 1",
@@ -172,15 +575,22 @@ func TestMainHandler(t *testing.T) {
 					Image:       "http://test1.ru/news/1.jpeg",
 				},
 				repository.NewsItem{
+					ID:          11,
 					Title:       "Заголовок 2",
 					Link:        "http://test1.ru/news/2",
 					Description: "описание 2",
 					Date:        "2019-09-27 05:00",
 					Image:       "http://test1.ru/news/2.jpeg",
 				},
-			},
+			}},
 			nil,
 		)
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 1, Url: "http://test1.ru"}, {ID: 2, Url: "http://test2.ru"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetStorySources", mock.Anything).
+		Return([]repository.StorySources{{StoryID: 12, Sources: 3}}, nil)
 	req, _ := http.NewRequest("GET", "/", nil)
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(app.mainHandler)
@@ -188,15 +598,80 @@ func TestMainHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Заголовок 1")
 	assert.Contains(t, rr.Body.String(), "Заголовок 2")
+	assert.Contains(t, rr.Body.String(), "Всего: 12")
+	assert.Contains(t, rr.Body.String(), `<span>&lt;-</span>`)
+	assert.Contains(t, rr.Body.String(), `<a href="?before=11">-></a>`)
+	assert.Contains(t, rr.Body.String(), `<a href="/?story=12">Также сообщают источников: 2</a>`)
+	assert.Contains(t, rr.Body.String(), `<a href="/news/12-zagolovok-1">Подробнее</a>`)
+	assert.Equal(t, 1, strings.Count(rr.Body.String(), "Также сообщают"))
 
 	app.repository.(*mockedRepository).
-		On("GetNews", 10, 10, "поиск").
-		Return([]repository.NewsItem{}, errors.New("test repository error"))
-	req, _ = http.NewRequest("GET", "/?q=поиск&page=2", nil)
+		On("GetNews", repository.NewsCursor{Before: 11}, 10, repository.NewsFilter{
+			SiteIDs:  []int{2},
+			From:     time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC),
+			To:       time.Date(2019, 9, 28, 0, 0, 0, 0, time.UTC),
+			HasImage: true,
+			Collapse: true,
+		}).
+		Return(repository.NewsPage{Total: 11, HasPrev: true, Items: []repository.NewsItem{{ID: 1, Title: "Заголовок 3"}}}, nil)
+	req, _ = http.NewRequest("GET", "/?site=2&from=2019-09-01&to=2019-09-27&image=1&before=11", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<option value="2" selected>`)
+	assert.Contains(t, rr.Body.String(), `<a href="?after=1&amp;from=2019-09-01&amp;image=1&amp;site=2&amp;to=2019-09-27">&lt;-</a>`)
+	assert.Contains(t, rr.Body.String(), `href="/news/1-`)
+	assert.Contains(t, rr.Body.String(), `?back=%2F%3Fsite%3D2%26from%3D2019-09-01%26to%3D2019-09-27%26image%3D1%26before%3D11">Подробнее</a>`)
+	assert.Contains(t, rr.Body.String(), `<span>-></span>`)
+
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{After: 5}, 10, repository.NewsFilter{Search: "поиск", Collapse: true}).
+		Return(repository.NewsPage{}, errors.New("test repository error"))
+	req, _ = http.NewRequest("GET", "/?q=поиск&after=5", nil)
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{1}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{SiteIDs: []int{1}, Collapse: true}).
+		Return(repository.NewsPage{}, nil)
+	req, _ = http.NewRequest("GET", "/", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 1, Login: "user"}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<option value="1">http://test1.ru</option>`)
+	assert.NotContains(t, rr.Body.String(), `http://test2.ru`)
+
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{Story: 12}).
+		Return(repository.NewsPage{Total: 1, Items: []repository.NewsItem{{ID: 13, StoryID: 12, Title: "Заголовок 4"}}}, nil)
+	req, _ = http.NewRequest("GET", "/?story=12", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Заголовок 4")
+	assert.NotContains(t, rr.Body.String(), "Также сообщают")
+
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{Search: `"><script>alert(1)</script>`, Collapse: true}).
+		Return(repository.NewsPage{Total: 1, Items: []repository.NewsItem{{
+			ID:          14,
+			Title:       "<script>alert(2)</script>",
+			Link:        "javascript:alert(3)",
+			Description: `<img src=x onerror="alert(4)">`,
+		}}}, nil)
+	req, _ = http.NewRequest("GET", "/?q=%22%3E%3Cscript%3Ealert(1)%3C%2Fscript%3E", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`)
+	assert.Contains(t, rr.Body.String(), `&lt;script&gt;alert(2)&lt;/script&gt;`)
+	assert.NotContains(t, rr.Body.String(), "javascript:alert")
+	assert.NotContains(t, rr.Body.String(), "<img src=x")
+
 	req, _ = http.NewRequest("GET", "/not-found", nil)
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
@@ -230,6 +705,18 @@ func TestSitesHandler(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "http://test1.ru/news/")
 	assert.Contains(t, rr.Body.String(), "http://test2.ru/news/")
 
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{1}, nil)
+	app.repository.(*mockedRepository).
+		On("GetUnreadCounts", 1).
+		Return([]repository.UnreadCount{{SiteID: 1, Count: 3}}, nil)
+	req, _ = http.NewRequest("GET", "/sites", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 1, Login: "user"}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<a class="unread" href="/?unread=1&site=1">непрочитанных: 3</a>`)
+
 	app = getApplication()
 	app.prepareTemplates()
 	app.repository.(*mockedRepository).
@@ -242,6 +729,54 @@ func TestSitesHandler(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 }
 
+func TestSiteHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+
+	started := time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC)
+	app.repository.(*mockedRepository).
+		On("GetSite", 1).
+		Return(repository.Site{ID: 1, Url: "http://test1.ru/news/"}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSite", 2).
+		Return(repository.Site{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetParseRuns", 1, 50).
+		Return([]repository.ParseRun{
+			{SiteID: 1, StartedAt: started, FinishedAt: started.Add(time.Second), StatusCode: 200, ItemsFound: 5, Inserted: 2},
+			{SiteID: 1, StartedAt: started, FinishedAt: started.Add(time.Second), StatusCode: 503, Error: "request failed with status code 503"},
+			{SiteID: 1, StartedAt: started, FinishedAt: started.Add(time.Second), StatusCode: 200, Error: "XML syntax error: unexpected <script>"},
+		}, nil)
+	app.repository.(*mockedRepository).
+		On("GetDailyStats", 1, mock.Anything).
+		Return([]repository.DailyStat{
+			{Day: started, Runs: 2, Inserted: 2, AvgDuration: 1},
+			{Day: started.AddDate(0, 0, 1), Runs: 2, Inserted: 4, AvgDuration: 2},
+		}, nil)
+
+	req, _ := http.NewRequest("GET", "/sites/1", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.siteHandler)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "http://test1.ru/news/")
+	assert.Contains(t, rr.Body.String(), "2019-09-27 04:00:00")
+	assert.Contains(t, rr.Body.String(), "request failed with status code 503")
+	assert.Contains(t, rr.Body.String(), "unexpected &lt;script&gt;")
+	assert.Contains(t, rr.Body.String(), "Среднее время обработки: 1.50")
+	assert.Contains(t, rr.Body.String(), `height: 50%`)
+
+	req, _ = http.NewRequest("GET", "/sites/2", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/sites/invalid", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
 func TestSiteDeleteHandler(t *testing.T) {
 	app := getApplication()
 	app.prepareTemplates()
@@ -293,11 +828,15 @@ func TestSiteAddHandler(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Добавить сайт")
+	assert.Contains(t, rr.Body.String(), `<input type="hidden" name="type" value="json" />`)
+	assert.Contains(t, rr.Body.String(), `<input id="json-items_path" name="items_path" value="" />`)
+	assert.Contains(t, rr.Body.String(), `<input id="html-news_item_path" name="news_item_path" value="" required />`)
+	assert.Contains(t, rr.Body.String(), `<input id="sitemap-link_pattern" name="link_pattern" value="" />`)
 
 	app.repository.(*mockedRepository).
-		On("AddSite", &repository.Site{Url: "http://test1.ru", IsRss: true}).
+		On("AddSite", &repository.Site{Url: "http://test1.ru", Type: parser.TypeRss}).
 		Return(nil)
-	reader := strings.NewReader("url=http://test1.ru;is_rss=1")
+	reader := strings.NewReader("url=http://test1.ru&type=rss")
 	req, _ = http.NewRequest("POST", "/sites/add", reader)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
@@ -308,7 +847,7 @@ func TestSiteAddHandler(t *testing.T) {
 	app.repository.(*mockedRepository).
 		On("AddSite", &repository.Site{
 			Url:             "http://test2.ru",
-			IsRss:           false,
+			Type:            parser.TypeHtml,
 			NewsItemPath:    "article",
 			TitlePath:       "h3",
 			DescriptionPath: ".desc",
@@ -317,11 +856,44 @@ func TestSiteAddHandler(t *testing.T) {
 			ImagePath:       "img",
 		}).
 		Return(errors.New("test repository error"))
-	reader = strings.NewReader("url=http://test2.ru;is_rss=0;news_item_path=article;title_path=h3;description_path=.desc;link_path=a;date_path=i;image_path=img")
+	reader = strings.NewReader("url=http://test2.ru&type=html&news_item_path=article&title_path=h3&description_path=.desc&link_path=a&date_path=i&image_path=img")
 	req, _ = http.NewRequest("POST", "/sites/add", reader)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 2)
+
+	reader = strings.NewReader("url=javascript:alert(1)&type=html&news_item_path=article[&title_path=h3&link_path=a")
+	req, _ = http.NewRequest("POST", "/sites/add", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Адрес должен начинаться с http:// или https://")
+	assert.Contains(t, rr.Body.String(), "Некорректный селектор")
+	assert.Contains(t, rr.Body.String(), `value="h3"`)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 2)
+
+	reader = strings.NewReader("url=http://test3.ru/api&type=json&items_path=data&title_field=title&link_field=")
+	req, _ = http.NewRequest("POST", "/sites/add", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<input id="json-items_path" name="items_path" value="data" />`)
+	assert.Contains(t, rr.Body.String(), `<input id="json-url" name="url" value="http://test3.ru/api" required />`)
+	assert.Contains(t, rr.Body.String(), `<input id="rss-url" name="url" value="" required />`)
+	assert.Contains(t, rr.Body.String(), "Укажите значение")
+
+	app.repository.(*mockedRepository).
+		On("AddSite", &repository.Site{Url: "http://test3.ru/api", Type: parser.TypeJson, Options: `{"items_path":"data","link_field":"url","title_field":"title"}`}).
+		Return(nil)
+	reader = strings.NewReader("url=http://test3.ru/api&type=json&items_path=data&title_field=title&link_field=url")
+	req, _ = http.NewRequest("POST", "/sites/add", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSite", 3)
 }
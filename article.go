@@ -0,0 +1,137 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/canonical"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// fetchContent stores the article of the news item fetched from its link. The rel=canonical link
+// of the article replaces the canonical link of the item, exist reports whether the news item
+// is already in the repository under that link.
+func (app *application) fetchContent(site repository.Site, item *repository.NewsItem) (exist bool, err error) {
+	article, err := app.parser.ParseArticle(site, item.Link)
+	if err != nil {
+		return false, err
+	}
+	item.Content = article.Content
+	item.ContentText = article.Text
+
+	if article.CanonicalLink == "" {
+		return false, nil
+	}
+	link := canonical.Link(article.CanonicalLink)
+	if link == item.CanonicalLink || (item.CanonicalLink == "" && link == canonical.Link(item.Link)) {
+		return false, nil
+	}
+	item.CanonicalLink = link
+
+	return app.repository.HasNewsItem(*item)
+}
+
+// newsSiblingsLimit is the number of other news of the story shown on the news item page.
+const newsSiblingsLimit = 10
+
+// ogDescriptionLength is the maximal number of characters of the OpenGraph description.
+const ogDescriptionLength = 300
+
+// newsItemHandler shows the news item at its permalink /news/{id}-{slug} with its article content,
+// tags and other news of the story. Links with an outdated or missing slug are redirected.
+func (app *application) newsItemHandler(res http.ResponseWriter, req *http.Request) {
+	id, ok := newsPathID(req.URL.Path)
+	if !ok {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	}
+
+	item, err := app.repository.GetNewsItem(id)
+	if err == repository.ErrNotFound {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+		return
+	}
+
+	path := newsPath(item)
+	if req.URL.Path != path {
+		location := path
+		if req.URL.RawQuery != "" {
+			location += "?" + req.URL.RawQuery
+		}
+		http.Redirect(res, req, location, http.StatusMovedPermanently)
+
+		return
+	}
+
+	site, err := app.repository.GetSite(item.SiteID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get site from repository")
+
+		return
+	}
+
+	story, err := app.repository.GetNews(repository.NewsCursor{}, newsSiblingsLimit+1, repository.NewsFilter{Story: item.Story()})
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get story news from repository")
+
+		return
+	}
+	back := safeNext(req.URL.Query().Get("back"))
+	type sibling struct {
+		repository.NewsItem
+		Permalink string
+	}
+	siblings := make([]sibling, 0, len(story.Items))
+	for _, other := range story.Items {
+		if other.ID != item.ID && len(siblings) < newsSiblingsLimit {
+			siblings = append(siblings, sibling{other, newsItemLink(other, back)})
+		}
+	}
+
+	description := item.Description
+	if description == "" {
+		description = item.ContentText
+	}
+	ogDescription := []rune(strings.Join(strings.Fields(description), " "))
+	if len(ogDescription) > ogDescriptionLength {
+		ogDescription = append(ogDescription[:ogDescriptionLength-1], '…')
+	}
+	var publishedTime string
+	if !item.PublishedAt.IsZero() {
+		publishedTime = item.PublishedAt.UTC().Format(time.RFC3339)
+	}
+
+	err = app.render(res, "news_item.tmpl", struct {
+		User *repository.User
+		Item repository.NewsItem
+		Site repository.Site
+		URL  string
+		Back string
+		// Content is the article sanitized by the parser.
+		Content       template.HTML
+		Description   string
+		PublishedTime string
+		Siblings      []sibling
+	}{
+		app.user(req), item, site, app.absoluteURL(req, path), back, template.HTML(item.Content), string(ogDescription), publishedTime, siblings,
+	})
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInsertNewsFetchContent(t *testing.T) {
+	app := getApplication()
+	site := repository.Site{ID: 2, Url: "https://news.ru/rss", Type: parser.TypeRss, FetchContent: true}
+	news := []repository.NewsItem{
+		{Link: "https://news.ru/1"},
+		{Link: "https://news.ru/2?utm_source=rss"},
+		{Link: "https://news.ru/3"},
+	}
+
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.CanonicalLink == "" })).
+		Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.CanonicalLink == "https://news.ru/old/2" })).
+		Return(true, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.Anything).
+		Return(nil)
+	app.parser.(*mockedParser).
+		On("ParseArticle", site, "https://news.ru/1").
+		Return(parser.Article{Content: "<p>Текст 1</p>", Text: "Текст 1", CanonicalLink: "http://www.news.ru/1/"}, nil)
+	app.parser.(*mockedParser).
+		On("ParseArticle", site, "https://news.ru/2?utm_source=rss").
+		Return(parser.Article{Content: "<p>Текст 2</p>", Text: "Текст 2", CanonicalLink: "https://news.ru/old/2"}, nil)
+	app.parser.(*mockedParser).
+		On("ParseArticle", site, "https://news.ru/3").
+		Return(parser.Article{}, errors.New("test parser error"))
+
+	inserted := app.insertNews(site, news, app.log.WithField("site_id", site.ID))
+	assert.Len(t, inserted, 2)
+	assert.Equal(t, "<p>Текст 1</p>", inserted[0].Content)
+	assert.Equal(t, "Текст 1", inserted[0].ContentText)
+	assert.Empty(t, inserted[0].CanonicalLink)
+	assert.Equal(t, "https://news.ru/3", inserted[1].Link)
+	assert.Empty(t, inserted[1].ContentText)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "HasNewsItem", 4)
+
+	site.FetchContent = false
+	inserted = app.insertNews(site, news[:1], app.log.WithField("site_id", site.ID))
+	assert.Len(t, inserted, 1)
+	app.parser.(*mockedParser).AssertNumberOfCalls(t, "ParseArticle", 3)
+}
+
+func TestNewsItemHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	app.publicURL = "https://newsagg.ru"
+
+	app.repository.(*mockedRepository).
+		On("GetNewsItem", 5).
+		Return(repository.NewsItem{
+			ID:          5,
+			SiteID:      2,
+			Title:       "Заголовок <1>",
+			Link:        "https://news.ru/1",
+			Image:       "https://news.ru/1.jpg",
+			Content:     "<p>Полный текст</p>",
+			ContentText: "Полный текст",
+			Tags:        []string{"экономика"},
+			PublishedAt: time.Date(2020, 5, 12, 10, 0, 0, 0, time.UTC),
+		}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNewsItem", 6).
+		Return(repository.NewsItem{ID: 6, SiteID: 2, Title: "Заголовок 2", Description: "Анонс"}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNewsItem", 7).
+		Return(repository.NewsItem{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetSite", 2).
+		Return(repository.Site{ID: 2, Url: "https://news.ru"}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, newsSiblingsLimit+1, repository.NewsFilter{Story: 5}).
+		Return(repository.NewsPage{Items: []repository.NewsItem{{ID: 8, Title: "Другой источник", StoryID: 5}, {ID: 5, Title: "Заголовок <1>"}}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, newsSiblingsLimit+1, repository.NewsFilter{Story: 6}).
+		Return(repository.NewsPage{Items: []repository.NewsItem{{ID: 6, Title: "Заголовок 2"}}}, nil)
+	handler := http.HandlerFunc(app.newsItemHandler)
+
+	req, _ := http.NewRequest("GET", "/news/5-zagolovok-1?back=%2F%3Fq%3Dtest%26before%3D9", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<h1>Заголовок &lt;1&gt;</h1>")
+	assert.Contains(t, rr.Body.String(), `<div class="content"><p>Полный текст</p></div>`)
+	assert.Contains(t, rr.Body.String(), `href="https://news.ru/1">Читать на сайте источника`)
+	assert.Contains(t, rr.Body.String(), `<meta property="og:title" content="Заголовок &lt;1&gt;" />`)
+	assert.Contains(t, rr.Body.String(), `<meta property="og:description" content="Полный текст" />`)
+	assert.Contains(t, rr.Body.String(), `<meta property="og:url" content="https://newsagg.ru/news/5-zagolovok-1" />`)
+	assert.Contains(t, rr.Body.String(), `<meta property="og:image" content="https://news.ru/1.jpg" />`)
+	assert.Contains(t, rr.Body.String(), `<meta property="article:published_time" content="2020-05-12T10:00:00Z" />`)
+	assert.Contains(t, rr.Body.String(), `<meta property="article:tag" content="экономика" />`)
+	assert.Contains(t, rr.Body.String(), `<a class="back" href="/?q=test&amp;before=9">`)
+	assert.Contains(t, rr.Body.String(), `<a href="/news/8-drugoy-istochnik?back=%2F%3Fq%3Dtest%26before%3D9">Другой источник</a>`)
+	assert.NotContains(t, rr.Body.String(), `<a href="/news/5-zagolovok-1`)
+
+	req, _ = http.NewRequest("GET", "/news/6-zagolovok-2?back=%2F%2Fevil.ru", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 1}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<p>Анонс</p>")
+	assert.Contains(t, rr.Body.String(), `href="/go/6"`)
+	assert.Contains(t, rr.Body.String(), `<a class="back" href="/">`)
+	assert.NotContains(t, rr.Body.String(), "og:image")
+	assert.NotContains(t, rr.Body.String(), "Также сообщают")
+
+	for _, path := range []string{"/news/5", "/news/5-old-title?back=%2Fstarred"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusMovedPermanently, rr.Code)
+	}
+	assert.Equal(t, "/news/5-zagolovok-1?back=%2Fstarred", rr.Header().Get("Location"))
+
+	for _, path := range []string{"/news/7", "/news/x", "/news/-5"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	}
+}
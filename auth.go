@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie     = "session"
+	sessionTTL        = 30 * 24 * time.Hour
+	minPasswordLength = 8
+
+	userKey  contextKey = "user"
+	scopeKey contextKey = "scope"
+)
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the form of a token stored in the repository.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate loads the user of the session cookie into the request context.
+// Requests with api tokens stay anonymous here, the tokens are checked by requireScope
+// on the routes accepting them.
+func (app *application) authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := bearerToken(req); ok {
+			handler(res, req)
+
+			return
+		}
+
+		cookie, err := req.Cookie(sessionCookie)
+		if err != nil {
+			handler(res, req)
+
+			return
+		}
+
+		session, err := app.repository.GetSession(hashToken(cookie.Value))
+		if err != nil {
+			if err != repository.ErrNotFound {
+				app.requestLog(req).WithError(err).Error("Fail get session from repository")
+			}
+			handler(res, req)
+
+			return
+		}
+
+		user, err := app.repository.GetUser(session.UserID)
+		if err != nil {
+			app.requestLog(req).WithError(err).Error("Fail get user from repository")
+			handler(res, req)
+
+			return
+		}
+
+		handler(res, req.WithContext(context.WithValue(req.Context(), userKey, &user)))
+	}
+}
+
+// user returns the logged in user or nil.
+func (app *application) user(req *http.Request) *repository.User {
+	user, _ := req.Context().Value(userKey).(*repository.User)
+
+	return user
+}
+
+// requireUser redirects anonymous users to the login page, api tokens are refused.
+func (app *application) requireUser(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := bearerToken(req); ok {
+			writeJSONError(res, http.StatusUnauthorized, "api tokens are not accepted here")
+
+			return
+		}
+		if app.user(req) == nil {
+			http.Redirect(res, req, "/login?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+
+			return
+		}
+
+		handler(res, req)
+	}
+}
+
+// requireAdmin allows only administrators to managing sources.
+func (app *application) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return app.requireUser(func(res http.ResponseWriter, req *http.Request) {
+		if !app.user(req).IsAdmin {
+			res.WriteHeader(http.StatusForbidden)
+			res.Write([]byte("Доступ запрещен"))
+
+			return
+		}
+
+		handler(res, req)
+	})
+}
+
+func (app *application) startSession(res http.ResponseWriter, user repository.User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	session := &repository.Session{
+		ID:        hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(sessionTTL),
+	}
+	err = app.repository.AddSession(session)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// safeNext returns local redirect target of the login form. Browsers take both slashes
+// and backslashes after the leading slash as a protocol relative url of another host.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.ContainsRune(next, '\\') || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	target, err := url.Parse(next)
+	if err != nil || target.Scheme != "" || target.Host != "" {
+		return "/"
+	}
+
+	return next
+}
+
+type loginForm struct {
+	CSRF        string
+	Error       string
+	Login       string
+	Next        string
+	CanRegister bool
+}
+
+func (app *application) canRegister() (bool, error) {
+	if app.allowRegister {
+		return true, nil
+	}
+	count, err := app.repository.CountUsers()
+
+	return count == 0, err
+}
+
+func (app *application) loginHandler(res http.ResponseWriter, req *http.Request) {
+	data := loginForm{CSRF: app.csrfToken(req), Next: safeNext(req.FormValue("next"))}
+
+	var err error
+	data.CanRegister, err = app.canRegister()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail count users in repository")
+
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		data.Login = req.FormValue("login")
+		user, err := app.repository.GetUserByLogin(data.Login)
+		if err != nil && err != repository.ErrNotFound {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get user from repository")
+
+			return
+		}
+		if err == nil && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.FormValue("password"))) == nil {
+			err = app.startSession(res, user)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail start session")
+
+				return
+			}
+			http.Redirect(res, req, data.Next, http.StatusSeeOther)
+
+			return
+		}
+		data.Error = "Неверный логин или пароль"
+		res.WriteHeader(http.StatusUnauthorized)
+	}
+
+	err = app.render(res, "login.tmpl", data)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
+
+func (app *application) registerHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	canRegister, err := app.canRegister()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail count users in repository")
+
+		return
+	}
+	if !canRegister {
+		res.WriteHeader(http.StatusForbidden)
+		res.Write([]byte("Регистрация закрыта"))
+
+		return
+	}
+
+	login := strings.TrimSpace(req.FormValue("login"))
+	password := req.FormValue("password")
+	var formError string
+	if login == "" {
+		formError = "Укажите логин"
+	} else if len(password) < minPasswordLength {
+		formError = "Пароль должен быть не короче 8 символов"
+	} else if _, err := app.repository.GetUserByLogin(login); err == nil {
+		formError = "Логин уже занят"
+	} else if err != repository.ErrNotFound {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get user from repository")
+
+		return
+	}
+	if formError != "" {
+		res.WriteHeader(http.StatusBadRequest)
+		err = app.render(res, "login.tmpl", loginForm{app.csrfToken(req), formError, login, safeNext(req.FormValue("next")), true})
+		if err != nil {
+			app.requestLog(req).WithError(err).Error("Fail execute template")
+		}
+
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail hash password")
+
+		return
+	}
+
+	// the first user manages sources
+	count, err := app.repository.CountUsers()
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail count users in repository")
+
+		return
+	}
+	user := &repository.User{Login: login, PasswordHash: string(hash), IsAdmin: count == 0}
+	err = app.repository.AddUser(user)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail insert user to repository")
+
+		return
+	}
+
+	err = app.startSession(res, *user)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail start session")
+
+		return
+	}
+
+	http.Redirect(res, req, safeNext(req.FormValue("next")), http.StatusSeeOther)
+}
+
+func (app *application) logoutHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if cookie, err := req.Cookie(sessionCookie); err == nil {
+		err = app.repository.DeleteSession(hashToken(cookie.Value))
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail delete session from repository")
+
+			return
+		}
+	}
+
+	http.SetCookie(res, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(res, req, "/", http.StatusSeeOther)
+}
+
+func (app *application) subscriptionHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, "/sites", http.StatusSeeOther)
+
+		return
+	}
+
+	user := app.user(req)
+	if req.FormValue("subscribe") == "1" {
+		err = app.repository.Subscribe(user.ID, id)
+	} else {
+		err = app.repository.Unsubscribe(user.ID, id)
+	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail update subscriptions in repository")
+
+		return
+	}
+
+	http.Redirect(res, req, "/sites", http.StatusSeeOther)
+}
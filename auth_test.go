@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// withUser returns request with the user logged in.
+func withUser(req *http.Request, user *repository.User) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userKey, user))
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetSession", hashToken("valid")).
+		Return(repository.Session{ID: hashToken("valid"), UserID: 1}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSession", hashToken("expired")).
+		Return(repository.Session{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetUser", 1).
+		Return(repository.User{ID: 1, Login: "user"}, nil)
+
+	var user *repository.User
+	handler := app.authenticate(func(res http.ResponseWriter, req *http.Request) {
+		user = app.user(req)
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "valid"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, &repository.User{ID: 1, Login: "user"}, user)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "expired"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Nil(t, user)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Nil(t, user)
+}
+
+func TestRequireAdmin(t *testing.T) {
+	app := getApplication()
+	handler := app.requireAdmin(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("POST", "/sites/delete", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/login?next=%2Fsites%2Fdelete", rr.Header().Get("Location"))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 2}))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 1, IsAdmin: true}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestLoginHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	app.repository.(*mockedRepository).
+		On("CountUsers").
+		Return(1, nil)
+	app.repository.(*mockedRepository).
+		On("GetUserByLogin", "user").
+		Return(repository.User{ID: 1, Login: "user", PasswordHash: string(hash)}, nil)
+	app.repository.(*mockedRepository).
+		On("GetUserByLogin", "unknown").
+		Return(repository.User{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("AddSession", mock.MatchedBy(func(session *repository.Session) bool { return session.UserID == 1 })).
+		Return(nil)
+
+	req, _ := http.NewRequest("GET", "/login", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.loginHandler)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "Регистрация")
+
+	req, _ = http.NewRequest("GET", "/login?next=%2F%22%3E%3Cscript%3Ealert(1)%3C%2Fscript%3E", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "<script>alert")
+	assert.Contains(t, rr.Body.String(), `value="/&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`)
+
+	reader := strings.NewReader("login=user&password=wrong")
+	req, _ = http.NewRequest("POST", "/login", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Неверный логин или пароль")
+
+	reader = strings.NewReader("login=unknown&password=password")
+	req, _ = http.NewRequest("POST", "/login", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	reader = strings.NewReader("login=user&password=password&next=/sites")
+	req, _ = http.NewRequest("POST", "/login", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/sites", rr.Header().Get("Location"))
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, sessionCookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSession", 1)
+}
+
+func TestRegisterHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+
+	app.repository.(*mockedRepository).
+		On("CountUsers").
+		Return(0, nil)
+	app.repository.(*mockedRepository).
+		On("GetUserByLogin", "admin").
+		Return(repository.User{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("AddUser", mock.MatchedBy(func(user *repository.User) bool {
+			return user.Login == "admin" && user.IsAdmin && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("password")) == nil
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddSession", mock.Anything).
+		Return(nil)
+
+	reader := strings.NewReader("login=admin&password=short")
+	req, _ := http.NewRequest("POST", "/register", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.registerHandler)
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Пароль должен быть не короче 8 символов")
+
+	reader = strings.NewReader("login=admin&password=password")
+	req, _ = http.NewRequest("POST", "/register", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddUser", 1)
+
+	app = getApplication()
+	app.repository.(*mockedRepository).
+		On("CountUsers").
+		Return(1, nil)
+	reader = strings.NewReader("login=user&password=password")
+	req, _ = http.NewRequest("POST", "/register", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.registerHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestLogoutHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("DeleteSession", hashToken("token")).
+		Return(nil)
+
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "token"})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.logoutHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, -1, rr.Result().Cookies()[0].MaxAge)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "DeleteSession", 1)
+}
+
+func TestSubscriptionHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("Subscribe", 1, 2).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("Unsubscribe", 1, 3).
+		Return(nil)
+	user := &repository.User{ID: 1}
+	handler := http.HandlerFunc(app.subscriptionHandler)
+
+	reader := strings.NewReader("id=2&subscribe=1")
+	req, _ := http.NewRequest("POST", "/sites/subscription", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	reader = strings.NewReader("id=3")
+	req, _ = http.NewRequest("POST", "/sites/subscription", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "Subscribe", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "Unsubscribe", 1)
+}
+
+func TestSafeNext(t *testing.T) {
+	assert.Equal(t, "/sites", safeNext("/sites"))
+	assert.Equal(t, "/", safeNext("//evil.com"))
+	assert.Equal(t, "/", safeNext("http://evil.com"))
+	assert.Equal(t, "/", safeNext(`/\evil.com`))
+	assert.Equal(t, "/", safeNext(`/news?q=\`))
+	assert.Equal(t, "/", safeNext("/\t/evil.com"))
+	assert.Equal(t, "/news?q=a", safeNext("/news?q=a"))
+	assert.Equal(t, "/starred?before=5", safeNext("/starred?before=5"))
+	for _, next := range []string{"", "https://evil.ru", "//evil.ru", "/\\evil.ru", "javascript:alert(1)"} {
+		assert.Equal(t, "/", safeNext(next), next)
+	}
+}
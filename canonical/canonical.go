@@ -0,0 +1,87 @@
+// Package canonical normalizes news links so that variants of one article compare equal.
+package canonical
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// trackingParams are query parameters which do not change the linked page.
+var trackingParams = map[string]bool{
+	"fbclid":     true,
+	"gclid":      true,
+	"dclid":      true,
+	"yclid":      true,
+	"ysclid":     true,
+	"msclkid":    true,
+	"igshid":     true,
+	"mc_cid":     true,
+	"mc_eid":     true,
+	"_openstat":  true,
+	"ref":        true,
+	"ref_src":    true,
+	"amp":        true,
+	"outputtype": true,
+}
+
+// hostPrefixes are subdomains of mirrors serving the same articles.
+var hostPrefixes = []string{"www.", "amp.", "m."}
+
+// Link returns the canonical form of the link: https scheme, lower case host without www, amp
+// and mobile subdomains and default port, clean path without trailing slash and amp variants,
+// sorted query without tracking parameters and no fragment.
+// Links which are not absolute http urls are returned trimmed.
+func Link(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Fragment = ""
+
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range hostPrefixes {
+		if strings.HasPrefix(host, prefix) && strings.Count(host, ".") > 1 {
+			host = strings.TrimPrefix(host, prefix)
+		}
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Path = cleanPath(u.Path)
+	u.RawPath = ""
+
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if trackingParams[lower] || strings.HasPrefix(lower, "utm_") {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func cleanPath(p string) string {
+	if p == "" || p == "/" {
+		return ""
+	}
+	p = path.Clean(p)
+	p = strings.TrimSuffix(p, "/amp")
+	p = strings.TrimSuffix(p, ".amp")
+	if strings.HasPrefix(p, "/amp/") {
+		p = strings.TrimPrefix(p, "/amp")
+	}
+	if p == "/" || p == "." {
+		return ""
+	}
+
+	return p
+}
@@ -0,0 +1,34 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLink(t *testing.T) {
+	cases := map[string]string{
+		"https://news.ru/1":                                   "https://news.ru/1",
+		"http://news.ru/1":                                    "https://news.ru/1",
+		" https://WWW.News.ru/1/ ":                            "https://news.ru/1",
+		"https://news.ru:443/1":                               "https://news.ru/1",
+		"https://news.ru:8080/1":                              "https://news.ru:8080/1",
+		"https://news.ru/1?utm_source=rss&utm_medium=feed":    "https://news.ru/1",
+		"https://news.ru/1?id=2&fbclid=abc&a=1#comments":      "https://news.ru/1?a=1&id=2",
+		"https://news.ru/a//b/../1":                           "https://news.ru/a/1",
+		"https://news.ru/1/amp":                               "https://news.ru/1",
+		"https://news.ru/amp/1":                               "https://news.ru/1",
+		"https://news.ru/1.amp":                               "https://news.ru/1",
+		"https://amp.news.ru/1?amp=1":                         "https://news.ru/1",
+		"https://m.news.ru/1":                                 "https://news.ru/1",
+		"https://www.ru/1":                                    "https://www.ru/1",
+		"https://news.ru/":                                    "https://news.ru",
+		"https://news.ru/news/%D0%BD%D0%BE%D0%B2%D0%BE%D1%81": "https://news.ru/news/%D0%BD%D0%BE%D0%B2%D0%BE%D1%81",
+		"ftp://news.ru/1":                                     "ftp://news.ru/1",
+		"/news/1":                                             "/news/1",
+		"12345":                                               "12345",
+	}
+	for link, expected := range cases {
+		assert.Equal(t, expected, Link(link), link)
+	}
+}
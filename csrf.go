@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+
+	csrfKey contextKey = "csrf"
+)
+
+// csrf protects handler with a double submit cookie: every request gets a token cookie,
+// unsafe requests must repeat the token in the csrf_token form field or the X-CSRF-Token header.
+// Requests with api tokens are not checked as browsers never send them cross site.
+func (app *application) csrf(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := bearerToken(req); ok {
+			handler(res, req)
+
+			return
+		}
+
+		var token string
+		if cookie, err := req.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+			token = cookie.Value
+		}
+
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			sent := req.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = req.PostFormValue(csrfField)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sent)) != 1 {
+				app.requestLog(req).Warn("Reject request with invalid csrf token")
+				res.WriteHeader(http.StatusForbidden)
+				res.Write([]byte("Неверный токен формы, обновите страницу"))
+
+				return
+			}
+		}
+
+		if token == "" {
+			var err error
+			token, err = newToken()
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail generate csrf token")
+
+				return
+			}
+			http.SetCookie(res, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		handler(res, req.WithContext(context.WithValue(req.Context(), csrfKey, token)))
+	}
+}
+
+// csrfToken returns token to be included into forms of the page.
+func (app *application) csrfToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfKey).(string)
+
+	return token
+}
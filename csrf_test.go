@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCsrf(t *testing.T) {
+	app := getApplication()
+	var token string
+	handler := app.csrf(func(res http.ResponseWriter, req *http.Request) {
+		token = app.csrfToken(req)
+		res.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Issue token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/sites/add", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		cookies := rr.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, csrfCookie, cookies[0].Name)
+		assert.Equal(t, token, cookies[0].Value)
+	})
+
+	t.Run("Reject post without cookie", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/sites/delete", strings.NewReader("id=1&csrf_token=token"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Reject cross site post", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/sites/delete", strings.NewReader("id=1"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Reject wrong token", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/sites/add", strings.NewReader("url=http://test1.ru&csrf_token=other"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Accept form token", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/sites/delete", strings.NewReader("id=1&csrf_token=token"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Result().Cookies())
+	})
+
+	t.Run("Accept header token", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/sites/delete", nil)
+		req.Header.Set(csrfHeader, "token")
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
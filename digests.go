@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/mailer"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const (
+	digestCheckInterval = time.Minute
+	// digestSize limits news in one email, the rest is sent with the next digest.
+	digestSize = 100
+)
+
+var digestPeriods = map[string]time.Duration{
+	repository.DigestHourly: time.Hour,
+	repository.DigestDaily:  24 * time.Hour,
+	repository.DigestWeekly: 7 * 24 * time.Hour,
+}
+
+// SetMailer enables email digests sent with the mailer.
+func (app *application) SetMailer(mailer Mailer) {
+	app.mailer = mailer
+}
+
+func (app *application) digesting() {
+	if app.mailer == nil {
+		app.log.Info("Digests are disabled without smtp server")
+
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		for {
+			select {
+			case <-app.stop:
+				return
+			case now := <-ticker.C:
+				app.sendDigests(now)
+			}
+		}
+	}()
+}
+
+// sendDigests sends digests which period has passed since they were sent last time.
+func (app *application) sendDigests(now time.Time) {
+	digests, err := app.repository.GetDigests()
+	if err != nil {
+		app.log.WithError(err).Error("Failed get digests from repository")
+
+		return
+	}
+
+	for _, digest := range digests {
+		if now.Sub(digest.LastSentAt) < digestPeriods[digest.Frequency] {
+			continue
+		}
+		if err := app.sendDigest(digest, now); err != nil {
+			app.log.WithError(err).WithField("digest_id", digest.ID).Error("Failed send digest")
+		}
+	}
+}
+
+// sendDigest emails news inserted after the digest cursor and moves the cursor.
+func (app *application) sendDigest(digest repository.Digest, now time.Time) error {
+	query, err := url.ParseQuery(digest.Search)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := app.repository.GetSubscriptions(digest.UserID)
+	if err != nil {
+		return err
+	}
+
+	filter := restrictToSites(newsFilterFromQuery(query), subscriptions)
+	page, err := app.repository.GetNews(repository.NewsCursor{After: digest.LastNewsID}, digestSize, filter)
+	if err != nil {
+		return err
+	}
+	if len(page.Items) == 0 {
+		return app.repository.MarkDigestSent(digest.ID, digest.LastNewsID, now)
+	}
+
+	data := struct {
+		Digest repository.Digest
+		Search string
+		Items  []repository.NewsItem
+		More   bool
+	}{
+		digest, digestSearchTitle(query), page.Items, page.HasPrev,
+	}
+	var text, html bytes.Buffer
+	if err = app.render(&text, "digest_text.tmpl", data); err != nil {
+		return err
+	}
+	if err = app.render(&html, "digest_html.tmpl", data); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Дайджест новостей: %d", len(page.Items))
+	if data.Search != "" {
+		subject += " - " + data.Search
+	}
+	err = app.mailer.Send(mailer.Message{
+		To:      digest.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// news are ordered newest first
+	return app.repository.MarkDigestSent(digest.ID, page.Items[0].ID, now)
+}
+
+// digestSearchTitle describes the saved search of a digest.
+func digestSearchTitle(query url.Values) string {
+	var parts []string
+	if query.Get("q") != "" {
+		parts = append(parts, "«"+query.Get("q")+"»")
+	}
+	if len(query["site"]) > 0 {
+		parts = append(parts, "сайты "+strings.Join(query["site"], ", "))
+	}
+	if query.Get("image") == "1" {
+		parts = append(parts, "с изображением")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+type digestForm struct {
+	Email     string
+	Frequency string
+	Search    string
+	Errors    map[string]string
+}
+
+func (app *application) digestsHandler(res http.ResponseWriter, req *http.Request) {
+	user := app.user(req)
+	form := digestForm{Frequency: repository.DigestDaily, Search: req.FormValue("search")}
+
+	if req.Method == http.MethodPost {
+		form.Email = strings.TrimSpace(req.FormValue("email"))
+		form.Frequency = req.FormValue("frequency")
+		form.Errors = map[string]string{}
+
+		if address, err := mail.ParseAddress(form.Email); err != nil || address.Address != form.Email {
+			form.Errors["email"] = "Некорректный адрес почты"
+		}
+		if digestPeriods[form.Frequency] == 0 {
+			form.Errors["frequency"] = "Неизвестная периодичность"
+		}
+		query, err := url.ParseQuery(form.Search)
+		if err != nil {
+			form.Errors["search"] = "Некорректный поиск"
+		}
+
+		if len(form.Errors) == 0 {
+			// the first digest starts with news inserted after its creation
+			latest, err := app.repository.GetNews(repository.NewsCursor{}, 1, repository.NewsFilter{})
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+				return
+			}
+			digest := &repository.Digest{
+				UserID:     user.ID,
+				Email:      form.Email,
+				Frequency:  form.Frequency,
+				Search:     newsFilterQuery(newsFilterFromQuery(query)).Encode(),
+				LastSentAt: time.Now(),
+			}
+			if len(latest.Items) > 0 {
+				digest.LastNewsID = latest.Items[0].ID
+			}
+			err = app.repository.AddDigest(digest)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail insert digest to repository")
+
+				return
+			}
+			http.Redirect(res, req, "/digests", http.StatusSeeOther)
+
+			return
+		}
+	}
+
+	digests, err := app.repository.GetUserDigests(user.ID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get digests from repository")
+
+		return
+	}
+
+	type digestRow struct {
+		repository.Digest
+		SearchTitle string
+	}
+	rows := make([]digestRow, 0, len(digests))
+	for _, digest := range digests {
+		query, _ := url.ParseQuery(digest.Search)
+		rows = append(rows, digestRow{digest, digestSearchTitle(query)})
+	}
+
+	if len(form.Errors) > 0 {
+		res.WriteHeader(http.StatusBadRequest)
+	}
+	err = app.render(
+		res,
+		"digests.tmpl",
+		struct {
+			CSRF    string
+			User    *repository.User
+			Digests []digestRow
+			Form    digestForm
+			Enabled bool
+		}{
+			app.csrfToken(req), user, rows, form, app.mailer != nil,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
+
+func (app *application) digestDeleteHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, "/digests", http.StatusSeeOther)
+
+		return
+	}
+
+	err = app.repository.DeleteDigest(app.user(req).ID, id)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail delete digest from repository")
+
+		return
+	}
+
+	http.Redirect(res, req, "/digests", http.StatusSeeOther)
+}
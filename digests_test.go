@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/mailer"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSendDigests(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	app.mailer = new(mockedMailer)
+	now := time.Date(2019, 9, 27, 12, 0, 0, 0, time.UTC)
+
+	app.repository.(*mockedRepository).
+		On("GetDigests").
+		Return([]repository.Digest{
+			{ID: 1, UserID: 1, Email: "user@test.ru", Frequency: repository.DigestHourly, Search: "q=%D0%BD%D0%B5%D1%84%D1%82%D1%8C", LastNewsID: 10, LastSentAt: now.Add(-time.Hour)},
+			{ID: 2, UserID: 1, Email: "user@test.ru", Frequency: repository.DigestDaily, LastNewsID: 10, LastSentAt: now.Add(-time.Hour)},
+			{ID: 3, UserID: 2, Email: "other@test.ru", Frequency: repository.DigestWeekly, LastNewsID: 12, LastSentAt: now.AddDate(0, 0, -8)},
+		}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{2}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 2).
+		Return([]int{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{After: 10}, digestSize, repository.NewsFilter{Search: "нефть", SiteIDs: []int{2}}).
+		Return(repository.NewsPage{HasPrev: true, Items: []repository.NewsItem{
+			{ID: 14, Title: "Нефть <дорожает>", Link: "http://test2.ru/news/14", Date: "27.09.2019"},
+			{ID: 11, Title: "Нефть дешевеет", Link: "http://test2.ru/news/11"},
+		}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{After: 12}, digestSize, repository.NewsFilter{}).
+		Return(repository.NewsPage{}, nil)
+	app.repository.(*mockedRepository).
+		On("MarkDigestSent", 1, 14, now).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("MarkDigestSent", 3, 12, now).
+		Return(nil)
+	app.mailer.(*mockedMailer).
+		On("Send", mock.MatchedBy(func(message mailer.Message) bool {
+			return message.To == "user@test.ru" &&
+				message.Subject == "Дайджест новостей: 2 - «нефть»" &&
+				strings.Contains(message.Text, "Нефть <дорожает>\n27.09.2019\nhttp://test2.ru/news/14\n") &&
+				strings.Contains(message.Text, "остальные придут со следующим дайджестом") &&
+				strings.Contains(message.HTML, `<a href="http://test2.ru/news/14">Нефть &lt;дорожает&gt;</a>`)
+		})).
+		Return(nil)
+
+	app.sendDigests(now)
+
+	app.mailer.(*mockedMailer).AssertNumberOfCalls(t, "Send", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "MarkDigestSent", 2)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetNews", 2)
+}
+
+func TestSendDigestFailure(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	app.mailer = new(mockedMailer)
+	digest := repository.Digest{ID: 1, UserID: 1, Email: "user@test.ru", Frequency: repository.DigestDaily, LastNewsID: 10}
+
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{After: 10}, digestSize, repository.NewsFilter{}).
+		Return(repository.NewsPage{Items: []repository.NewsItem{{ID: 11, Title: "Заголовок"}}}, nil)
+	app.mailer.(*mockedMailer).
+		On("Send", mock.Anything).
+		Return(errors.New("test smtp error"))
+
+	err := app.sendDigest(digest, time.Now())
+	assert.EqualError(t, err, "test smtp error")
+	app.repository.(*mockedRepository).AssertNotCalled(t, "MarkDigestSent", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDigestSearchTitle(t *testing.T) {
+	query, _ := url.ParseQuery("q=test&site=1&site=2&image=1&from=2019-09-01")
+	assert.Equal(t, "«test», сайты 1, 2, с изображением", digestSearchTitle(query))
+	assert.Equal(t, "", digestSearchTitle(url.Values{}))
+}
+
+func TestDigestsHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1, Login: "user"}
+
+	app.repository.(*mockedRepository).
+		On("GetUserDigests", 1).
+		Return([]repository.Digest{{ID: 1, Email: "user@test.ru", Frequency: repository.DigestWeekly, Search: "q=test"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 1, repository.NewsFilter{}).
+		Return(repository.NewsPage{Items: []repository.NewsItem{{ID: 20}}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddDigest", mock.MatchedBy(func(digest *repository.Digest) bool {
+			return digest.UserID == 1 && digest.Email == "user@test.ru" && digest.Frequency == repository.DigestHourly &&
+				digest.Search == "q=test&site=2" && digest.LastNewsID == 20
+		})).
+		Return(nil)
+	handler := http.HandlerFunc(app.digestsHandler)
+
+	req, _ := http.NewRequest("GET", "/digests?search=q%3Dtest%26site%3D2%26before%3D5", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "«test»")
+	assert.Contains(t, rr.Body.String(), "Отправка почты не настроена")
+	assert.Contains(t, rr.Body.String(), `name="search" value="q=test&amp;site=2&amp;before=5"`)
+
+	reader := strings.NewReader("email=user%40test.ru&frequency=hourly&search=q%3Dtest%26site%3D2%26before%3D5")
+	req, _ = http.NewRequest("POST", "/digests", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddDigest", 1)
+
+	reader = strings.NewReader("email=User+%3Cuser%40test.ru%3E&frequency=monthly")
+	req, _ = http.NewRequest("POST", "/digests", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Некорректный адрес почты")
+	assert.Contains(t, rr.Body.String(), "Неизвестная периодичность")
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddDigest", 1)
+}
+
+func TestDigestDeleteHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("DeleteDigest", 1, 3).
+		Return(nil)
+
+	req, _ := http.NewRequest("POST", "/digests/delete", strings.NewReader("id=3"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.digestDeleteHandler).ServeHTTP(rr, withUser(req, &repository.User{ID: 1}))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "DeleteDigest", 1)
+}
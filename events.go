@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const (
+	// eventBuffer is the number of news a slow subscriber may lag behind before news are dropped for it.
+	eventBuffer    = 64
+	eventHeartbeat = 30 * time.Second
+)
+
+// eventBus broadcasts inserted news to subscribers in process.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan repository.NewsItem]bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: map[chan repository.NewsItem]bool{}}
+}
+
+func (bus *eventBus) subscribe() chan repository.NewsItem {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	events := make(chan repository.NewsItem, eventBuffer)
+	bus.subscribers[events] = true
+
+	return events
+}
+
+func (bus *eventBus) unsubscribe(events chan repository.NewsItem) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	delete(bus.subscribers, events)
+}
+
+// publish sends the news item to subscribers without waiting for slow ones.
+func (bus *eventBus) publish(item repository.NewsItem) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for events := range bus.subscribers {
+		select {
+		case events <- item:
+		default:
+		}
+	}
+}
+
+// matchNewsFilter reports whether the news item passes the filter as GetNews would select it.
+func matchNewsFilter(filter repository.NewsFilter, item repository.NewsItem) bool {
+	if filter.Search != "" && !strings.Contains(strings.ToLower(item.Title), strings.ToLower(filter.Search)) {
+		return false
+	}
+	if len(filter.SiteIDs) > 0 {
+		found := false
+		for _, id := range filter.SiteIDs {
+			found = found || id == item.SiteID
+		}
+		if !found {
+			return false
+		}
+	}
+	if !filter.From.IsZero() && item.PublishedAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !item.PublishedAt.Before(filter.To) {
+		return false
+	}
+	if filter.HasImage && item.Image == "" {
+		return false
+	}
+	if filter.Tag != "" && !hasTag(item.Tags, filter.Tag) {
+		return false
+	}
+
+	return true
+}
+
+// eventsHandler streams news inserted from now on as server-sent events,
+// filtered by the news filter query parameters and subscriptions of the user.
+func (app *application) eventsHandler(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).Error("Fail stream events, response writer is not a flusher")
+
+		return
+	}
+
+	filter := newsFilterFromQuery(req.URL.Query())
+	if user := app.user(req); user != nil {
+		subscriptions, err := app.repository.GetSubscriptions(user.ID)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail get subscriptions from repository")
+
+			return
+		}
+		filter = restrictToSites(filter, subscriptions)
+	}
+
+	events := app.events.subscribe()
+	defer app.events.unsubscribe(events)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-app.stop:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": heartbeat\n\n")
+		case item := <-events:
+			if !matchNewsFilter(filter, item) {
+				continue
+			}
+			data, err := json.Marshal(newApiNewsItem(item))
+			if err != nil {
+				app.requestLog(req).WithError(err).Error("Fail encode event")
+				continue
+			}
+			fmt.Fprintf(res, "id: %d\nevent: news\ndata: %s\n\n", item.ID, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	first := bus.subscribe()
+	second := bus.subscribe()
+
+	bus.publish(repository.NewsItem{ID: 1})
+	assert.Equal(t, 1, (<-first).ID)
+	assert.Equal(t, 1, (<-second).ID)
+
+	bus.unsubscribe(second)
+	for i := 0; i < eventBuffer+10; i++ {
+		bus.publish(repository.NewsItem{ID: i})
+	}
+	assert.Len(t, first, eventBuffer)
+	assert.Len(t, second, 0)
+}
+
+func TestMatchNewsFilter(t *testing.T) {
+	item := repository.NewsItem{
+		SiteID:      2,
+		Title:       "Цены на Нефть",
+		PublishedAt: time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC),
+		Tags:        []string{"экономика", "нефть"},
+	}
+
+	assert.True(t, matchNewsFilter(repository.NewsFilter{}, item))
+	assert.True(t, matchNewsFilter(repository.NewsFilter{
+		Search:  "нефть",
+		SiteIDs: []int{1, 2},
+		From:    time.Date(2019, 9, 27, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2019, 9, 28, 0, 0, 0, 0, time.UTC),
+	}, item))
+	assert.False(t, matchNewsFilter(repository.NewsFilter{Search: "газ"}, item))
+	assert.False(t, matchNewsFilter(repository.NewsFilter{SiteIDs: []int{1}}, item))
+	assert.False(t, matchNewsFilter(repository.NewsFilter{To: time.Date(2019, 9, 27, 0, 0, 0, 0, time.UTC)}, item))
+	assert.False(t, matchNewsFilter(repository.NewsFilter{HasImage: true}, item))
+	assert.True(t, matchNewsFilter(repository.NewsFilter{Tag: "нефть"}, item))
+	assert.False(t, matchNewsFilter(repository.NewsFilter{Tag: "спорт"}, item))
+}
+
+func TestEventsHandler(t *testing.T) {
+	app := getApplication()
+	server := httptest.NewServer(app.instrument("events", app.eventsHandler))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/events?site=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	app.events.publish(repository.NewsItem{ID: 5, SiteID: 1, Title: "Другой сайт"})
+	app.events.publish(repository.NewsItem{ID: 6, SiteID: 2, Title: "Заголовок", Link: "http://test2.ru/news/6"})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var event []string
+	for len(event) < 3 {
+		select {
+		case line := <-lines:
+			event = append(event, line)
+		case <-time.After(time.Second):
+			t.Fatal("event is not received")
+		}
+	}
+	assert.Equal(t, "id: 6", event[0])
+	assert.Equal(t, "event: news", event[1])
+	assert.True(t, strings.HasPrefix(event[2], `data: {"id":6,"site_id":2,"title":"Заголовок"`))
+}
+
+func TestParseSitePublishes(t *testing.T) {
+	app := getApplication()
+	site := repository.Site{ID: 1, Url: "http://test1.ru", Type: parser.TypeRss}
+	app.parser.(*mockedParser).
+		On("Parse", site).
+		Return([]repository.NewsItem{{Link: "http://test1.ru/news/1"}, {Link: "http://test1.ru/news/2"}}, parser.FetchInfo{StatusCode: http.StatusOK}, nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.Link == "http://test1.ru/news/1" })).
+		Return(true, nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.Link == "http://test1.ru/news/2" })).
+		Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.Anything).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddParseRun", mock.Anything).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("GetSiteFilters", site.ID).
+		Return([]repository.SiteFilter{}, nil)
+
+	events := app.events.subscribe()
+	inserted := app.parseSite(site)
+	assert.Len(t, inserted, 1)
+	assert.Len(t, events, 1)
+	assert.Equal(t, repository.NewsItem{SiteID: 1, Link: "http://test1.ru/news/2"}, <-events)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// exportBatchSize is the number of news read from the repository at once while exporting.
+const exportBatchSize = 500
+
+// exportRowGroupSize is the size in bytes of a row group of parquet exports,
+// it bounds the memory taken by an export.
+const exportRowGroupSize = 32 << 20
+
+// exportFormats maps export formats to their content types.
+var exportFormats = map[string]string{
+	"jsonl":   "application/x-ndjson",
+	"csv":     "text/csv; charset=utf-8",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportRow is an exported news item with the metadata of its site.
+type exportRow struct {
+	ID            int       `json:"id"`
+	SiteID        int       `json:"site_id"`
+	SiteUrl       string    `json:"site_url"`
+	SiteHost      string    `json:"site_host"`
+	SiteType      string    `json:"site_type"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Link          string    `json:"link"`
+	CanonicalLink string    `json:"canonical_link"`
+	Date          string    `json:"date"`
+	Image         string    `json:"image"`
+	PublishedAt   time.Time `json:"published_at"`
+	StoryID       int       `json:"story_id"`
+	Tags          []string  `json:"tags"`
+	ContentText   string    `json:"content_text"`
+}
+
+func newExportRow(item repository.NewsItem, site repository.Site) exportRow {
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	host := ""
+	if siteUrl, err := url.Parse(site.Url); err == nil {
+		host = siteUrl.Hostname()
+	}
+
+	return exportRow{
+		ID:            item.ID,
+		SiteID:        item.SiteID,
+		SiteUrl:       site.Url,
+		SiteHost:      host,
+		SiteType:      site.Type,
+		Title:         item.Title,
+		Description:   item.Description,
+		Link:          item.Link,
+		CanonicalLink: item.CanonicalLink,
+		Date:          item.Date,
+		Image:         item.Image,
+		PublishedAt:   item.PublishedAt,
+		StoryID:       item.Story(),
+		Tags:          tags,
+		ContentText:   item.ContentText,
+	}
+}
+
+// parquet types of export columns, timestamps are stored in milliseconds since the Unix epoch
+const (
+	parquetInt64     = "type=INT64"
+	parquetString    = "type=BYTE_ARRAY, convertedtype=UTF8"
+	parquetTimestamp = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+)
+
+// exportColumn is a column of csv and parquet exports.
+type exportColumn struct {
+	Name        string
+	ParquetType string
+}
+
+// exportColumns are the columns of csv and parquet exports, tags are joined with commas.
+var exportColumns = []exportColumn{
+	{"id", parquetInt64},
+	{"site_id", parquetInt64},
+	{"site_url", parquetString},
+	{"site_host", parquetString},
+	{"site_type", parquetString},
+	{"title", parquetString},
+	{"description", parquetString},
+	{"link", parquetString},
+	{"canonical_link", parquetString},
+	{"date", parquetString},
+	{"image", parquetString},
+	{"published_at", parquetTimestamp},
+	{"story_id", parquetInt64},
+	{"tags", parquetString},
+	{"content_text", parquetString},
+}
+
+// values returns the values of the row in the order of exportColumns.
+func (row exportRow) values() []interface{} {
+	return []interface{}{
+		int64(row.ID),
+		int64(row.SiteID),
+		row.SiteUrl,
+		row.SiteHost,
+		row.SiteType,
+		row.Title,
+		row.Description,
+		row.Link,
+		row.CanonicalLink,
+		row.Date,
+		row.Image,
+		row.PublishedAt,
+		int64(row.StoryID),
+		strings.Join(row.Tags, ","),
+		row.ContentText,
+	}
+}
+
+// exportWriter encodes exported rows, flush is called after every batch of news.
+type exportWriter interface {
+	write(row exportRow) error
+	flush() error
+	close() error
+}
+
+type jsonlExport struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (export *jsonlExport) write(row exportRow) error {
+	return export.encoder.Encode(row)
+}
+
+func (export *jsonlExport) flush() error {
+	return export.buf.Flush()
+}
+
+func (export *jsonlExport) close() error {
+	return export.buf.Flush()
+}
+
+type csvExport struct {
+	writer *csv.Writer
+}
+
+func (export *csvExport) write(row exportRow) error {
+	record := make([]string, 0, len(exportColumns))
+	for _, value := range row.values() {
+		switch value := value.(type) {
+		case string:
+			record = append(record, value)
+		case int64:
+			record = append(record, strconv.FormatInt(value, 10))
+		case time.Time:
+			record = append(record, value.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return export.writer.Write(record)
+}
+
+func (export *csvExport) flush() error {
+	export.writer.Flush()
+
+	return export.writer.Error()
+}
+
+func (export *csvExport) close() error {
+	return export.flush()
+}
+
+type parquetExport struct {
+	writer *writer.CSVWriter
+}
+
+func (export *parquetExport) write(row exportRow) error {
+	values := row.values()
+	for i, value := range values {
+		if date, ok := value.(time.Time); ok {
+			values[i] = date.UnixNano() / int64(time.Millisecond)
+		}
+	}
+
+	return export.writer.Write(values)
+}
+
+// flush leaves rows buffered until the row group is complete.
+func (export *parquetExport) flush() error {
+	return nil
+}
+
+func (export *parquetExport) close() error {
+	return export.writer.WriteStop()
+}
+
+func newExportWriter(out io.Writer, format string) (exportWriter, error) {
+	switch format {
+	case "jsonl":
+		buf := bufio.NewWriter(out)
+
+		return &jsonlExport{buf: buf, encoder: json.NewEncoder(buf)}, nil
+	case "csv":
+		writer := csv.NewWriter(out)
+		header := make([]string, 0, len(exportColumns))
+		for _, column := range exportColumns {
+			header = append(header, column.Name)
+		}
+
+		return &csvExport{writer: writer}, writer.Write(header)
+	case "parquet":
+		schema := make([]string, 0, len(exportColumns))
+		for _, column := range exportColumns {
+			schema = append(schema, "name="+column.Name+", "+column.ParquetType)
+		}
+		pw, err := writer.NewCSVWriterFromWriter(schema, out, 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = exportRowGroupSize
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+		return &parquetExport{writer: pw}, nil
+	}
+
+	return nil, errors.New("unknown export format " + format)
+}
+
+// exportSites returns the sites of exported news by id.
+func (app *application) exportSites() (map[int]repository.Site, error) {
+	list, err := app.repository.GetSites()
+	if err != nil {
+		return nil, err
+	}
+	sites := make(map[int]repository.Site, len(list))
+	for _, site := range list {
+		sites[site.ID] = site
+	}
+
+	return sites, nil
+}
+
+// exportNews writes news matching the filter in ascending order of ids to out in the format,
+// reading them from the repository in batches. flushed, if not nil, is called after every batch.
+// It returns the number of exported news.
+func (app *application) exportNews(out io.Writer, format string, filter repository.NewsFilter, sites map[int]repository.Site, flushed func()) (count int, err error) {
+	writer, err := newExportWriter(out, format)
+	if err != nil {
+		return 0, err
+	}
+
+	last := 0
+	for {
+		news, err := app.repository.GetNewsAfter(last, exportBatchSize, filter)
+		if err != nil {
+			return count, err
+		}
+		for _, item := range news {
+			err = writer.write(newExportRow(item, sites[item.SiteID]))
+			if err != nil {
+				return count, err
+			}
+			last = item.ID
+			count++
+		}
+		err = writer.flush()
+		if err != nil {
+			return count, err
+		}
+		if flushed != nil {
+			flushed()
+		}
+		if len(news) < exportBatchSize {
+			break
+		}
+	}
+
+	return count, writer.close()
+}
+
+func (app *application) apiExportHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(res, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	query := req.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	contentType, ok := exportFormats[format]
+	if !ok {
+		writeJSONError(res, http.StatusBadRequest, "unknown format, use jsonl, csv or parquet")
+
+		return
+	}
+
+	user := app.user(req)
+	filter := userNewsFilter(newsFilterFromQuery(query), query, user)
+	if query.Get("starred") == "1" {
+		filter.StarredBy = user.ID
+	}
+	sites, err := app.exportSites()
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail get sites from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Content-Disposition", `attachment; filename="news.`+format+`"`)
+	var flushed func()
+	if flusher, ok := res.(http.Flusher); ok {
+		flushed = flusher.Flush
+	}
+	count, err := app.exportNews(res, format, filter, sites, flushed)
+	if err != nil {
+		// the response is already started, the client gets a truncated export
+		app.requestLog(req).WithError(err).WithField("exported", count).Error("Fail export news")
+	}
+}
+
+// exportCommand runs the export command line: it writes news matching the filter flags
+// to the output file or out.
+func (app *application) exportCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "Export format (jsonl, csv, parquet)")
+	output := flags.String("o", "", "Output file, standard output if empty")
+	sites := flags.String("site", "", "Comma separated site IDs, all sites if empty")
+	from := flags.String("from", "", "First publication date, YYYY-MM-DD")
+	to := flags.String("to", "", "Last publication date, YYYY-MM-DD")
+	search := flags.String("q", "", "Search query")
+	tag := flags.String("tag", "", "Tag")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if _, ok := exportFormats[*format]; !ok {
+		return errors.New("unknown export format " + *format)
+	}
+
+	query := url.Values{"q": {*search}, "from": {*from}, "to": {*to}, "tag": {*tag}}
+	for _, id := range parseIDs(*sites) {
+		query.Add("site", strconv.Itoa(id))
+	}
+	filter := newsFilterFromQuery(query)
+	siteMap, err := app.exportSites()
+	if err != nil {
+		return err
+	}
+
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+	count, err := app.exportNews(out, *format, filter, siteMap, nil)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	app.log.WithField("exported", count).Info("Exported news")
+
+	return nil
+}
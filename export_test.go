@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var exportPublished = time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC)
+
+func exportApplication() *application {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 1, Url: "http://test1.ru/news", Type: parser.TypeRss}, {ID: 2, Url: "https://test2.ru", Type: parser.TypeHtml}}, nil)
+
+	return app
+}
+
+func TestApiExportHandler(t *testing.T) {
+	app := exportApplication()
+	batch := make([]repository.NewsItem, exportBatchSize)
+	for i := range batch {
+		batch[i] = repository.NewsItem{ID: i + 1, SiteID: 2, Title: "Курс", Link: "https://test2.ru/news", PublishedAt: exportPublished}
+	}
+	filter := repository.NewsFilter{Search: "нефть", SiteIDs: []int{1, 2}, From: time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)}
+	app.repository.(*mockedRepository).
+		On("GetNewsAfter", 0, exportBatchSize, filter).
+		Return(batch, nil)
+	app.repository.(*mockedRepository).
+		On("GetNewsAfter", exportBatchSize, exportBatchSize, filter).
+		Return([]repository.NewsItem{
+			{ID: 600, SiteID: 1, Title: "Нефть, \"Brent\"", Link: "http://test1.ru/news/600", StoryID: 7, PublishedAt: exportPublished, Tags: []string{"экономика", "нефть"}},
+		}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/export?format=jsonl&q=%D0%BD%D0%B5%D1%84%D1%82%D1%8C&site=1&site=2&from=2019-09-01", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.apiExportHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="news.jsonl"`, rr.Header().Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+	assert.Len(t, lines, exportBatchSize+1)
+	assert.JSONEq(t, `{
+		"id": 600, "site_id": 1, "site_url": "http://test1.ru/news", "site_host": "test1.ru", "site_type": "rss",
+		"title": "Нефть, \"Brent\"", "description": "", "link": "http://test1.ru/news/600", "canonical_link": "",
+		"date": "", "image": "", "published_at": "2019-09-27T04:00:00Z", "story_id": 7,
+		"tags": ["экономика", "нефть"], "content_text": ""
+	}`, lines[exportBatchSize])
+
+	req, _ = http.NewRequest("GET", "/api/export?format=csv&q=%D0%BD%D0%B5%D1%84%D1%82%D1%8C&site=1&site=2&from=2019-09-01", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiExportHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, records, exportBatchSize+2)
+	assert.Equal(t, []string{"id", "site_id", "site_url", "site_host", "site_type", "title", "description", "link", "canonical_link", "date", "image", "published_at", "story_id", "tags", "content_text"}, records[0])
+	assert.Equal(t, []string{"1", "2", "https://test2.ru", "test2.ru", "html", "Курс", "", "https://test2.ru/news", "", "", "", "2019-09-27T04:00:00Z", "1", "", ""}, records[1])
+	assert.Equal(t, []string{"600", "1", "http://test1.ru/news", "test1.ru", "rss", "Нефть, \"Brent\"", "", "http://test1.ru/news/600", "", "", "", "2019-09-27T04:00:00Z", "7", "экономика,нефть", ""}, records[exportBatchSize+1])
+
+	req, _ = http.NewRequest("GET", "/api/export?format=xml", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiExportHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/api/export", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.apiExportHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestApiExportHandlerParquet(t *testing.T) {
+	app := exportApplication()
+	user := &repository.User{ID: 3}
+	app.repository.(*mockedRepository).
+		On("GetNewsAfter", 0, exportBatchSize, repository.NewsFilter{StarredBy: 3}).
+		Return([]repository.NewsItem{
+			{ID: 5, SiteID: 1, Title: "Нефть", PublishedAt: exportPublished, Tags: []string{"экономика", "нефть"}},
+			{ID: 6, SiteID: 2, Title: "Курс", Link: "https://test2.ru/news", StoryID: 5, PublishedAt: exportPublished.Add(time.Hour)},
+		}, nil)
+
+	req, _ := http.NewRequest("GET", "/api/export?format=parquet&starred=1", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.apiExportHandler).ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/vnd.apache.parquet", rr.Header().Get("Content-Type"))
+
+	file, err := buffer.NewBufferFile(rr.Body.Bytes())
+	assert.Nil(t, err)
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), pr.GetNumRows())
+	var names []string
+	for i := range exportColumns {
+		names = append(names, pr.SchemaHandler.GetExName(i+1))
+	}
+	assert.Equal(t, []string{"id", "site_id", "site_url", "site_host", "site_type", "title", "description", "link", "canonical_link", "date", "image", "published_at", "story_id", "tags", "content_text"}, names)
+
+	columns := make([][]interface{}, len(exportColumns))
+	for i := range exportColumns {
+		columns[i], _, _, err = pr.ReadColumnByIndex(int64(i), 2)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []interface{}{int64(5), int64(6)}, columns[0])
+	assert.Equal(t, []interface{}{"test1.ru", "test2.ru"}, columns[3])
+	assert.Equal(t, []interface{}{"Нефть", "Курс"}, columns[5])
+	assert.Equal(t, []interface{}{exportPublished.Unix() * 1000, exportPublished.Add(time.Hour).Unix() * 1000}, columns[11])
+	assert.Equal(t, []interface{}{int64(5), int64(5)}, columns[12])
+	assert.Equal(t, []interface{}{"экономика,нефть", ""}, columns[13])
+}
+
+func TestApiExportRequiresToken(t *testing.T) {
+	app := getApplication()
+
+	req, _ := http.NewRequest("GET", "/api/export", nil)
+	rr := httptest.NewRecorder()
+	app.requireScope(repository.ScopeRead, app.apiExportHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestExportCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	app := exportApplication()
+	app.repository.(*mockedRepository).
+		On("GetNewsAfter", 0, exportBatchSize, repository.NewsFilter{
+			SiteIDs: []int{1},
+			To:      time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC),
+			Tag:     "экономика",
+		}).
+		Return([]repository.NewsItem{{ID: 5, SiteID: 1, Title: "Нефть", PublishedAt: exportPublished}}, nil)
+
+	output := filepath.Join(dir, "news.csv")
+	err = app.exportCommand([]string{"-format", "csv", "-site", "1", "-to", "2019-09-30", "-tag", "Экономика", "-o", output}, nil)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "5,1,http://test1.ru/news,test1.ru,rss,Нефть,")
+
+	var out bytes.Buffer
+	err = app.exportCommand([]string{"-format", "csv", "-site", "1", "-to", "2019-09-30", "-tag", "экономика"}, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), out.String())
+
+	err = app.exportCommand([]string{"-format", "xml"}, &out)
+	assert.EqualError(t, err, "unknown export format xml")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/onauryzbaev/go_news_final_/pipeline"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// filterTestSize is the number of recent news of the site a filter is tested against.
+const filterTestSize = 50
+
+type compiledFilter struct {
+	filter repository.SiteFilter
+	match  alertMatcher
+}
+
+// siteFilters decides which news of the site are inserted.
+type siteFilters []compiledFilter
+
+// filterText is the text of the news item field the filter is matched against.
+func filterText(field string, item repository.NewsItem) string {
+	switch field {
+	case repository.FilterTitle:
+		return item.Title
+	case repository.FilterDescription:
+		return item.Description
+	case repository.FilterLink:
+		return item.Link
+	case repository.FilterCategory:
+		return strings.Join(item.Tags, "\n")
+	}
+
+	return alertText(item)
+}
+
+func (filter compiledFilter) matches(item repository.NewsItem) bool {
+	return filter.match(filterText(filter.filter.Field, item))
+}
+
+// keep reports whether the news item matches one of the include filters, if there are any,
+// and none of the exclude filters.
+func (filters siteFilters) keep(item repository.NewsItem) bool {
+	included, hasInclude := false, false
+	for _, filter := range filters {
+		switch filter.filter.Action {
+		case repository.FilterInclude:
+			hasInclude = true
+			if !included && filter.matches(item) {
+				included = true
+			}
+		case repository.FilterExclude:
+			if filter.matches(item) {
+				return false
+			}
+		}
+	}
+
+	return included || !hasInclude
+}
+
+// compileSiteFilters compiles the filters of the site, skipping filters that fail to compile.
+func (app *application) compileSiteFilters(filters []repository.SiteFilter) siteFilters {
+	compiled := make(siteFilters, 0, len(filters))
+	for _, filter := range filters {
+		match, err := compileMatcher(filter.Kind, filter.Query)
+		if err != nil {
+			app.log.WithError(err).WithField("filter_id", filter.ID).Warn("Skip invalid site filter")
+			continue
+		}
+		compiled = append(compiled, compiledFilter{filter, match})
+	}
+
+	return compiled
+}
+
+// filterSiteNews transforms news with the processors of the site, drops news rejected
+// by the processors or the filters of the site and returns the number of dropped news.
+func (app *application) filterSiteNews(site repository.Site, news []repository.NewsItem) (kept []repository.NewsItem, filtered int, err error) {
+	filters, err := app.repository.GetSiteFilters(site.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	processors, err := pipeline.Parse(site.Processors)
+	if err != nil {
+		// sites are validated on creation, a broken configuration must not stop parsing the site
+		app.log.WithError(err).WithField("site_id", site.ID).Warn("Skip invalid site processors")
+		processors = nil
+	}
+	if len(filters) == 0 && len(processors) == 0 {
+		return news, 0, nil
+	}
+
+	compiled := app.compileSiteFilters(filters)
+	for _, item := range news {
+		if processors.Process(&item) && compiled.keep(item) {
+			kept = append(kept, item)
+		}
+	}
+
+	return kept, len(news) - len(kept), nil
+}
+
+// filterTestRow is a recent news item of the site with the result of the tested filter.
+type filterTestRow struct {
+	Item    repository.NewsItem
+	Matched bool
+	Kept    bool
+}
+
+func (app *application) siteFiltersHandler(res http.ResponseWriter, req *http.Request) {
+	siteID, err := strconv.Atoi(req.FormValue("site"))
+	if err != nil {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	}
+	site, err := app.repository.GetSite(siteID)
+	if err == repository.ErrNotFound {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get site from repository")
+
+		return
+	}
+
+	filters, err := app.repository.GetSiteFilters(site.ID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get site filters from repository")
+
+		return
+	}
+
+	form := repository.SiteFilter{Action: repository.FilterExclude, Field: repository.FilterText, Kind: repository.AlertKeyword}
+	var errors map[string]string
+	var tested []filterTestRow
+	testing := false
+
+	if req.Method == http.MethodPost {
+		form.SiteID = site.ID
+		form.Action = req.FormValue("action")
+		form.Field = req.FormValue("field")
+		form.Kind = req.FormValue("kind")
+		form.Query = strings.TrimSpace(req.FormValue("query"))
+		testing = req.FormValue("test") == "1"
+
+		errors = validateSiteFilter(form)
+		if len(errors) == 0 && testing {
+			tested, err = app.testSiteFilter(site, filters, form)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+				return
+			}
+		}
+		if len(errors) == 0 && !testing {
+			err = app.repository.AddSiteFilter(&form)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail insert site filter to repository")
+
+				return
+			}
+			http.Redirect(res, req, "/sites/filters?site="+strconv.Itoa(site.ID), http.StatusSeeOther)
+
+			return
+		}
+	}
+
+	if len(errors) > 0 {
+		res.WriteHeader(http.StatusBadRequest)
+	}
+	err = app.render(
+		res,
+		"site_filters.tmpl",
+		struct {
+			CSRF    string
+			User    *repository.User
+			Site    repository.Site
+			Filters []repository.SiteFilter
+			Form    repository.SiteFilter
+			Errors  map[string]string
+			Testing bool
+			Tested  []filterTestRow
+		}{
+			app.csrfToken(req), app.user(req), site, filters, form, errors, testing, tested,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
+
+// testSiteFilter evaluates the candidate filter against recent news of the site,
+// together with the existing filters of the site.
+func (app *application) testSiteFilter(site repository.Site, filters []repository.SiteFilter, candidate repository.SiteFilter) ([]filterTestRow, error) {
+	page, err := app.repository.GetNews(repository.NewsCursor{}, filterTestSize, repository.NewsFilter{SiteIDs: []int{site.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := app.compileSiteFilters(append(filters, candidate))
+	candidateFilter := compiled[len(compiled)-1]
+	rows := make([]filterTestRow, 0, len(page.Items))
+	for _, item := range page.Items {
+		rows = append(rows, filterTestRow{
+			Item:    item,
+			Matched: candidateFilter.matches(item),
+			Kept:    compiled.keep(item),
+		})
+	}
+
+	return rows, nil
+}
+
+func (app *application) siteFilterDeleteHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	siteID, err := strconv.Atoi(req.FormValue("site"))
+	if err != nil {
+		http.Redirect(res, req, "/sites", http.StatusSeeOther)
+
+		return
+	}
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err == nil {
+		err = app.repository.DeleteSiteFilter(siteID, id)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail delete site filter from repository")
+
+			return
+		}
+	}
+
+	http.Redirect(res, req, "/sites/filters?site="+strconv.Itoa(siteID), http.StatusSeeOther)
+}
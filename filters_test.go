@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSiteFiltersKeep(t *testing.T) {
+	app := getApplication()
+	sponsored := repository.NewsItem{Title: "Реклама: лучший банк", Link: "https://news.ru/1"}
+	oil := repository.NewsItem{Title: "Нефть дорожает", Description: "Brent выше 90", Link: "https://news.ru/economy/2"}
+	sport := repository.NewsItem{Title: "Матч перенесён", Link: "https://news.ru/sport/3"}
+
+	assert.True(t, app.compileSiteFilters(nil).keep(sponsored))
+
+	exclude := app.compileSiteFilters([]repository.SiteFilter{
+		{Action: repository.FilterExclude, Field: repository.FilterTitle, Kind: repository.AlertKeyword, Query: "реклама"},
+	})
+	assert.False(t, exclude.keep(sponsored))
+	assert.True(t, exclude.keep(oil))
+
+	include := app.compileSiteFilters([]repository.SiteFilter{
+		{Action: repository.FilterInclude, Field: repository.FilterLink, Kind: repository.AlertRegex, Query: `/economy/`},
+		{Action: repository.FilterInclude, Field: repository.FilterText, Kind: repository.AlertKeyword, Query: "реклама"},
+		{Action: repository.FilterExclude, Field: repository.FilterDescription, Kind: repository.AlertQuery, Query: "brent"},
+		{Action: repository.FilterExclude, Field: repository.FilterTitle, Kind: repository.AlertRegex, Query: "("},
+	})
+	assert.Len(t, include, 3)
+	assert.True(t, include.keep(sponsored))
+	assert.False(t, include.keep(oil))
+	assert.False(t, include.keep(sport))
+
+	category := app.compileSiteFilters([]repository.SiteFilter{
+		{Action: repository.FilterInclude, Field: repository.FilterCategory, Kind: repository.AlertKeyword, Query: "экономика"},
+	})
+	oil.Tags = []string{"Спорт и экономика"}
+	sport.Tags = []string{"Экономика", "Спорт"}
+	assert.True(t, category.keep(oil))
+	assert.True(t, category.keep(sport))
+	assert.False(t, category.keep(sponsored))
+}
+
+func TestParseSiteFilters(t *testing.T) {
+	app := getApplication()
+	site := repository.Site{ID: 1, Url: "http://test1.ru", Type: parser.TypeRss}
+	app.parser.(*mockedParser).
+		On("Parse", site).
+		Return([]repository.NewsItem{{Link: "http://test1.ru/news/1", Title: "Реклама"}, {Link: "http://test1.ru/news/2", Title: "Новости"}}, parser.FetchInfo{StatusCode: http.StatusOK}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSiteFilters", 1).
+		Return([]repository.SiteFilter{{Action: repository.FilterExclude, Field: repository.FilterTitle, Kind: repository.AlertKeyword, Query: "реклама"}}, nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return item.Link == "http://test1.ru/news/2" })).
+		Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.Anything).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("AddParseRun", mock.MatchedBy(func(run *repository.ParseRun) bool {
+			return run.ItemsFound == 2 && run.Filtered == 1 && run.Inserted == 1
+		})).
+		Return(nil)
+
+	inserted := app.parseSite(site)
+	assert.Len(t, inserted, 1)
+	assert.Equal(t, "http://test1.ru/news/2", inserted[0].Link)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "HasNewsItem", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddParseRun", 1)
+}
+
+func TestSiteFiltersHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1, Login: "admin", IsAdmin: true}
+	site := repository.Site{ID: 2, Url: "http://test2.ru", Type: parser.TypeRss}
+
+	app.repository.(*mockedRepository).On("GetSite", 2).Return(site, nil)
+	app.repository.(*mockedRepository).On("GetSite", 3).Return(repository.Site{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetSiteFilters", 2).
+		Return([]repository.SiteFilter{{ID: 5, SiteID: 2, Action: repository.FilterExclude, Field: repository.FilterTitle, Kind: repository.AlertKeyword, Query: "спорт"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, filterTestSize, repository.NewsFilter{SiteIDs: []int{2}}).
+		Return(repository.NewsPage{Items: []repository.NewsItem{
+			{ID: 1, Title: "Реклама банка", Link: "http://test2.ru/1"},
+			{ID: 2, Title: "Спорт: итоги", Link: "http://test2.ru/2"},
+			{ID: 3, Title: "Погода", Link: "http://test2.ru/3"},
+		}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddSiteFilter", mock.MatchedBy(func(filter *repository.SiteFilter) bool {
+			return filter.SiteID == 2 && filter.Action == repository.FilterExclude && filter.Query == "реклама"
+		})).
+		Return(nil)
+	handler := http.HandlerFunc(app.siteFiltersHandler)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/sites/filters", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withUser(req, user))
+
+		return rr
+	}
+
+	req, _ := http.NewRequest("GET", "/sites/filters?site=2", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "keyword: спорт")
+
+	req, _ = http.NewRequest("GET", "/sites/filters?site=3", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = post("site=2&action=exclude&field=title&kind=keyword&query=%D1%80%D0%B5%D0%BA%D0%BB%D0%B0%D0%BC%D0%B0&test=1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 2, strings.Count(rr.Body.String(), "<tr class=\"dropped\">"))
+	assert.Contains(t, rr.Body.String(), "Реклама банка")
+	app.repository.(*mockedRepository).AssertNotCalled(t, "AddSiteFilter", mock.Anything)
+
+	rr = post("site=2&action=exclude&field=title&kind=regex&query=%28")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Некорректный запрос")
+
+	rr = post("site=2&action=exclude&field=title&kind=regex&query=%3Cscript%3E%28")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "&lt;script&gt;(")
+	assert.NotContains(t, rr.Body.String(), "<script>")
+
+	rr = post("site=2&action=exclude&field=title&kind=keyword&query=%D1%80%D0%B5%D0%BA%D0%BB%D0%B0%D0%BC%D0%B0")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/sites/filters?site=2", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddSiteFilter", 1)
+}
+
+func TestSiteFilterDeleteHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).On("DeleteSiteFilter", 2, 5).Return(nil)
+
+	req, _ := http.NewRequest("POST", "/sites/filters/delete", strings.NewReader("site=2&id=5"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.siteFilterDeleteHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/sites/filters?site=2", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertCalled(t, "DeleteSiteFilter", 2, 5)
+}
+
+func TestFilterSiteNewsProcessors(t *testing.T) {
+	app := getApplication()
+	site := repository.Site{ID: 1, Processors: "replace title ^\\d{2}\\.\\d{2}\\s+\ndrop title ^Реклама"}
+	app.repository.(*mockedRepository).
+		On("GetSiteFilters", 1).
+		Return([]repository.SiteFilter{{Action: repository.FilterExclude, Field: repository.FilterTitle, Kind: repository.AlertKeyword, Query: "спорт"}}, nil)
+
+	kept, filtered, err := app.filterSiteNews(site, []repository.NewsItem{
+		{Title: "12.05 Курс рубля"},
+		{Title: "12.05 Реклама банка"},
+		{Title: "Спорт: итоги"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, filtered)
+	assert.Equal(t, []repository.NewsItem{{Title: "Курс рубля"}}, kept)
+}
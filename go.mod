@@ -3,9 +3,16 @@ module github.com/onauryzbaev/go_news_final_
 go 1.13
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/PuerkitoBio/goquery v1.5.0
+	github.com/andybalholm/cascadia v1.0.0
 	github.com/jinzhu/gorm v1.9.10
-	github.com/stretchr/testify v1.4.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/sirupsen/logrus v1.5.0
+	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
 
 replace github.com/onauryzbaev/go_news_fina => ./
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (app *application) healthHandler(res http.ResponseWriter, req *http.Request) {
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte("ok"))
+}
+
+// readyHandler reports whether the application is able to serve pages and keeps parsing sites.
+func (app *application) readyHandler(res http.ResponseWriter, req *http.Request) {
+	var failures []string
+	if err := app.repository.Ping(); err != nil {
+		failures = append(failures, fmt.Sprintf("database: %v", err))
+	}
+
+	app.mu.Lock()
+	migrated := app.migrated
+	lastParse := app.lastParse
+	if lastParse.IsZero() {
+		lastParse = app.started
+	}
+	app.mu.Unlock()
+
+	if !migrated {
+		failures = append(failures, "migrations: not applied")
+	}
+	if app.templates == nil {
+		failures = append(failures, "templates: not loaded")
+	}
+	if maxAge := app.interval * time.Duration(app.readyIntervals); time.Since(lastParse) > maxAge {
+		failures = append(failures, fmt.Sprintf("parsing: last cycle completed at %s", lastParse.Format(time.RFC3339)))
+	}
+
+	if len(failures) > 0 {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		res.Write([]byte(strings.Join(failures, "\n")))
+
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte("ok"))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	app := getApplication()
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.healthHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Run("Ready", func(t *testing.T) {
+		app := getApplication()
+		app.prepareTemplates()
+		app.interval = time.Minute
+		app.migrated = true
+		app.lastParse = time.Now()
+		app.repository.(*mockedRepository).On("Ping").Return(nil)
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.readyHandler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Not ready", func(t *testing.T) {
+		app := getApplication()
+		app.interval = time.Minute
+		app.lastParse = time.Now().Add(-time.Hour)
+		app.repository.(*mockedRepository).On("Ping").Return(errors.New("test repository error"))
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.readyHandler).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Contains(t, rr.Body.String(), "database: test repository error")
+		assert.Contains(t, rr.Body.String(), "migrations: not applied")
+		assert.Contains(t, rr.Body.String(), "templates: not loaded")
+		assert.Contains(t, rr.Body.String(), "parsing: last cycle completed")
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey string
+
+const requestLogKey contextKey = "request_log"
+
+// newLogger creates logger writing entries with given level in json or logfmt format.
+func newLogger(level, format string) (*logrus.Logger, error) {
+	logger := logrus.New()
+
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(lvl)
+
+	switch format {
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "logfmt":
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return logger, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// logRequests assigns request ID to every request and logs it after handling.
+func (app *application) logRequests(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		res.Header().Set("X-Request-ID", requestID)
+
+		entry := app.log.WithField("request_id", requestID)
+		req = req.WithContext(context.WithValue(req.Context(), requestLogKey, entry))
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		handler(rec, req)
+
+		entry.WithFields(logrus.Fields{
+			"method":   req.Method,
+			"path":     req.URL.Path,
+			"status":   rec.status,
+			"duration": time.Since(start),
+		}).Info("Handle request")
+	}
+}
+
+// requestLog returns log entry bound to the request ID.
+func (app *application) requestLog(req *http.Request) *logrus.Entry {
+	if entry, ok := req.Context().Value(requestLogKey).(*logrus.Entry); ok {
+		return entry
+	}
+
+	return logrus.NewEntry(app.log)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	_, err := newLogger("debug", "json")
+	assert.NoError(t, err)
+
+	_, err = newLogger("verbose", "json")
+	assert.Error(t, err)
+
+	_, err = newLogger("info", "xml")
+	assert.Error(t, err)
+}
+
+func TestLogRequests(t *testing.T) {
+	app := getApplication()
+	logger, _ := newLogger("info", "json")
+	out := &bytes.Buffer{}
+	logger.SetOutput(out)
+	app.log = logger
+
+	handler := app.logRequests(func(res http.ResponseWriter, req *http.Request) {
+		app.requestLog(req).Error("Test error")
+		res.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req, _ := http.NewRequest("GET", "/sites", nil)
+	req.Header.Set("X-Request-ID", "test-request")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "test-request", rr.Header().Get("X-Request-ID"))
+	assert.Contains(t, out.String(), `"msg":"Test error","request_id":"test-request"`)
+	assert.Contains(t, out.String(), `"path":"/sites"`)
+	assert.Contains(t, out.String(), `"status":500`)
+
+	req, _ = http.NewRequest("GET", "/sites", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+}
+
+func TestParseIDs(t *testing.T) {
+	assert.Equal(t, []int{1, 3}, parseIDs("1, x,3"))
+	assert.Empty(t, parseIDs(""))
+}
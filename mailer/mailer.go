@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// Config holds SMTP server settings, authentication is used when User is set.
+type Config struct {
+	Addr     string
+	From     string
+	User     string
+	Password string
+}
+
+// Message is an email with plain text and HTML alternatives.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type mailer struct {
+	config Config
+	auth   smtp.Auth
+	now    func() time.Time
+}
+
+func NewMailer(config Config) *mailer {
+	m := &mailer{config: config, now: time.Now}
+	if config.User != "" {
+		host, _, _ := net.SplitHostPort(config.Addr)
+		m.auth = smtp.PlainAuth("", config.User, config.Password, host)
+	}
+
+	return m
+}
+
+// Send delivers the message through the SMTP server.
+func (m *mailer) Send(message Message) error {
+	body, err := m.build(message)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.config.Addr, m.auth, m.config.From, []string{message.To}, body)
+}
+
+// build encodes the message as multipart/alternative MIME email.
+func (m *mailer) build(message Message) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	alternatives := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	}
+	for _, alternative := range alternatives {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {alternative.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writer := quotedprintable.NewWriter(part)
+		if _, err = writer.Write([]byte(alternative.content)); err != nil {
+			return nil, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var email bytes.Buffer
+	headers := []struct {
+		name  string
+		value string
+	}{
+		{"From", m.config.From},
+		{"To", message.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", m.now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary())},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&email, "%s: %s\r\n", header.name, header.value)
+	}
+	email.WriteString("\r\n")
+	email.Write(body.Bytes())
+
+	return email.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bufio"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpStandIn is a minimal SMTP server recording received emails.
+type smtpStandIn struct {
+	listener net.Listener
+	received chan string
+}
+
+func newSmtpStandIn(t *testing.T) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpStandIn{listener: listener, received: make(chan string, 10)}
+	go server.serve()
+
+	return server
+}
+
+func (server *smtpStandIn) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(textproto.NewConn(conn))
+	}
+}
+
+func (server *smtpStandIn) handle(conn *textproto.Conn) {
+	defer conn.Close()
+
+	var envelope []string
+	conn.PrintfLine("220 localhost stand-in")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 localhost")
+		case "MAIL", "RCPT":
+			envelope = append(envelope, line)
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			conn.PrintfLine("354 Go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			server.received <- strings.Join(envelope, "\n") + "\n\n" + string(data)
+			conn.PrintfLine("250 OK")
+		case "QUIT":
+			conn.PrintfLine("221 Bye")
+
+			return
+		default:
+			conn.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	server := newSmtpStandIn(t)
+	defer server.listener.Close()
+
+	m := NewMailer(Config{Addr: server.listener.Addr().String(), From: "news@test.ru"})
+	m.now = func() time.Time { return time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC) }
+	err := m.Send(Message{
+		To:      "user@test.ru",
+		Subject: "Дайджест новостей",
+		Text:    "Заголовок 1\nhttp://test1.ru/news/1",
+		HTML:    `<a href="http://test1.ru/news/1">Заголовок 1</a>`,
+	})
+	assert.NoError(t, err)
+
+	var received string
+	select {
+	case received = <-server.received:
+	case <-time.After(time.Second):
+		t.Fatal("email is not received")
+	}
+	parts := strings.SplitN(received, "\n\n", 2)
+	assert.Equal(t, "MAIL FROM:<news@test.ru>\nRCPT TO:<user@test.ru>", parts[0])
+
+	email, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(parts[1])))
+	assert.NoError(t, err)
+	subject, _ := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+	assert.Equal(t, "Дайджест новостей", subject)
+	assert.Equal(t, "user@test.ru", email.Header.Get("To"))
+	assert.Equal(t, "Fri, 27 Sep 2019 04:00:00 +0000", email.Header.Get("Date"))
+
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	reader := multipart.NewReader(email.Body, params["boundary"])
+	var contents []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(quotedprintable.NewReader(part))
+		contents = append(contents, part.Header.Get("Content-Type")+": "+string(content))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: Заголовок 1\nhttp://test1.ru/news/1",
+		`text/html; charset=utf-8: <a href="http://test1.ru/news/1">Заголовок 1</a>`,
+	}, contents)
+}
+
+func TestSendError(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	m := NewMailer(Config{Addr: addr, From: "news@test.ru"})
+	assert.Error(t, m.Send(Message{To: "user@test.ru", Subject: "test"}))
+}
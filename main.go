@@ -3,13 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/onauryzbaev/go_news_final_/mailer"
 	"github.com/onauryzbaev/go_news_final_/parser"
 	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/telegram"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
@@ -17,21 +20,107 @@ import (
 func main() {
 	interval := flag.Int("i", 600, "Parsing interval in seconds")
 	port := flag.Int("p", 8080, "Port for http server")
+	readyIntervals := flag.Int("ready-intervals", 3, "Number of parsing intervals without a completed parse cycle after which the app is not ready")
+	runsRetention := flag.Int("runs-retention", 30, "Number of days parse runs are kept")
+	newsRetention := flag.Int("news-retention", 0, "Number of days news are kept, news are kept forever if 0")
+	newsPerSite := flag.Int("news-per-site", 0, "Number of the newest news kept for every site, unlimited if 0")
+	keepStarred := flag.Bool("keep-starred", true, "Keep news starred by any user regardless of retention")
+	pruneInterval := flag.Int("prune-interval", 60, "Interval of pruning expired news in minutes")
+	linksRetention := flag.Int("pruned-links-retention", 30, "Number of days links of pruned news are kept so feeds do not insert them again")
+	archiveDir := flag.String("archive-dir", "", "Directory pruned news are archived to as gzipped JSON lines, news are not archived if empty")
+	allowRegister := flag.Bool("allow-register", false, "Allow anyone to register, otherwise only the first user can")
+	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	logFormat := flag.String("log-format", "logfmt", "Log format (json, logfmt)")
+	debugSites := flag.String("debug-sites", "", "Comma separated site IDs to trace selectors on debug level")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server host:port for email digests, digests are disabled if empty")
+	smtpFrom := flag.String("smtp-from", "newsagg@localhost", "Sender address of email digests")
+	smtpUser := flag.String("smtp-user", "", "SMTP user, authentication is disabled if empty")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	telegramToken := flag.String("telegram-token", "", "Telegram bot token, posting to Telegram is disabled if empty")
+	telegramApi := flag.String("telegram-api", telegram.DefaultBaseURL, "Telegram Bot API base URL")
+	telegramChats := flag.String("telegram-chats", "", "Comma separated Telegram chats receiving all new news")
+	publicURL := flag.String("public-url", "", "External url of the app for WebSub callbacks, WebSub is disabled if empty")
 	flag.Parse()
 
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create logger: %v", err))
+	}
+	logger.SetOutput(os.Stdout)
+
 	db, err := gorm.Open("postgres", "host=localhost port=54320 user=postgres dbname=newsagg sslmode=disable")
 	if err != nil {
 		panic(fmt.Sprintf("failed to connect database: %v", err))
 	}
 	defer db.Close()
+	instrumentDB(db)
+
+	// the timeout bounds fetching of feeds and articles, a hanging site would stall the whole parse cycle
+	siteParser := parser.NewParser(&http.Client{Timeout: 30 * time.Second})
+	siteParser.SetLogger(logger, parseIDs(*debugSites))
 
 	app := NewApplication(
 		repository.NewRepository(db),
-		parser.NewParser(&http.Client{}),
-		log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
-		*port,
-		time.Duration(*interval)*time.Second,
+		siteParser,
+		logger,
+		Config{
+			Port:           *port,
+			Interval:       time.Duration(*interval) * time.Second,
+			ReadyIntervals: *readyIntervals,
+			RunsRetention:  time.Duration(*runsRetention) * 24 * time.Hour,
+			NewsRetention:  time.Duration(*newsRetention) * 24 * time.Hour,
+			NewsPerSite:    *newsPerSite,
+			KeepStarred:    *keepStarred,
+			PruneInterval:  time.Duration(*pruneInterval) * time.Minute,
+			ArchiveDir:     *archiveDir,
+			LinksRetention: time.Duration(*linksRetention) * 24 * time.Hour,
+			AllowRegister:  *allowRegister,
+			PublicURL:      *publicURL,
+		},
 	)
+	if flag.Arg(0) == "export" {
+		// the export may be written to the standard output
+		logger.SetOutput(os.Stderr)
+		err = app.exportCommand(flag.Args()[1:], os.Stdout)
+		if err != nil {
+			logger.WithError(err).Error("Failed export news")
+		}
+
+		return
+	}
+	if *smtpAddr != "" {
+		app.SetMailer(mailer.NewMailer(mailer.Config{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			User:     *smtpUser,
+			Password: *smtpPassword,
+		}))
+	}
+	if *telegramToken != "" {
+		var chats []string
+		for _, chat := range strings.Split(*telegramChats, ",") {
+			if chat = strings.TrimSpace(chat); chat != "" {
+				chats = append(chats, chat)
+			}
+		}
+		app.SetTelegram(telegram.NewBot(telegram.Config{
+			Token:          *telegramToken,
+			BaseURL:        *telegramApi,
+			ChatInterval:   3 * time.Second,
+			GlobalInterval: 35 * time.Millisecond,
+		}), chats)
+	}
 	app.Serve()
 	defer app.Stop()
 }
+
+func parseIDs(list string) (ids []int) {
+	for _, value := range strings.Split(list, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	siteFetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "newsagg",
+			Name:      "site_fetch_duration_seconds",
+			Help:      "Duration of fetching and parsing a site.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"site_id"},
+	)
+	siteFetchStatus = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "newsagg",
+			Name:      "site_fetch_status_total",
+			Help:      "Number of site fetches by HTTP status code.",
+		},
+		[]string{"site_id", "code"},
+	)
+	siteParseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "newsagg",
+			Name:      "site_parse_errors_total",
+			Help:      "Number of failed site parses.",
+		},
+		[]string{"site_id"},
+	)
+	siteItemsFound = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "newsagg",
+			Name:      "site_items_found_total",
+			Help:      "Number of news items found on a site.",
+		},
+		[]string{"site_id"},
+	)
+	siteItemsInserted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "newsagg",
+			Name:      "site_items_inserted_total",
+			Help:      "Number of news items inserted to repository.",
+		},
+		[]string{"site_id"},
+	)
+	newsPruned = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "newsagg",
+			Name:      "news_pruned_total",
+			Help:      "Number of news items deleted by the retention policy.",
+		},
+	)
+	parseCycleDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "newsagg",
+			Name:      "parse_cycle_duration_seconds",
+			Help:      "Duration of a full parse cycle over all sites.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+		},
+	)
+	repositoryQueryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "newsagg",
+			Name:      "repository_query_duration_seconds",
+			Help:      "Duration of repository queries.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"operation", "table"},
+	)
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "newsagg",
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of http requests.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler", "method", "code"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		siteFetchDuration,
+		siteFetchStatus,
+		siteParseErrors,
+		siteItemsFound,
+		siteItemsInserted,
+		newsPruned,
+		parseCycleDuration,
+		repositoryQueryDuration,
+		httpRequestDuration,
+	)
+}
+
+// fetchStatus returns status code label for the result of parser.Parse.
+func fetchStatus(statusCode int) string {
+	if statusCode == 0 {
+		return "error"
+	}
+
+	return strconv.Itoa(statusCode)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.status = status
+	rec.wroteHeader = true
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// instrument wraps handler to observe its latency and response status.
+func (app *application) instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		handler(rec, req)
+		httpRequestDuration.
+			WithLabelValues(name, req.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// instrumentDB registers gorm callbacks observing duration of every query.
+func instrumentDB(db *gorm.DB) {
+	const startKey = "metrics:start"
+	before := func(scope *gorm.Scope) {
+		scope.Set(startKey, time.Now())
+	}
+	after := func(operation string) func(scope *gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			start, ok := scope.Get(startKey)
+			if !ok {
+				return
+			}
+			repositoryQueryDuration.
+				WithLabelValues(operation, scope.TableName()).
+				Observe(time.Since(start.(time.Time)).Seconds())
+		}
+	}
+
+	callback := db.Callback()
+	callback.Create().Before("gorm:begin_transaction").Register("metrics:before_create", before)
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("metrics:after_create", after("create"))
+	callback.Query().Before("gorm:query").Register("metrics:before_query", before)
+	callback.Query().After("gorm:after_query").Register("metrics:after_query", after("query"))
+	callback.Update().Before("gorm:begin_transaction").Register("metrics:before_update", before)
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("metrics:after_update", after("update"))
+	callback.Delete().Before("gorm:begin_transaction").Register("metrics:before_delete", before)
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:after_delete", after("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", before)
+	callback.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", after("row_query"))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchStatus(t *testing.T) {
+	assert.Equal(t, "200", fetchStatus(http.StatusOK))
+	assert.Equal(t, "503", fetchStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, "error", fetchStatus(0))
+}
+
+func TestInstrument(t *testing.T) {
+	app := getApplication()
+	handler := app.instrument("test", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+		res.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const filterDateLayout = "2006-01-02"
+
+// newsFilterFromQuery reads news filter from query parameters q, site, from, to, image, story and tag.
+func newsFilterFromQuery(query url.Values) repository.NewsFilter {
+	filter := repository.NewsFilter{
+		Search:   query.Get("q"),
+		HasImage: query.Get("image") == "1",
+	}
+	for _, value := range query["site"] {
+		if id, err := strconv.Atoi(value); err == nil {
+			filter.SiteIDs = append(filter.SiteIDs, id)
+		}
+	}
+	if from, err := time.Parse(filterDateLayout, query.Get("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(filterDateLayout, query.Get("to")); err == nil {
+		// the "to" date is inclusive
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	filter.Story, _ = strconv.Atoi(query.Get("story"))
+	filter.Tag = repository.NormalizeTag(query.Get("tag"))
+
+	return filter
+}
+
+// newsFilterQuery encodes filter back to query parameters.
+func newsFilterQuery(filter repository.NewsFilter) url.Values {
+	query := url.Values{}
+	if filter.Search != "" {
+		query.Set("q", filter.Search)
+	}
+	for _, id := range filter.SiteIDs {
+		query.Add("site", strconv.Itoa(id))
+	}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(filterDateLayout))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.AddDate(0, 0, -1).Format(filterDateLayout))
+	}
+	if filter.HasImage {
+		query.Set("image", "1")
+	}
+	if filter.UnreadBy > 0 {
+		query.Set("unread", "1")
+	}
+	if filter.Story > 0 {
+		query.Set("story", strconv.Itoa(filter.Story))
+	}
+	if filter.Tag != "" {
+		query.Set("tag", filter.Tag)
+	}
+
+	return query
+}
+
+// newsCursorFromQuery reads page cursor from query parameters before and after.
+func newsCursorFromQuery(query url.Values) repository.NewsCursor {
+	cursor := repository.NewsCursor{}
+	cursor.Before, _ = strconv.Atoi(query.Get("before"))
+	cursor.After, _ = strconv.Atoi(query.Get("after"))
+
+	return cursor
+}
+
+// newsPageQuery encodes filter query with the page cursor.
+func newsPageQuery(filter url.Values, cursor repository.NewsCursor) string {
+	query := url.Values{}
+	for key, values := range filter {
+		query[key] = values
+	}
+	if cursor.Before > 0 {
+		query.Set("before", strconv.Itoa(cursor.Before))
+	}
+	if cursor.After > 0 {
+		query.Set("after", strconv.Itoa(cursor.After))
+	}
+
+	return query.Encode()
+}
+
+// restrictToSites limits filter to the given sites unless siteIDs is empty.
+func restrictToSites(filter repository.NewsFilter, siteIDs []int) repository.NewsFilter {
+	if len(siteIDs) == 0 {
+		return filter
+	}
+
+	allowed := make(map[int]bool, len(siteIDs))
+	for _, id := range siteIDs {
+		allowed[id] = true
+	}
+	var restricted []int
+	for _, id := range filter.SiteIDs {
+		if allowed[id] {
+			restricted = append(restricted, id)
+		}
+	}
+	if len(restricted) == 0 {
+		restricted = siteIDs
+	}
+	filter.SiteIDs = restricted
+
+	return filter
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsFilterFromQuery(t *testing.T) {
+	query, _ := url.ParseQuery("q=test&site=1&site=x&site=3&from=2019-09-01&to=invalid&image=1")
+	filter := newsFilterFromQuery(query)
+	assert.Equal(t, repository.NewsFilter{
+		Search:   "test",
+		SiteIDs:  []int{1, 3},
+		From:     time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC),
+		HasImage: true,
+	}, filter)
+
+	query, _ = url.ParseQuery("q=test&site=1&site=3&from=2019-09-01&to=2019-09-27&image=1")
+	assert.Equal(t, query, newsFilterQuery(newsFilterFromQuery(query)))
+
+	query, _ = url.ParseQuery("q=test&unread=1")
+	user := &repository.User{ID: 2}
+	assert.Equal(t, repository.NewsFilter{Search: "test", UnreadBy: 2}, userNewsFilter(newsFilterFromQuery(query), query, user))
+	assert.Equal(t, query, newsFilterQuery(userNewsFilter(newsFilterFromQuery(query), query, user)))
+
+	query, _ = url.ParseQuery("story=12&image=1")
+	assert.Equal(t, repository.NewsFilter{Story: 12, HasImage: true}, newsFilterFromQuery(query))
+	assert.Equal(t, query, newsFilterQuery(newsFilterFromQuery(query)))
+
+	query, _ = url.ParseQuery("tag=%20Нефть%20%20и%20газ")
+	assert.Equal(t, repository.NewsFilter{Tag: "нефть и газ"}, newsFilterFromQuery(query))
+	assert.Equal(t, "нефть и газ", newsFilterQuery(newsFilterFromQuery(query)).Get("tag"))
+}
+
+func TestNewsPageQuery(t *testing.T) {
+	query, _ := url.ParseQuery("before=10&after=x")
+	assert.Equal(t, repository.NewsCursor{Before: 10}, newsCursorFromQuery(query))
+
+	filter := url.Values{"q": {"test"}}
+	assert.Equal(t, "before=10&q=test", newsPageQuery(filter, repository.NewsCursor{Before: 10}))
+	assert.Equal(t, "after=3&q=test", newsPageQuery(filter, repository.NewsCursor{After: 3}))
+	assert.Equal(t, url.Values{"q": {"test"}}, filter)
+}
+
+func TestRestrictToSites(t *testing.T) {
+	filter := repository.NewsFilter{Search: "test"}
+	assert.Equal(t, filter, restrictToSites(filter, nil))
+	assert.Equal(t, []int{1, 2}, restrictToSites(filter, []int{1, 2}).SiteIDs)
+
+	filter.SiteIDs = []int{2, 3}
+	assert.Equal(t, []int{2}, restrictToSites(filter, []int{1, 2}).SiteIDs)
+
+	filter.SiteIDs = []int{3}
+	assert.Equal(t, []int{1, 2}, restrictToSites(filter, []int{1, 2}).SiteIDs)
+}
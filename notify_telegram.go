@@ -0,0 +1,162 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/telegram"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	telegramQueueSize = 1000
+	// limits of message text and photo caption length in the Bot API
+	telegramTextLimit    = 4096
+	telegramCaptionLimit = 1024
+)
+
+// telegramPost is a news item queued for posting to a chat, with the name of the matched alert rule if any.
+type telegramPost struct {
+	chatID string
+	item   repository.NewsItem
+	rule   string
+}
+
+// SetTelegram enables posting news to Telegram chats with the bot,
+// all new news are posted to chats, alert rules may post their matches to other chats.
+func (app *application) SetTelegram(bot Telegram, chats []string) {
+	app.telegram = bot
+	app.telegramChats = chats
+	app.telegramQueue = make(chan telegramPost, telegramQueueSize)
+}
+
+// notifying posts queued news one by one, the bot waits for its rate limits.
+func (app *application) notifying() {
+	if app.telegram == nil {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-app.stop:
+				return
+			case post := <-app.telegramQueue:
+				app.postTelegram(post)
+			}
+		}
+	}()
+}
+
+// broadcast queues news inserted in a parse cycle for the configured chats.
+func (app *application) broadcast(news []repository.NewsItem) {
+	for _, chatID := range app.telegramChats {
+		for _, item := range news {
+			app.queueTelegram(telegramPost{chatID: chatID, item: item})
+		}
+	}
+}
+
+// queueTelegram queues the post dropping it when the queue is full.
+func (app *application) queueTelegram(post telegramPost) {
+	if app.telegramQueue == nil {
+		return
+	}
+
+	select {
+	case app.telegramQueue <- post:
+	default:
+		app.log.WithFields(logrus.Fields{"chat_id": post.chatID, "news_item_id": post.item.ID}).Warn("Drop telegram post, queue is full")
+	}
+}
+
+func (app *application) postTelegram(post telegramPost) {
+	postLog := app.log.WithFields(logrus.Fields{"chat_id": post.chatID, "news_item_id": post.item.ID})
+
+	message := telegramMessage(post.item, post.rule)
+	err := app.telegram.Send(post.chatID, message)
+	if apiErr, ok := err.(*telegram.Error); ok && apiErr.Code == 400 && message.Photo != "" {
+		// Telegram could not fetch the image, post the news without it
+		postLog.WithError(err).Debug("Failed post telegram photo")
+		message = telegramMessage(repository.NewsItem{
+			Title:       post.item.Title,
+			Description: post.item.Description,
+			Link:        post.item.Link,
+		}, post.rule)
+		err = app.telegram.Send(post.chatID, message)
+	}
+	if err != nil {
+		postLog.WithError(err).Error("Failed post news to telegram")
+
+		return
+	}
+	postLog.Debug("Posted news to telegram")
+}
+
+// telegramMessage formats the news item in Telegram HTML with the image as photo preview.
+func telegramMessage(item repository.NewsItem, rule string) telegram.Message {
+	message := telegram.Message{Photo: item.Image}
+	limit := telegramTextLimit
+	if message.Photo != "" {
+		limit = telegramCaptionLimit
+	}
+
+	footer := "\n<a href=\"" + html.EscapeString(item.Link) + "\">" + html.EscapeString(linkHost(item.Link)) + "</a>"
+	// the title is kept before the rule and the description, they get the rest of the limit
+	rest := limit - utf8.RuneCountInString(footer)
+	title := "<b>" + fitRunes(item.Title, rest-utf8.RuneCountInString("<b></b>\n")) + "</b>\n"
+	rest -= utf8.RuneCountInString(title)
+	var header string
+	if rule = fitRunes(rule, rest-utf8.RuneCountInString("🔔 \n")); rule != "" {
+		header = "🔔 " + rule + "\n"
+		rest -= utf8.RuneCountInString(header)
+	}
+
+	description := fitRunes(item.Description, rest-utf8.RuneCountInString("\n"))
+	if description != "" {
+		description += "\n"
+	}
+	message.Text = header + title + description + footer
+
+	return message
+}
+
+// fitRunes escapes text truncating it with an ellipsis if it does not fit limit runes after escaping.
+func fitRunes(text string, limit int) string {
+	if escaped := html.EscapeString(text); utf8.RuneCountInString(escaped) <= limit {
+		return escaped
+	}
+
+	return truncateRunes(text, limit)
+}
+
+// truncateRunes escapes text truncated with an ellipsis to fit limit runes after escaping.
+func truncateRunes(text string, limit int) string {
+	var escaped strings.Builder
+	length := 0
+	for _, r := range text {
+		char := html.EscapeString(string(r))
+		// keep room for the ellipsis
+		if length+utf8.RuneCountInString(char) > limit-1 {
+			break
+		}
+		escaped.WriteString(char)
+		length += utf8.RuneCountInString(char)
+	}
+	if length == 0 {
+		return ""
+	}
+
+	return escaped.String() + "…"
+}
+
+func linkHost(link string) string {
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return link
+}
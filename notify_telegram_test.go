@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/telegram"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelegramMessage(t *testing.T) {
+	message := telegramMessage(repository.NewsItem{
+		Title:       "Цены на <нефть>",
+		Description: "Нефть & газ",
+		Link:        "http://test1.ru/news/1?a=1&b=2",
+	}, "")
+	assert.Equal(t, telegram.Message{
+		Text: "<b>Цены на &lt;нефть&gt;</b>\nНефть &amp; газ\n\n<a href=\"http://test1.ru/news/1?a=1&amp;b=2\">test1.ru</a>",
+	}, message)
+
+	message = telegramMessage(repository.NewsItem{
+		Title:       "Заголовок",
+		Description: strings.Repeat("<б>", 1000),
+		Link:        "http://test1.ru/news/2",
+		Image:       "http://test1.ru/news/2.jpeg",
+	}, "Нефть")
+	assert.Equal(t, "http://test1.ru/news/2.jpeg", message.Photo)
+	assert.True(t, strings.HasPrefix(message.Text, "🔔 Нефть\n<b>Заголовок</b>\n&lt;б&gt;"))
+	assert.True(t, strings.HasSuffix(message.Text, "…\n\n<a href=\"http://test1.ru/news/2\">test1.ru</a>"))
+	assert.True(t, utf8.RuneCountInString(message.Text) <= telegramCaptionLimit)
+	assert.NotContains(t, message.Text, "&l…")
+
+	message = telegramMessage(repository.NewsItem{
+		Title:       strings.Repeat("<Заголовок>", 1000),
+		Description: "Нефть",
+		Link:        "http://test1.ru/news/3",
+		Image:       "http://test1.ru/news/3.jpeg",
+	}, "Нефть")
+	assert.True(t, strings.HasPrefix(message.Text, "<b>&lt;Заголовок&gt;"))
+	assert.True(t, strings.HasSuffix(message.Text, "…</b>\n\n<a href=\"http://test1.ru/news/3\">test1.ru</a>"))
+	assert.True(t, utf8.RuneCountInString(message.Text) <= telegramCaptionLimit)
+	assert.NotContains(t, message.Text, "Нефть")
+
+	message = telegramMessage(repository.NewsItem{
+		Title: strings.Repeat("Заголовок ", 1000),
+		Link:  "http://test1.ru/news/4",
+	}, "")
+	assert.True(t, strings.HasSuffix(message.Text, "…</b>\n\n<a href=\"http://test1.ru/news/4\">test1.ru</a>"))
+	assert.True(t, utf8.RuneCountInString(message.Text) <= telegramTextLimit)
+}
+
+func TestBroadcast(t *testing.T) {
+	app := getApplication()
+	app.broadcast([]repository.NewsItem{{ID: 1}})
+
+	app.SetTelegram(new(mockedTelegram), []string{"@news", "-100"})
+	app.broadcast([]repository.NewsItem{{ID: 1}, {ID: 2}})
+	assert.Len(t, app.telegramQueue, 4)
+	assert.Equal(t, telegramPost{chatID: "@news", item: repository.NewsItem{ID: 1}}, <-app.telegramQueue)
+
+	for i := 0; i < telegramQueueSize; i++ {
+		app.queueTelegram(telegramPost{chatID: "@news"})
+	}
+	assert.Len(t, app.telegramQueue, telegramQueueSize)
+}
+
+func TestAlertTelegram(t *testing.T) {
+	app := getApplication()
+	app.SetTelegram(new(mockedTelegram), nil)
+	app.repository.(*mockedRepository).
+		On("GetAlertRules").
+		Return([]repository.AlertRule{{ID: 1, Name: "Нефть", Kind: repository.AlertKeyword, Query: "нефть", TelegramChat: "@oil"}}, nil)
+
+	app.alert([]repository.NewsItem{{ID: 1, Title: "Нефть дорожает"}, {ID: 2, Title: "Погода"}})
+	assert.Len(t, app.telegramQueue, 1)
+	assert.Equal(t, telegramPost{chatID: "@oil", item: repository.NewsItem{ID: 1, Title: "Нефть дорожает"}, rule: "Нефть"}, <-app.telegramQueue)
+	app.repository.(*mockedRepository).AssertNotCalled(t, "AddAlertDelivery")
+}
+
+func TestPostTelegram(t *testing.T) {
+	app := getApplication()
+	bot := new(mockedTelegram)
+	app.SetTelegram(bot, nil)
+	item := repository.NewsItem{ID: 1, Title: "Заголовок", Link: "http://test1.ru/news/1", Image: "http://test1.ru/1.jpeg"}
+	withoutImage := item
+	withoutImage.Image = ""
+
+	bot.On("Send", "@news", telegramMessage(item, "")).
+		Return(&telegram.Error{Code: 400, Description: "Bad Request: wrong file identifier"}).Once()
+	bot.On("Send", "@news", telegramMessage(withoutImage, "")).
+		Return(nil).Once()
+	app.postTelegram(telegramPost{chatID: "@news", item: item})
+	bot.AssertNumberOfCalls(t, "Send", 2)
+
+	bot.On("Send", "@gone", telegramMessage(item, "")).
+		Return(errors.New("test telegram error")).Once()
+	app.postTelegram(telegramPost{chatID: "@gone", item: item})
+	bot.AssertNumberOfCalls(t, "Send", 3)
+}
@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// maxArticleSize limits the size of a fetched article page.
+const maxArticleSize = 5 << 20
+
+// ErrNoContent is returned by ParseArticle when the page has no article text.
+var ErrNoContent = errors.New("article content not found")
+
+// Article is the main content of a news item page.
+type Article struct {
+	// Content is the sanitized html of the article body.
+	Content string
+	// Text is the plain text of the article body, paragraphs are separated by empty lines.
+	Text string
+	// CanonicalLink is the link rel=canonical of the page.
+	CanonicalLink string
+}
+
+var (
+	// removedTags are dropped with their content.
+	removedTags = "script, style, noscript, iframe, object, embed, form, button, input, select, textarea, svg, canvas, nav, aside, footer, header"
+	// allowedTags are kept in the sanitized content, other tags are replaced with their content.
+	allowedTags = map[string]bool{
+		"div": true, "section": true, "p": true, "br": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "code": true,
+		"strong": true, "b": true, "em": true, "i": true, "a": true, "img": true,
+		"figure": true, "figcaption": true, "table": true, "tr": true, "td": true, "th": true,
+	}
+	// allowedAttrs are the attributes kept on the allowed tags.
+	allowedAttrs = map[string]string{"a": "href", "img": "src"}
+	// blockTags separate paragraphs of the plain text.
+	blockTags = map[string]bool{
+		"p": true, "br": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "div": true,
+		"figure": true, "figcaption": true, "table": true, "tr": true, "section": true, "article": true,
+	}
+
+	unlikelyPattern = regexp.MustCompile(`(?i)comment|sidebar|footer|menu|share|social|related|promo|advert|banner|subscribe|cookie|popup|widget`)
+	positivePattern = regexp.MustCompile(`(?i)article|content|body|main|text|post|entry|story`)
+	spacePattern    = regexp.MustCompile(`[\s\x{a0}]+`)
+	newlinesPattern = regexp.MustCompile(`[ ]*(\n[ ]*)+`)
+)
+
+// ParseArticle fetches the page of the news item and extracts the article body,
+// with the ContentPath selector of the site or a readability heuristic.
+func (parser *parser) ParseArticle(site repository.Site, link string) (article Article, err error) {
+	response, err := parser.client.Get(link)
+	if err != nil {
+		return
+	}
+	if response.Body != nil {
+		defer response.Body.Close()
+	}
+	if response.StatusCode != http.StatusOK {
+		err = &StatusError{StatusCode: response.StatusCode}
+
+		return
+	}
+
+	base, err := url.Parse(link)
+	if err != nil {
+		return
+	}
+	if response.Request != nil {
+		base = response.Request.URL
+	}
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(response.Body, maxArticleSize))
+	if err != nil {
+		return
+	}
+
+	if href, ok := doc.Find("link[rel~=canonical]").Attr("href"); ok && href != "" {
+		article.CanonicalLink = prepareLink(*base, href)
+	}
+
+	var content *goquery.Selection
+	if site.ContentPath != "" {
+		content = doc.Find(site.ContentPath).First()
+	} else {
+		content = readabilityContent(doc)
+	}
+	if content == nil || content.Length() == 0 {
+		err = ErrNoContent
+
+		return
+	}
+
+	content.Find(removedTags).Remove()
+	article.Text = plainText(content)
+	if article.Text == "" {
+		err = ErrNoContent
+
+		return
+	}
+	sanitize(content, *base)
+	article.Content, err = content.Html()
+	article.Content = strings.TrimSpace(article.Content)
+	parser.trace(site).WithFields(logrus.Fields{"link": link, "length": len(article.Text)}).Debug("Parsed article")
+
+	return
+}
+
+// readabilityContent selects the element holding most of the text paragraphs,
+// nil if the page has no paragraphs.
+func readabilityContent(doc *goquery.Document) *goquery.Selection {
+	body := doc.Find("body")
+	body.Find(removedTags).Remove()
+	body.Find("*").Each(func(i int, s *goquery.Selection) {
+		hints := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyPattern.MatchString(hints) && !positivePattern.MatchString(hints) && goquery.NodeName(s) != "body" {
+			s.Remove()
+		}
+	})
+
+	type candidate struct {
+		selection *goquery.Selection
+		score     float64
+	}
+	var candidates []*candidate
+	scores := map[interface{}]*candidate{}
+	add := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		key := s.Get(0)
+		c, ok := scores[key]
+		if !ok {
+			c = &candidate{selection: s, score: classWeight(s)}
+			scores[key] = c
+			candidates = append(candidates, c)
+		}
+		c.score += score
+	}
+	body.Find("p, pre, td, blockquote").Each(func(i int, s *goquery.Selection) {
+		text := strings.TrimSpace(s.Text())
+		length := len([]rune(text))
+		if length < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(minInt(length/100, 3))
+		add(s.Parent(), score)
+		add(s.Parent().Parent(), score/2)
+	})
+
+	var best *candidate
+	for _, c := range candidates {
+		c.score *= 1 - linkDensity(c.selection)
+		if best == nil || c.score > best.score {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	return best.selection
+}
+
+func classWeight(s *goquery.Selection) float64 {
+	hints := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+	weight := 0.0
+	if positivePattern.MatchString(hints) {
+		weight += 25
+	}
+	if unlikelyPattern.MatchString(hints) {
+		weight -= 25
+	}
+
+	return weight
+}
+
+// linkDensity is the share of the element text inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	length := len([]rune(s.Text()))
+	if length == 0 {
+		return 0
+	}
+	links := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		links += len([]rune(a.Text()))
+	})
+
+	return float64(links) / float64(length)
+}
+
+// sanitize keeps only the allowed tags and attributes of the content, resolving links against base.
+func sanitize(content *goquery.Selection, base url.URL) {
+	content.Find(removedTags).Remove()
+	removeComments(content)
+	content.Find("*").Each(func(i int, s *goquery.Selection) {
+		removeComments(s)
+		name := goquery.NodeName(s)
+		if !allowedTags[name] {
+			s.Contents().Unwrap()
+			s.Remove()
+
+			return
+		}
+
+		allowed := allowedAttrs[name]
+		value, hasValue := s.Attr(allowed)
+		// RemoveAttr modifies the attribute list
+		attrs := s.Get(0).Attr
+		keys := make([]string, 0, len(attrs))
+		for _, attr := range attrs {
+			keys = append(keys, attr.Key)
+		}
+		for _, key := range keys {
+			s.RemoveAttr(key)
+		}
+		if !hasValue {
+			return
+		}
+		link := prepareLink(base, strings.TrimSpace(value))
+		if strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://") {
+			s.SetAttr(allowed, link)
+		}
+	})
+}
+
+func removeComments(s *goquery.Selection) {
+	s.Contents().FilterFunction(func(i int, child *goquery.Selection) bool {
+		return goquery.NodeName(child) == "#comment"
+	}).Remove()
+}
+
+// plainText returns the text of the content with paragraphs separated by empty lines.
+func plainText(content *goquery.Selection) string {
+	var text strings.Builder
+	var walk func(s *goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Contents().Each(func(i int, child *goquery.Selection) {
+			name := goquery.NodeName(child)
+			if name == "#text" {
+				text.WriteString(spacePattern.ReplaceAllString(child.Text(), " "))
+
+				return
+			}
+			if blockTags[name] {
+				text.WriteString("\n\n")
+			}
+			walk(child)
+			if blockTags[name] {
+				text.WriteString("\n\n")
+			}
+		})
+	}
+	walk(content)
+
+	return strings.TrimSpace(newlinesPattern.ReplaceAllString(text.String(), "\n\n"))
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html>
+<head>
+	<link rel="canonical" href="/news/1" />
+	<script>var tracking = true;</script>
+</head>
+<body>
+	<header><a href="/">Главная</a></header>
+	<nav class="menu"><a href="/politics">Политика</a> <a href="/economy">Экономика</a></nav>
+	<div class="layout">
+		<div class="article-body" id="content">
+			<h1>Заголовок новости</h1>
+			<p class="lead" onclick="steal()">Банк России повысил ключевую ставку до 16%, сообщила пресс-служба регулятора в пятницу.</p>
+			<!-- banner -->
+			<div class="share-buttons"><a href="https://vk.com/share">Поделиться</a></div>
+			<p>Решение принято на внеочередном заседании, <a href="/rates" style="color: red">подробнее о ставке</a>, аналитики ожидали такого шага.</p>
+			<img src="/img/1.jpg" width="100" />
+			<script>alert(1)</script>
+			<span>Инфляция, по оценкам, замедлится к концу года, а ставка останется высокой.</span>
+		</div>
+		<div class="sidebar">
+			<p>Самое читаемое: очень длинный текст бокового блока, который не относится к статье.</p>
+		</div>
+	</div>
+	<footer><p>Все права защищены, перепечатка запрещена без ссылки на источник.</p></footer>
+</body>
+</html>`
+
+func TestParseArticle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		if req.URL.Path == "/large" {
+			_, _ = rw.Write([]byte(`<html><body><div class="article-body"><p>Начало статьи</p>`))
+			_, _ = rw.Write([]byte(strings.Repeat(" ", maxArticleSize)))
+			_, _ = rw.Write([]byte(`<p>Конец статьи</p></div></body></html>`))
+
+			return
+		}
+		if req.URL.Path == "/empty" {
+			_, _ = rw.Write([]byte(`<html><body><nav>Меню</nav></body></html>`))
+
+			return
+		}
+		_, _ = rw.Write([]byte(articlePage))
+	}))
+	defer server.Close()
+
+	parser := NewParser(server.Client())
+	article, err := parser.ParseArticle(repository.Site{}, server.URL+"/news/1?utm_source=rss")
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/news/1", article.CanonicalLink)
+	assert.Equal(t, "Заголовок новости\n\n"+
+		"Банк России повысил ключевую ставку до 16%, сообщила пресс-служба регулятора в пятницу.\n\n"+
+		"Решение принято на внеочередном заседании, подробнее о ставке, аналитики ожидали такого шага.\n\n"+
+		"Инфляция, по оценкам, замедлится к концу года, а ставка останется высокой.", article.Text)
+	assert.Contains(t, article.Content, `<p>Банк России`)
+	assert.Contains(t, article.Content, `<a href="`+server.URL+`/rates">подробнее о ставке</a>`)
+	assert.Contains(t, article.Content, `<img src="`+server.URL+`/img/1.jpg"/>`)
+	assert.NotContains(t, article.Content, "script")
+	assert.NotContains(t, article.Content, "onclick")
+	assert.NotContains(t, article.Content, "Поделиться")
+	assert.NotContains(t, article.Content, "banner")
+	assert.NotContains(t, article.Content, "<span>")
+	assert.NotContains(t, article.Text, "Самое читаемое")
+
+	article, err = parser.ParseArticle(repository.Site{ContentPath: ".sidebar"}, server.URL+"/news/1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Самое читаемое: очень длинный текст бокового блока, который не относится к статье.", article.Text)
+
+	_, err = parser.ParseArticle(repository.Site{ContentPath: ".missing"}, server.URL+"/news/1")
+	assert.Equal(t, ErrNoContent, err)
+	_, err = parser.ParseArticle(repository.Site{}, server.URL+"/empty")
+	assert.Equal(t, ErrNoContent, err)
+	// the page is read up to maxArticleSize
+	article, err = parser.ParseArticle(repository.Site{ContentPath: ".article-body"}, server.URL+"/large")
+	assert.NoError(t, err)
+	assert.Equal(t, "Начало статьи", article.Text)
+	_, err = parser.ParseArticle(repository.Site{}, server.URL+"/missing")
+	assert.Equal(t, &StatusError{StatusCode: http.StatusNotFound}, err)
+}
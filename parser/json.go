@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// jsonSource reads news from JSON responses of APIs, the fields of news items are selected by dot separated paths.
+type jsonSource struct{}
+
+func (jsonSource) Type() string {
+	return TypeJson
+}
+
+func (jsonSource) Title() string {
+	return "JSON API"
+}
+
+func (jsonSource) Schema() []ConfigField {
+	return []ConfigField{
+		{Name: "items_path", Label: "Путь к списку новостей, пустой если ответ сам список (например data.items)", Kind: FieldText},
+		{Name: "title_field", Label: "Поле заголовка новости (например title)", Kind: FieldText, Required: true},
+		{Name: "link_field", Label: "Поле ссылки на новость (например url)", Kind: FieldText, Required: true},
+		{Name: "description_field", Label: "Поле описания новости (например summary)", Kind: FieldText},
+		{Name: "date_field", Label: "Поле даты публикации, строка или unix время (например published_at)", Kind: FieldText},
+		{Name: "image_field", Label: "Поле изображения новости (например image.url)", Kind: FieldText},
+		{Name: "category_field", Label: "Поле категорий новости, строка или список (например tags)", Kind: FieldText},
+	}
+}
+
+func (jsonSource) Parse(page Page) (news []repository.NewsItem, err error) {
+	var data interface{}
+	decoder := json.NewDecoder(page.Response.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&data)
+	if err != nil {
+		return
+	}
+
+	site := page.Site
+	items, _ := jsonPath(data, site.Option("items_path")).([]interface{})
+	page.Trace.WithFields(logrus.Fields{"path": site.Option("items_path"), "items": len(items)}).Debug("Select json news items")
+	for _, value := range items {
+		item := repository.NewsItem{
+			Title:       strings.TrimSpace(jsonString(jsonPath(value, site.Option("title_field")))),
+			Description: strings.TrimSpace(jsonString(jsonPath(value, site.Option("description_field")))),
+		}
+		link := strings.TrimSpace(jsonString(jsonPath(value, site.Option("link_field"))))
+		if item.Title == "" || link == "" {
+			continue
+		}
+		item.Link = prepareLink(*page.Response.Request.URL, link)
+		if image := jsonString(jsonPath(value, site.Option("image_field"))); image != "" {
+			item.Image = prepareLink(*page.Response.Request.URL, image)
+		}
+		item.Date, item.PublishedAt = jsonDate(jsonPath(value, site.Option("date_field")))
+		if field := site.Option("category_field"); field != "" {
+			item.Tags = jsonStrings(jsonPath(value, field))
+		}
+		news = append(news, item)
+	}
+
+	return
+}
+
+// jsonPath returns the value at the dot separated path of object keys and array indexes, nil if there is none.
+func jsonPath(value interface{}, path string) interface{} {
+	if path == "" {
+		return value
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			value = container[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil
+			}
+			value = container[index]
+		default:
+			return nil
+		}
+	}
+
+	return value
+}
+
+// jsonString returns the string, number or boolean value as a string, empty for other values.
+func jsonString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+
+	return ""
+}
+
+// jsonStrings returns the strings of a list, or the value if it is a single string.
+func jsonStrings(value interface{}) (values []string) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	for _, element := range list {
+		if text := strings.TrimSpace(jsonString(element)); text != "" {
+			values = append(values, text)
+		}
+	}
+
+	return
+}
+
+// jsonDate reads a date string or a unix time in seconds or milliseconds.
+func jsonDate(value interface{}) (string, time.Time) {
+	if number, ok := value.(json.Number); ok {
+		unix, err := number.Int64()
+		if err != nil || unix <= 0 {
+			return "", time.Time{}
+		}
+		// unix times in milliseconds exceed 1e12 since 2001
+		if unix > 1e12 {
+			unix /= 1000
+		}
+		date := time.Unix(unix, 0).UTC()
+
+		return date.Format(time.RFC3339), date
+	}
+
+	date := strings.TrimSpace(jsonString(value))
+
+	return date, parseDate(date)
+}
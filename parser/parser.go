@@ -1,15 +1,19 @@
 package parser
 
 import (
+	"bytes"
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/onauryzbaev/go_news_final_/canonical"
 	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type HttpClient interface {
@@ -23,78 +27,214 @@ type rss struct {
 }
 
 type channel struct {
-	XMLName xml.Name `xml:"channel"`
-	Items   []item   `xml:"item"`
+	XMLName xml.Name   `xml:"channel"`
+	Links   []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Items   []item     `xml:"item"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
 }
 
 type item struct {
-	XMLName     xml.Name `xml:"item"`
-	Title       string   `xml:"title"`
-	Description string   `xml:"description"`
-	Link        string   `xml:"link"`
-	Date        string   `xml:"pubDate"`
-	Image       string   `xml:"image"`
+	XMLName     xml.Name   `xml:"item"`
+	Title       string     `xml:"title"`
+	Description string     `xml:"description"`
+	Link        string     `xml:"link"`
+	GUID        guid       `xml:"guid"`
+	Date        string     `xml:"pubDate"`
+	Image       string     `xml:"image"`
+	Categories  []category `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Links   []atomLink  `xml:"http://www.w3.org/2005/Atom link"`
+	Entries []atomEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type atomEntry struct {
+	Title      string     `xml:"title"`
+	Summary    string     `xml:"summary"`
+	Content    string     `xml:"content"`
+	Links      []atomLink `xml:"link"`
+	ID         string     `xml:"id"`
+	Published  string     `xml:"published"`
+	Updated    string     `xml:"updated"`
+	Categories []category `xml:"category"`
+}
+
+// category is an rss <category>name</category> or an atom <category term="name" /> element.
+type category struct {
+	Value string `xml:",chardata"`
+	Term  string `xml:"term,attr"`
+}
+
+func categoryTags(categories []category) (tags []string) {
+	for _, category := range categories {
+		tag := strings.TrimSpace(category.Term)
+		if tag == "" {
+			tag = strings.TrimSpace(category.Value)
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return
+}
+
+type guid struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink string `xml:"isPermaLink,attr"`
+}
+
+// permaLink returns the guid if it is the permanent url of the item, guids are permanent urls by default.
+func (guid guid) permaLink() string {
+	value := strings.TrimSpace(guid.Value)
+	if guid.IsPermaLink == "false" || !(strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")) {
+		return ""
+	}
+
+	return value
+}
+
+// StatusError is returned by Parse when the site responds with a non 200 status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("request failed with status code %d", err.StatusCode)
+}
+
+// FetchInfo describes the http response a site was parsed from.
+type FetchInfo struct {
+	StatusCode int
+	Bytes      int64
+	// Hub and Topic are the WebSub hub and the self url advertised by the feed.
+	Hub   string
+	Topic string
+}
+
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.bytes += int64(n)
+
+	return n, err
 }
 
 type parser struct {
-	client HttpClient
+	client     HttpClient
+	log        *logrus.Logger
+	debugLog   *logrus.Logger
+	debugSites map[int]bool
 }
 
 func NewParser(client HttpClient) *parser {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
 	return &parser{
-		client: client,
+		client:   client,
+		log:      logger,
+		debugLog: logger,
+	}
+}
+
+// SetLogger sets logger for selector tracing. Tracing of debugSites is
+// written on debug level regardless of the logger level.
+func (parser *parser) SetLogger(logger *logrus.Logger, debugSites []int) {
+	parser.log = logger
+	parser.debugLog = &logrus.Logger{
+		Out:          logger.Out,
+		Hooks:        logger.Hooks,
+		Formatter:    logger.Formatter,
+		ReportCaller: logger.ReportCaller,
+		Level:        logrus.DebugLevel,
+		ExitFunc:     logger.ExitFunc,
+	}
+	parser.debugSites = make(map[int]bool, len(debugSites))
+	for _, id := range debugSites {
+		parser.debugSites[id] = true
+	}
+}
+
+func (parser *parser) trace(site repository.Site) *logrus.Entry {
+	logger := parser.log
+	if parser.debugSites[site.ID] {
+		logger = parser.debugLog
 	}
+
+	return logger.WithFields(logrus.Fields{"site_id": site.ID, "url": site.Url})
 }
 
-func (parser *parser) Parse(site repository.Site) (news []repository.NewsItem, err error) {
+// Parse fetches the site and parses its news with the source parser of the site type.
+func (parser *parser) Parse(site repository.Site) (news []repository.NewsItem, info FetchInfo, err error) {
+	source, ok := Source(site.Type)
+	if !ok {
+		err = &UnknownSourceError{Type: site.Type}
+
+		return
+	}
+
 	response, err := parser.client.Get(site.Url)
 	if err != nil {
 		return
 	}
+	if response.Body != nil {
+		defer response.Body.Close()
+	}
+	info.StatusCode = response.StatusCode
 
 	if response.StatusCode != http.StatusOK {
-		err = errors.New(fmt.Sprintf("request failed with status code %d", response.StatusCode))
+		err = &StatusError{StatusCode: response.StatusCode}
 
 		return
 	}
 
-	if site.IsRss {
-		news, err = parser.parseRss(response)
-	} else {
-		news, err = parser.parseHtml(
-			response,
-			site.NewsItemPath,
-			site.TitlePath,
-			site.DescriptionPath,
-			site.LinkPath,
-			site.DatePath,
-			site.ImagePath,
-		)
-	}
+	body := &countingReader{ReadCloser: response.Body}
+	response.Body = body
+	defer func() {
+		info.Bytes = body.bytes
+	}()
+
+	news, err = source.Parse(Page{Site: site, Response: response, Trace: parser.trace(site), Info: &info})
 
 	return
 }
 
-func (parser *parser) parseHtml(
+func parseHtml(
 	response *http.Response,
+	trace *logrus.Entry,
 	itemPath,
 	titlePath,
 	descriptionPath,
 	linkPath,
 	datePath,
-	imagePath string,
+	imagePath,
+	categoryPath string,
 ) (news []repository.NewsItem, err error) {
 	doc, err := goquery.NewDocumentFromReader(response.Body)
 	if err != nil {
 		return
 	}
 
-	doc.Find(itemPath).Each(func(i int, s *goquery.Selection) {
+	items := doc.Find(itemPath)
+	trace.WithFields(logrus.Fields{"selector": itemPath, "matches": items.Length()}).Debug("Select news items")
+	items.Each(func(i int, s *goquery.Selection) {
 		item := repository.NewsItem{
 			Title:       s.Find(titlePath).Text(),
 			Description: s.Find(descriptionPath).Text(),
 			Date:        s.Find(datePath).Text(),
 		}
+		item.PublishedAt = parseDate(item.Date)
 		linkSelection := s.Find(linkPath)
 		if link, ok := linkSelection.Attr("href"); ok {
 			item.Link = prepareLink(*response.Request.URL, link)
@@ -103,37 +243,188 @@ func (parser *parser) parseHtml(
 		if image, ok := imageSelection.Attr("src"); ok {
 			item.Image = prepareLink(*response.Request.URL, image)
 		}
+		if categoryPath != "" {
+			s.Find(categoryPath).Each(func(i int, category *goquery.Selection) {
+				if tag := strings.TrimSpace(category.Text()); tag != "" {
+					item.Tags = append(item.Tags, tag)
+				}
+			})
+		}
+		trace.WithFields(logrus.Fields{
+			"index":             i,
+			"title_matches":     s.Find(titlePath).Length(),
+			"link_matches":      linkSelection.Length(),
+			"image_matches":     imageSelection.Length(),
+			"description_empty": item.Description == "",
+			"date_empty":        item.Date == "",
+			"tags":              len(item.Tags),
+			"title":             item.Title,
+			"link":              item.Link,
+		}).Debug("Select news item fields")
 		news = append(news, item)
 	})
 
 	return
 }
 
-func (parser *parser) parseRss(response *http.Response) (news []repository.NewsItem, err error) {
+// ParseFeed parses news of the rss or atom feed content pushed by a WebSub hub.
+func (parser *parser) ParseFeed(site repository.Site, body []byte) ([]repository.NewsItem, error) {
+	news, _, err := parseRssBody(body)
+	parser.trace(site).WithField("items", len(news)).Debug("Parsed pushed rss channel")
+
+	return news, err
+}
+
+func parseRss(response *http.Response) (news []repository.NewsItem, links []atomLink, err error) {
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return
 	}
 
+	return parseRssBody(body)
+}
+
+func parseRssBody(body []byte) (news []repository.NewsItem, links []atomLink, err error) {
+	if rootElement(body) == "feed" {
+		return parseAtomBody(body)
+	}
+
 	rss := &rss{}
 	err = xml.Unmarshal(body, rss)
 	if err != nil {
 		return
 	}
+	links = rss.Channel.Links
 
 	for _, rssItem := range rss.Channel.Items {
-		news = append(news, repository.NewsItem{
+		item := repository.NewsItem{
 			Title:       rssItem.Title,
 			Description: rssItem.Description,
 			Link:        rssItem.Link,
 			Date:        rssItem.Date,
 			Image:       rssItem.Image,
-		})
+			PublishedAt: parseDate(rssItem.Date),
+		}
+		if link := rssItem.GUID.permaLink(); link != "" {
+			item.CanonicalLink = canonical.Link(link)
+		}
+		item.Tags = categoryTags(rssItem.Categories)
+		news = append(news, item)
 	}
 
 	return
 }
 
+// rootElement returns the local name of the root element of the xml document.
+func rootElement(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+func parseAtomBody(body []byte) (news []repository.NewsItem, links []atomLink, err error) {
+	feed := &atomFeed{}
+	err = xml.Unmarshal(body, feed)
+	if err != nil {
+		return
+	}
+	links = feed.Links
+
+	for _, entry := range feed.Entries {
+		item := repository.NewsItem{
+			Title:       strings.TrimSpace(entry.Title),
+			Description: strings.TrimSpace(entry.Summary),
+			Date:        entry.Published,
+			Tags:        categoryTags(entry.Categories),
+		}
+		if item.Description == "" {
+			item.Description = strings.TrimSpace(entry.Content)
+		}
+		if item.Date == "" {
+			item.Date = entry.Updated
+		}
+		item.PublishedAt = parseDate(item.Date)
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				item.Link = link.Href
+
+				break
+			}
+		}
+		if id := strings.TrimSpace(entry.ID); item.Link == "" && (strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://")) {
+			item.Link = id
+		}
+		news = append(news, item)
+	}
+
+	return
+}
+
+// headerLinks reads links of the http Link header: <url>; rel="hub", <url>; rel="self".
+func headerLinks(header http.Header) (links []atomLink) {
+	for _, value := range header["Link"] {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			href := strings.Trim(strings.TrimSpace(fields[0]), "<>")
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "rel=") {
+					for _, rel := range strings.Fields(strings.Trim(strings.TrimPrefix(param, "rel="), `"`)) {
+						links = append(links, atomLink{Rel: rel, Href: href})
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// hubLinks returns the first hub and self links.
+func hubLinks(links []atomLink) (hub string, self string) {
+	for _, link := range links {
+		if link.Rel == "hub" && hub == "" {
+			hub = link.Href
+		}
+		if link.Rel == "self" && self == "" {
+			self = link.Href
+		}
+	}
+
+	return
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02.01.2006 15:04",
+	"2006-01-02",
+}
+
+// parseDate parses publication date in one of the common formats, zero time if none matches.
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date
+		}
+	}
+
+	return time.Time{}
+}
+
 func prepareLink(siteUrl url.URL, link string) string {
 	linkUrl, err := url.Parse(link)
 	if err == nil {
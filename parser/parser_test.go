@@ -1,14 +1,17 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
 	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestNewParser(t *testing.T) {
@@ -25,7 +28,7 @@ func TestParse(t *testing.T) {
 			Return(&http.Response{}, errors.New("test http error"))
 
 		parser := NewParser(mockedClient)
-		_, err := parser.Parse(repository.Site{Url: "http://error.ru"})
+		_, _, err := parser.Parse(repository.Site{Url: "http://error.ru", Type: TypeRss})
 		assert.Error(t, err)
 		assert.Equal(t, "test http error", err.Error())
 	})
@@ -37,9 +40,10 @@ func TestParse(t *testing.T) {
 			Return(&http.Response{StatusCode: http.StatusInternalServerError}, nil)
 
 		parser := NewParser(mockedClient)
-		_, err := parser.Parse(repository.Site{Url: "http://error-500.ru"})
+		_, _, err := parser.Parse(repository.Site{Url: "http://error-500.ru", Type: TypeRss})
 		assert.Error(t, err)
 		assert.Equal(t, "request failed with status code 500", err.Error())
+		assert.Equal(t, &StatusError{StatusCode: http.StatusInternalServerError}, err)
 	})
 
 	t.Run("Parse rss invalid response", func(t *testing.T) {
@@ -50,7 +54,7 @@ func TestParse(t *testing.T) {
 			Return(response.Result(), nil)
 
 		parser := NewParser(mockedClient)
-		_, err := parser.Parse(repository.Site{Url: "http://invalid-xml-rss.ru", IsRss: true})
+		_, _, err := parser.Parse(repository.Site{Url: "http://invalid-xml-rss.ru", Type: TypeRss})
 		assert.Error(t, err)
 		assert.Equal(t, "EOF", err.Error())
 	})
@@ -63,9 +67,10 @@ func TestParse(t *testing.T) {
 			Return(response.Result(), nil)
 
 		parser := NewParser(mockedClient)
-		news, err := parser.Parse(repository.Site{Url: "http://invalid.ru", IsRss: false})
+		news, info, err := parser.Parse(repository.Site{Url: "http://invalid.ru", Type: TypeHtml})
 		assert.NoError(t, err)
 		assert.Empty(t, news)
+		assert.Equal(t, FetchInfo{StatusCode: http.StatusOK}, info)
 	})
 
 	t.Run("Parse rss success", func(t *testing.T) {
@@ -92,14 +97,17 @@ func TestParse(t *testing.T) {
 		defer server.Close()
 
 		parser := NewParser(server.Client())
-		news, err := parser.Parse(repository.Site{Url: server.URL, IsRss: true})
+		news, info, err := parser.Parse(repository.Site{Url: server.URL, Type: TypeRss})
 		assert.NoError(t, err)
 		assert.Len(t, news, 2)
+		assert.Equal(t, http.StatusOK, info.StatusCode)
+		assert.NotZero(t, info.Bytes)
 		assert.Equal(t, news[0], repository.NewsItem{
 			Title:       "Заголовок 1",
 			Description: "Описание 1",
 			Date:        "Wed, 25 Sep 2019 18:10:34 +0300",
 			Link:        "https://news.ru/1",
+			PublishedAt: parseDate("Wed, 25 Sep 2019 18:10:34 +0300"),
 		})
 		assert.Equal(t, news[1], repository.NewsItem{
 			Title:       "Заголовок 2",
@@ -109,6 +117,140 @@ func TestParse(t *testing.T) {
 		})
 	})
 
+	t.Run("Parse rss with WebSub hub", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/header" {
+				rw.Header().Add("Link", `<https://hub.news.ru/>; rel="hub", <https://news.ru/header.rss>; rel="self"`)
+			}
+			_, _ = rw.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+				<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+					<channel>
+						<link>https://news.ru</link>
+						<atom:link rel="self" href="https://news.ru/rss" />
+						<atom:link rel="hub" href="https://pubsubhubbub.appspot.com/" />
+						<item>
+							<title>Заголовок 1</title>
+							<link>https://news.ru/1</link>
+						</item>
+					</channel>
+				</rss>
+			`))
+		}))
+		defer server.Close()
+
+		parser := NewParser(server.Client())
+		news, info, err := parser.Parse(repository.Site{Url: server.URL, Type: TypeRss})
+		assert.NoError(t, err)
+		assert.Len(t, news, 1)
+		assert.Equal(t, "https://pubsubhubbub.appspot.com/", info.Hub)
+		assert.Equal(t, "https://news.ru/rss", info.Topic)
+
+		_, info, err = parser.Parse(repository.Site{Url: server.URL + "/header", Type: TypeRss})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://pubsubhubbub.appspot.com/", info.Hub)
+		assert.Equal(t, "https://news.ru/rss", info.Topic)
+
+		news, err = parser.ParseFeed(repository.Site{Type: TypeRss}, []byte(`<rss><channel><item><link>https://news.ru/2</link></item></channel></rss>`))
+		assert.NoError(t, err)
+		assert.Equal(t, []repository.NewsItem{{Link: "https://news.ru/2"}}, news)
+
+		_, err = parser.ParseFeed(repository.Site{Type: TypeRss}, []byte(`<rss>`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Parse canonical links", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(`<rss><channel>
+				<item><link>https://news.ru/1?utm_source=rss</link><guid>http://www.news.ru/1/</guid></item>
+				<item><link>https://news.ru/2</link><guid isPermaLink="false">http://news.ru/?p=2</guid></item>
+				<item><link>https://news.ru/3</link><guid>3</guid></item>
+			</channel></rss>`))
+		}))
+		defer server.Close()
+
+		parser := NewParser(server.Client())
+		news, _, err := parser.Parse(repository.Site{Url: server.URL + "/rss", Type: TypeRss})
+		assert.NoError(t, err)
+		assert.Len(t, news, 3)
+		assert.Equal(t, "https://news.ru/1", news[0].CanonicalLink)
+		assert.Equal(t, "https://news.ru/1?utm_source=rss", news[0].Link)
+		assert.Empty(t, news[1].CanonicalLink)
+		assert.Empty(t, news[2].CanonicalLink)
+	})
+
+	t.Run("Parse categories", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/rss":
+				_, _ = rw.Write([]byte(`<rss xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+					<item><link>https://news.ru/1</link><category>Экономика</category><category> Нефть </category></item>
+					<item><link>https://news.ru/2</link><atom:category term="Спорт" /></item>
+				</channel></rss>`))
+			case "/atom":
+				_, _ = rw.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+					<feed xmlns="http://www.w3.org/2005/Atom">
+						<link rel="hub" href="https://hub.news.ru/" />
+						<entry>
+							<title> Заголовок 1 </title>
+							<link rel="alternate" href="https://news.ru/1" />
+							<id>tag:news.ru,2020:1</id>
+							<updated>2020-05-12T10:00:00Z</updated>
+							<summary>Описание 1</summary>
+							<category term="Экономика" label="Экономика и финансы" />
+						</entry>
+						<entry>
+							<id>https://news.ru/2</id>
+							<published>2020-05-12T11:00:00Z</published>
+							<content type="html">Текст 2</content>
+						</entry>
+					</feed>`))
+			default:
+				_, _ = rw.Write([]byte(`<html><body>
+					<article><a href="/1">Заголовок 1</a><span class="tag">Экономика</span><span class="tag"> </span><span class="tag">Нефть</span></article>
+					<article><a href="/2">Заголовок 2</a></article>
+				</body></html>`))
+			}
+		}))
+		defer server.Close()
+
+		parser := NewParser(server.Client())
+		news, _, err := parser.Parse(repository.Site{Url: server.URL + "/rss", Type: TypeRss})
+		assert.NoError(t, err)
+		assert.Len(t, news, 2)
+		assert.Equal(t, []string{"Экономика", "Нефть"}, news[0].Tags)
+		assert.Equal(t, []string{"Спорт"}, news[1].Tags)
+
+		news, info, err := parser.Parse(repository.Site{Url: server.URL + "/atom", Type: TypeRss})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://hub.news.ru/", info.Hub)
+		assert.Equal(t, []repository.NewsItem{
+			{
+				Title:       "Заголовок 1",
+				Description: "Описание 1",
+				Link:        "https://news.ru/1",
+				Date:        "2020-05-12T10:00:00Z",
+				PublishedAt: time.Date(2020, 5, 12, 10, 0, 0, 0, time.UTC),
+				Tags:        []string{"Экономика"},
+			},
+			{
+				Description: "Текст 2",
+				Link:        "https://news.ru/2",
+				Date:        "2020-05-12T11:00:00Z",
+				PublishedAt: time.Date(2020, 5, 12, 11, 0, 0, 0, time.UTC),
+			},
+		}, news)
+
+		news, err = parser.ParseFeed(repository.Site{Type: TypeRss}, []byte(`<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="https://news.ru/3" /></entry></feed>`))
+		assert.NoError(t, err)
+		assert.Equal(t, []repository.NewsItem{{Link: "https://news.ru/3"}}, news)
+
+		news, _, err = parser.Parse(repository.Site{Url: server.URL, Type: TypeHtml, NewsItemPath: "article", TitlePath: "a", LinkPath: "a", CategoryPath: ".tag"})
+		assert.NoError(t, err)
+		assert.Len(t, news, 2)
+		assert.Equal(t, []string{"Экономика", "Нефть"}, news[0].Tags)
+		assert.Empty(t, news[1].Tags)
+	})
+
 	t.Run("Parse html success", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 			_, _ = rw.Write([]byte(`<!DOCTYPE html>
@@ -131,9 +273,9 @@ func TestParse(t *testing.T) {
 		defer server.Close()
 
 		parser := NewParser(server.Client())
-		news, err := parser.Parse(repository.Site{
+		news, info, err := parser.Parse(repository.Site{
 			Url:             server.URL,
-			IsRss:           false,
+			Type:            TypeHtml,
 			NewsItemPath:    "article.art",
 			TitlePath:       "h3",
 			DescriptionPath: ".art-desc",
@@ -143,6 +285,8 @@ func TestParse(t *testing.T) {
 		})
 		assert.NoError(t, err)
 		assert.Len(t, news, 2)
+		assert.Equal(t, http.StatusOK, info.StatusCode)
+		assert.NotZero(t, info.Bytes)
 		assert.Equal(t, news[0], repository.NewsItem{
 			Title:       "Заголовок 1",
 			Description: "Описание 1",
@@ -159,6 +303,37 @@ func TestParse(t *testing.T) {
 	})
 }
 
+func TestSetLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`<html><body><article><a href="/1"><h3>Заголовок 1</h3></a></article></body></html>`))
+	}))
+	defer server.Close()
+
+	out := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetLevel(logrus.InfoLevel)
+
+	parser := NewParser(server.Client())
+	parser.SetLogger(logger, []int{2})
+
+	_, _, err := parser.Parse(repository.Site{ID: 1, Url: server.URL, Type: TypeHtml, NewsItemPath: "article", TitlePath: "h3", LinkPath: "a"})
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+
+	_, _, err = parser.Parse(repository.Site{ID: 2, Url: server.URL, Type: TypeHtml, NewsItemPath: "article", TitlePath: "h3", LinkPath: "a"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Select news items")
+	assert.Contains(t, out.String(), "matches=1")
+	assert.Contains(t, out.String(), "site_id=2")
+}
+
+func TestParseDate(t *testing.T) {
+	assert.Equal(t, "2019-09-25T15:10:34Z", parseDate("Wed, 25 Sep 2019 18:10:34 +0300").UTC().Format(time.RFC3339))
+	assert.Equal(t, "2019-09-27T04:00:00Z", parseDate(" 2019-09-27 04:00 ").UTC().Format(time.RFC3339))
+	assert.True(t, parseDate("вчера").IsZero())
+}
+
 func TestPrepareLink(t *testing.T) {
 	u := &url.URL{}
 
@@ -183,6 +358,23 @@ func TestPrepareLink(t *testing.T) {
 	})
 }
 
+func TestHeaderLinks(t *testing.T) {
+	header := http.Header{}
+	header.Add("Link", `<https://hub.news.ru/>; rel="hub", <https://news.ru/rss>; rel="self alternate"`)
+	header.Add("Link", `<https://news.ru/next>; rel=next`)
+
+	assert.Equal(t, []atomLink{
+		{Rel: "hub", Href: "https://hub.news.ru/"},
+		{Rel: "self", Href: "https://news.ru/rss"},
+		{Rel: "alternate", Href: "https://news.ru/rss"},
+		{Rel: "next", Href: "https://news.ru/next"},
+	}, headerLinks(header))
+
+	hub, self := hubLinks(headerLinks(header))
+	assert.Equal(t, "https://hub.news.ru/", hub)
+	assert.Equal(t, "https://news.ru/rss", self)
+}
+
 type mockedHttpClient struct {
 	mock.Mock
 }
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// maxTitleLength is the size of the news title column.
+const maxTitleLength = 250
+
+type sitemapUrlset struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string         `xml:"loc"`
+	Lastmod string         `xml:"lastmod"`
+	News    sitemapNews    `xml:"http://www.google.com/schemas/sitemap-news/0.9 news"`
+	Images  []sitemapImage `xml:"http://www.google.com/schemas/sitemap-image/1.1 image"`
+}
+
+// sitemapNews is the news extension of Google news sitemaps.
+type sitemapNews struct {
+	Title           string `xml:"title"`
+	PublicationDate string `xml:"publication_date"`
+	Keywords        string `xml:"keywords"`
+}
+
+type sitemapImage struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapSource reads news from sitemaps, news sitemaps provide titles, dates and keywords,
+// pages of plain sitemaps are titled by their links.
+type sitemapSource struct{}
+
+func (sitemapSource) Type() string {
+	return TypeSitemap
+}
+
+func (sitemapSource) Title() string {
+	return "Карта сайта (sitemap.xml)"
+}
+
+func (sitemapSource) Schema() []ConfigField {
+	return []ConfigField{
+		{Name: "link_pattern", Label: "Регулярное выражение ссылок на новости, остальные страницы пропускаются (например /news/\\d+)", Kind: FieldRegex},
+	}
+}
+
+func (sitemapSource) Parse(page Page) (news []repository.NewsItem, err error) {
+	body, err := ioutil.ReadAll(page.Response.Body)
+	if err != nil {
+		return
+	}
+	if rootElement(body) == "sitemapindex" {
+		return nil, errors.New("sitemap index is not supported, add one of its sitemaps")
+	}
+	urlset := &sitemapUrlset{}
+	err = xml.Unmarshal(body, urlset)
+	if err != nil {
+		return
+	}
+
+	var pattern *regexp.Regexp
+	if value := page.Site.Option("link_pattern"); value != "" {
+		pattern, err = regexp.Compile(value)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, url := range urlset.Urls {
+		link := strings.TrimSpace(url.Loc)
+		if link == "" || (pattern != nil && !pattern.MatchString(link)) {
+			continue
+		}
+		item := repository.NewsItem{
+			Title: strings.TrimSpace(url.News.Title),
+			Link:  link,
+			Date:  strings.TrimSpace(url.News.PublicationDate),
+		}
+		if item.Title == "" {
+			item.Title = truncateTitle(link)
+		}
+		if item.Date == "" {
+			item.Date = strings.TrimSpace(url.Lastmod)
+		}
+		item.PublishedAt = parseDate(item.Date)
+		if len(url.Images) > 0 {
+			item.Image = strings.TrimSpace(url.Images[0].Loc)
+		}
+		if url.News.Keywords != "" {
+			item.Tags = repository.SplitTags(url.News.Keywords)
+		}
+		news = append(news, item)
+	}
+	page.Trace.WithFields(logrus.Fields{"urls": len(urlset.Urls), "items": len(news)}).Debug("Parsed sitemap")
+
+	return
+}
+
+// truncateTitle cuts the fallback title to the size of the title column.
+func truncateTitle(title string) string {
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		return string(runes[:maxTitleLength])
+	}
+
+	return title
+}
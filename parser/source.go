@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// FieldKind tells how the value of a setting is checked.
+type FieldKind string
+
+const (
+	FieldText     FieldKind = "text"
+	FieldSelector FieldKind = "selector"
+	FieldRegex    FieldKind = "regex"
+)
+
+// ConfigField is a setting of sites of a source type, read with repository.Site.Option.
+type ConfigField struct {
+	// Name is the option name, it is also the name of the site form field.
+	Name     string
+	Label    string
+	Kind     FieldKind
+	Required bool
+}
+
+// Page is the fetched page of the site a source parser reads news from.
+type Page struct {
+	Site     repository.Site
+	Response *http.Response
+	Trace    *logrus.Entry
+	// Info receives the WebSub hub of the page if the source supports WebSub.
+	Info *FetchInfo
+}
+
+// SourceParser parses news of the sites of one source type.
+type SourceParser interface {
+	// Type is the name of the source type stored in repository.Site.Type.
+	Type() string
+	// Title names the source type in the site form.
+	Title() string
+	// Schema lists the settings of the source type.
+	Schema() []ConfigField
+	Parse(page Page) ([]repository.NewsItem, error)
+}
+
+var (
+	sources     = map[string]SourceParser{}
+	sourceOrder []string
+)
+
+// Register adds the source parser to the registry, it panics if its type is already registered.
+func Register(source SourceParser) {
+	if _, ok := sources[source.Type()]; ok {
+		panic(fmt.Sprintf("parser: source type %s registered twice", source.Type()))
+	}
+	sources[source.Type()] = source
+	sourceOrder = append(sourceOrder, source.Type())
+}
+
+// Source returns the source parser of the type.
+func Source(sourceType string) (SourceParser, bool) {
+	source, ok := sources[sourceType]
+
+	return source, ok
+}
+
+// Sources returns all source parsers in the order they were registered.
+func Sources() []SourceParser {
+	list := make([]SourceParser, 0, len(sourceOrder))
+	for _, sourceType := range sourceOrder {
+		list = append(list, sources[sourceType])
+	}
+
+	return list
+}
+
+// UnknownSourceError is returned by Parse for sites with a type without a source parser.
+type UnknownSourceError struct {
+	Type string
+}
+
+func (err *UnknownSourceError) Error() string {
+	return fmt.Sprintf("unknown source type %q", err.Type)
+}
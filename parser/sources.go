@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// source types of the parser package
+const (
+	TypeRss     = "rss"
+	TypeHtml    = "html"
+	TypeJson    = "json"
+	TypeSitemap = "sitemap"
+)
+
+func init() {
+	Register(rssSource{})
+	Register(htmlSource{})
+	Register(jsonSource{})
+	Register(sitemapSource{})
+}
+
+// rssSource parses rss and atom feeds.
+type rssSource struct{}
+
+func (rssSource) Type() string {
+	return TypeRss
+}
+
+func (rssSource) Title() string {
+	return "Rss или Atom канал"
+}
+
+func (rssSource) Schema() []ConfigField {
+	return nil
+}
+
+func (rssSource) Parse(page Page) ([]repository.NewsItem, error) {
+	news, links, err := parseRss(page.Response)
+	page.Info.Hub, page.Info.Topic = hubLinks(append(links, headerLinks(page.Response.Header)...))
+	page.Trace.WithFields(logrus.Fields{"items": len(news), "hub": page.Info.Hub}).Debug("Parsed rss channel")
+
+	return news, err
+}
+
+// htmlSource selects news of html pages with css selectors.
+type htmlSource struct{}
+
+func (htmlSource) Type() string {
+	return TypeHtml
+}
+
+func (htmlSource) Title() string {
+	return "Html страница"
+}
+
+func (htmlSource) Schema() []ConfigField {
+	return []ConfigField{
+		{Name: "news_item_path", Label: "Селектор блока с новостью (например .news article)", Kind: FieldSelector, Required: true},
+		{Name: "title_path", Label: "Селектор заголовка новости (например h3)", Kind: FieldSelector, Required: true},
+		{Name: "link_path", Label: "Селектор ссылки на новость (например h3 a)", Kind: FieldSelector, Required: true},
+		{Name: "description_path", Label: "Селектор описания новости (например .description)", Kind: FieldSelector},
+		{Name: "date_path", Label: "Селектор даты публикации новости (например i.date)", Kind: FieldSelector},
+		{Name: "image_path", Label: "Селектор изображения новости (например img)", Kind: FieldSelector},
+		{Name: "category_path", Label: "Селектор категорий новости (например .tags a)", Kind: FieldSelector},
+	}
+}
+
+func (htmlSource) Parse(page Page) ([]repository.NewsItem, error) {
+	site := page.Site
+
+	return parseHtml(
+		page.Response,
+		page.Trace,
+		site.NewsItemPath,
+		site.TitlePath,
+		site.DescriptionPath,
+		site.LinkPath,
+		site.DatePath,
+		site.ImagePath,
+		site.CategoryPath,
+	)
+}
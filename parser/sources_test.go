@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSources(t *testing.T) {
+	var types []string
+	for _, source := range Sources() {
+		types = append(types, source.Type())
+		assert.NotEmpty(t, source.Title())
+	}
+	assert.Equal(t, []string{TypeRss, TypeHtml, TypeJson, TypeSitemap}, types)
+
+	source, ok := Source(TypeHtml)
+	assert.True(t, ok)
+	assert.Equal(t, "news_item_path", source.Schema()[0].Name)
+	assert.True(t, source.Schema()[0].Required)
+
+	_, ok = Source("ftp")
+	assert.False(t, ok)
+	assert.Panics(t, func() { Register(rssSource{}) })
+
+	parser := NewParser(&mockedHttpClient{})
+	_, _, err := parser.Parse(repository.Site{Url: "http://test.ru", Type: "ftp"})
+	assert.Equal(t, &UnknownSourceError{Type: "ftp"}, err)
+	assert.EqualError(t, err, `unknown source type "ftp"`)
+}
+
+func TestParseJson(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"data": {"items": [
+			{"title": " Заголовок 1 ", "url": "/news/1", "summary": "Описание 1", "published": 1569556800, "image": {"url": "https://news.ru/1.jpg"}, "tags": ["Экономика", 2019, {"name": "skip"}]},
+			{"title": "Заголовок 2", "url": "https://news.ru/2", "published": "2019-09-27T05:00:00Z", "tags": "Политика"},
+			{"title": "Заголовок 3", "url": "https://news.ru/3", "published": 1569560400000},
+			{"title": "Без ссылки"},
+			{"title": " ", "url": "https://news.ru/4"}
+		]}}`))
+	}))
+	defer server.Close()
+
+	site := repository.Site{Url: server.URL + "/api/news", Type: TypeJson}
+	site.SetOption("items_path", "data.items")
+	site.SetOption("title_field", "title")
+	site.SetOption("link_field", "url")
+	site.SetOption("description_field", "summary")
+	site.SetOption("date_field", "published")
+	site.SetOption("image_field", "image.url")
+	site.SetOption("category_field", "tags")
+
+	parser := NewParser(server.Client())
+	news, info, err := parser.Parse(site)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, info.StatusCode)
+	assert.Equal(t, []repository.NewsItem{
+		{
+			Title:       "Заголовок 1",
+			Description: "Описание 1",
+			Link:        server.URL + "/news/1",
+			Image:       "https://news.ru/1.jpg",
+			Date:        "2019-09-27T04:00:00Z",
+			PublishedAt: time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC),
+			Tags:        []string{"Экономика", "2019"},
+		},
+		{
+			Title:       "Заголовок 2",
+			Link:        "https://news.ru/2",
+			Date:        "2019-09-27T05:00:00Z",
+			PublishedAt: time.Date(2019, 9, 27, 5, 0, 0, 0, time.UTC),
+			Tags:        []string{"Политика"},
+		},
+		{
+			Title:       "Заголовок 3",
+			Link:        "https://news.ru/3",
+			Date:        "2019-09-27T05:00:00Z",
+			PublishedAt: time.Date(2019, 9, 27, 5, 0, 0, 0, time.UTC),
+		},
+	}, news)
+
+	site.SetOption("items_path", "")
+	news, _, err = parser.Parse(site)
+	assert.NoError(t, err)
+	assert.Empty(t, news)
+}
+
+func TestTruncateTitle(t *testing.T) {
+	assert.Equal(t, "https://news.ru/news/2", truncateTitle("https://news.ru/news/2"))
+	link := "https://news.ru/" + strings.Repeat("я", 300)
+	assert.Equal(t, maxTitleLength, utf8.RuneCountInString(truncateTitle(link)))
+	assert.True(t, strings.HasPrefix(link, truncateTitle(link)))
+}
+
+func TestJsonPath(t *testing.T) {
+	value := map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": "c"}}}
+	assert.Equal(t, "c", jsonPath(value, "a.0.b"))
+	assert.Nil(t, jsonPath(value, "a.1.b"))
+	assert.Nil(t, jsonPath(value, "a.x"))
+	assert.Nil(t, jsonPath(value, "a.0.b.c"))
+	assert.Equal(t, value, jsonPath(value, ""))
+}
+
+func TestParseSitemap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/index.xml" {
+			_, _ = rw.Write([]byte(`<?xml version="1.0"?><sitemapindex><sitemap><loc>https://news.ru/1.xml</loc></sitemap></sitemapindex>`))
+
+			return
+		}
+		_, _ = rw.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+			<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+				xmlns:news="http://www.google.com/schemas/sitemap-news/0.9"
+				xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+				<url>
+					<loc>https://news.ru/news/1</loc>
+					<news:news>
+						<news:publication><news:name>Новости</news:name></news:publication>
+						<news:publication_date>2019-09-27T04:00:00Z</news:publication_date>
+						<news:title>Заголовок 1</news:title>
+						<news:keywords>Экономика, нефть</news:keywords>
+					</news:news>
+					<image:image><image:loc>https://news.ru/1.jpg</image:loc></image:image>
+				</url>
+				<url><loc>https://news.ru/about</loc></url>
+				<url><loc>https://news.ru/news/2</loc><lastmod>2019-09-27</lastmod></url>
+			</urlset>`))
+	}))
+	defer server.Close()
+
+	site := repository.Site{Url: server.URL + "/sitemap.xml", Type: TypeSitemap}
+	site.SetOption("link_pattern", `/news/\d+`)
+
+	parser := NewParser(server.Client())
+	news, _, err := parser.Parse(site)
+	assert.NoError(t, err)
+	assert.Equal(t, []repository.NewsItem{
+		{
+			Title:       "Заголовок 1",
+			Link:        "https://news.ru/news/1",
+			Image:       "https://news.ru/1.jpg",
+			Date:        "2019-09-27T04:00:00Z",
+			PublishedAt: time.Date(2019, 9, 27, 4, 0, 0, 0, time.UTC),
+			Tags:        []string{"экономика", "нефть"},
+		},
+		{
+			Title:       "https://news.ru/news/2",
+			Link:        "https://news.ru/news/2",
+			Date:        "2019-09-27",
+			PublishedAt: time.Date(2019, 9, 27, 0, 0, 0, 0, time.UTC),
+		},
+	}, news)
+
+	site.SetOption("link_pattern", "")
+	news, _, err = parser.Parse(site)
+	assert.NoError(t, err)
+	assert.Len(t, news, 3)
+
+	_, _, err = parser.Parse(repository.Site{Url: server.URL + "/index.xml", Type: TypeSitemap})
+	assert.EqualError(t, err, "sitemap index is not supported, add one of its sitemaps")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// maxSlugLength is the maximal number of characters of a news item slug.
+const maxSlugLength = 80
+
+// translit spells cyrillic letters in latin for slugs.
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "h", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// newsSlug returns the title in lower case latin letters and digits separated by dashes,
+// cut at a word boundary to maxSlugLength.
+func newsSlug(title string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		latin, ok := translit[r]
+		if !ok && r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			latin, ok = string(r), true
+		}
+		if !ok {
+			dash = true
+			continue
+		}
+		// letters without spelling, like the hard sign, do not separate words
+		if latin == "" {
+			continue
+		}
+		if dash && slug.Len() > 0 {
+			slug.WriteByte('-')
+		}
+		dash = false
+		slug.WriteString(latin)
+	}
+
+	value := slug.String()
+	if len(value) > maxSlugLength {
+		value = value[:maxSlugLength+1]
+		if cut := strings.LastIndexByte(value, '-'); cut > 0 {
+			value = value[:cut]
+		} else {
+			value = value[:maxSlugLength]
+		}
+	}
+
+	return value
+}
+
+// newsPath is the permalink of the news item: /news/{id}-{slug}.
+func newsPath(item repository.NewsItem) string {
+	path := "/news/" + strconv.Itoa(item.ID)
+	if slug := newsSlug(item.Title); slug != "" {
+		path += "-" + slug
+	}
+
+	return path
+}
+
+// newsPathID reads the news item id of the permalink, the slug is optional.
+func newsPathID(path string) (int, bool) {
+	value := strings.TrimPrefix(path, "/news/")
+	if dash := strings.IndexByte(value, '-'); dash >= 0 {
+		value = value[:dash]
+	}
+	id, err := strconv.Atoi(value)
+
+	return id, err == nil && id > 0
+}
+
+// newsItemLink is the permalink of the news item returning to the list page back.
+func newsItemLink(item repository.NewsItem, back string) string {
+	if back == "" || back == "/" {
+		return newsPath(item)
+	}
+
+	return newsPath(item) + "?" + url.Values{"back": {back}}.Encode()
+}
+
+// absoluteURL resolves the path against the public url of the application,
+// or the requested host if it is not configured.
+func (app *application) absoluteURL(req *http.Request, path string) string {
+	if app.publicURL != "" {
+		return strings.TrimSuffix(app.publicURL, "/") + path
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + req.Host + path
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsSlug(t *testing.T) {
+	cases := map[string]string{
+		"Курс рубля вырос на 2%":      "kurs-rublya-vyros-na-2",
+		"  Объём ВВП — «рекорд»!  ":   "obem-vvp-rekord",
+		"Apple представила iPhone 12": "apple-predstavila-iphone-12",
+		"Щёлково: съезд партии":       "schelkovo-sezd-partii",
+		"<b>Заголовок</b>":            "b-zagolovok-b",
+		"":                            "",
+		"!!!":                         "",
+		"中文标题":                        "",
+		strings.Repeat("длинное слово ", 20): "dlinnoe-slovo-dlinnoe-slovo-dlinnoe-slovo-dlinnoe-slovo-dlinnoe-slovo-dlinnoe",
+	}
+	for title, slug := range cases {
+		assert.Equal(t, slug, newsSlug(title), title)
+	}
+}
+
+func TestNewsPath(t *testing.T) {
+	assert.Equal(t, "/news/5-kurs-rublya", newsPath(repository.NewsItem{ID: 5, Title: "Курс рубля"}))
+	assert.Equal(t, "/news/5", newsPath(repository.NewsItem{ID: 5}))
+
+	for path, id := range map[string]int{"/news/5-kurs-rublya": 5, "/news/5": 5, "/news/12-": 12} {
+		parsed, ok := newsPathID(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, id, parsed, path)
+	}
+	for _, path := range []string{"/news/", "/news/x-5", "/news/-5", "/news/0"} {
+		_, ok := newsPathID(path)
+		assert.False(t, ok, path)
+	}
+
+	item := repository.NewsItem{ID: 5, Title: "Курс рубля"}
+	assert.Equal(t, "/news/5-kurs-rublya", newsItemLink(item, "/"))
+	assert.Equal(t, "/news/5-kurs-rublya?back=%2F%3Fq%3D1", newsItemLink(item, "/?q=1"))
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	app := getApplication()
+	req, _ := http.NewRequest("GET", "http://localhost:8080/news/5", nil)
+	assert.Equal(t, "http://localhost:8080/news/5", app.absoluteURL(req, "/news/5"))
+
+	app.publicURL = "https://newsagg.ru/"
+	assert.Equal(t, "https://newsagg.ru/news/5", app.absoluteURL(req, "/news/5"))
+}
@@ -0,0 +1,293 @@
+// Package pipeline transforms parsed news items before they are inserted.
+// A pipeline is configured per site with one processor per line:
+//
+//	trim [field...]                   collapse whitespace, all text fields by default
+//	strip_html [field...]             replace html with its text, title and description by default
+//	truncate field length             cut the text to length characters at a word boundary
+//	replace field pattern [text]      replace regexp matches, the text is the rest of the line
+//	rewrite_link pattern replacement  replace regexp matches in the link
+//	set_tags tag, tag...              add tags to every news item
+//	drop field pattern                drop news matching the regexp, the pattern is the rest of the line
+//
+// Empty lines and lines starting with # are ignored.
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// Processor transforms the news item, returning false if the item should be dropped.
+type Processor interface {
+	Process(item *repository.NewsItem) bool
+}
+
+// Pipeline applies processors in order until one of them drops the item.
+type Pipeline []Processor
+
+// Process reports whether the item is kept after all processors.
+func (pipeline Pipeline) Process(item *repository.NewsItem) bool {
+	for _, processor := range pipeline {
+		if !processor.Process(item) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fields of news items processors apply to.
+const (
+	Title       = "title"
+	Description = "description"
+	Link        = "link"
+	Image       = "image"
+	Date        = "date"
+)
+
+// textFields are the fields trim applies to by default.
+var textFields = []string{Title, Description, Link, Image, Date}
+
+func field(item *repository.NewsItem, name string) *string {
+	switch name {
+	case Title:
+		return &item.Title
+	case Description:
+		return &item.Description
+	case Link:
+		return &item.Link
+	case Image:
+		return &item.Image
+	case Date:
+		return &item.Date
+	}
+
+	return nil
+}
+
+func checkFields(names []string) error {
+	for _, name := range names {
+		if field(&repository.NewsItem{}, name) == nil {
+			return fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Parse compiles the pipeline configuration, errors refer to the line number.
+func Parse(config string) (Pipeline, error) {
+	var pipeline Pipeline
+	for i, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		processor, err := parseProcessor(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		pipeline = append(pipeline, processor)
+	}
+
+	return pipeline, nil
+}
+
+func parseProcessor(line string) (Processor, error) {
+	args := strings.Fields(line)
+	name := args[0]
+	args = args[1:]
+	// rest returns the rest of the line after n arguments
+	rest := func(n int) string {
+		value := strings.TrimSpace(strings.TrimPrefix(line, name))
+		for i := 0; i < n; i++ {
+			value = strings.TrimSpace(strings.TrimPrefix(value, args[i]))
+		}
+
+		return value
+	}
+
+	switch name {
+	case "trim":
+		if len(args) == 0 {
+			args = textFields
+		}
+
+		return Trim{Fields: args}, checkFields(args)
+	case "strip_html":
+		if len(args) == 0 {
+			args = []string{Title, Description}
+		}
+
+		return StripHTML{Fields: args}, checkFields(args)
+	case "truncate":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("truncate needs a field and a length")
+		}
+		length, err := strconv.Atoi(args[1])
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid length %q", args[1])
+		}
+
+		return Truncate{Field: args[0], Length: length}, checkFields(args[:1])
+	case "replace":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("replace needs a field and a pattern")
+		}
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return Replace{Field: args[0], Pattern: re, Replacement: rest(2)}, checkFields(args[:1])
+	case "rewrite_link":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("rewrite_link needs a pattern and a replacement")
+		}
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return Replace{Field: Link, Pattern: re, Replacement: args[1]}, nil
+	case "set_tags":
+		var tags []string
+		for _, tag := range strings.Split(rest(0), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("set_tags needs tags")
+		}
+
+		return SetTags{Tags: tags}, nil
+	case "drop":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("drop needs a field and a pattern")
+		}
+		re, err := regexp.Compile(rest(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return Drop{Field: args[0], Pattern: re}, checkFields(args[:1])
+	}
+
+	return nil, fmt.Errorf("unknown processor %q", name)
+}
+
+var spacePattern = regexp.MustCompile(`[\s\x{a0}]+`)
+
+// Trim collapses whitespace of the fields into single spaces and trims them.
+type Trim struct {
+	Fields []string
+}
+
+func (processor Trim) Process(item *repository.NewsItem) bool {
+	for _, name := range processor.Fields {
+		value := field(item, name)
+		*value = strings.TrimSpace(spacePattern.ReplaceAllString(*value, " "))
+	}
+
+	return true
+}
+
+// StripHTML replaces html markup of the fields with its text.
+type StripHTML struct {
+	Fields []string
+}
+
+func (processor StripHTML) Process(item *repository.NewsItem) bool {
+	for _, name := range processor.Fields {
+		value := field(item, name)
+		if !strings.ContainsAny(*value, "<&") {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(*value))
+		if err != nil {
+			continue
+		}
+		doc.Find("script, style").Remove()
+		*value = strings.TrimSpace(doc.Text())
+	}
+
+	return true
+}
+
+// Truncate cuts the field to Length characters, at the last word boundary if there is one,
+// and appends an ellipsis.
+type Truncate struct {
+	Field  string
+	Length int
+}
+
+func (processor Truncate) Process(item *repository.NewsItem) bool {
+	value := field(item, processor.Field)
+	runes := []rune(*value)
+	if len(runes) <= processor.Length {
+		return true
+	}
+
+	cut := string(runes[:processor.Length])
+	if space := strings.LastIndexAny(cut, " \t\n"); space > 0 {
+		cut = cut[:space]
+	}
+	*value = strings.TrimRight(cut, " \t\n.,;:-") + "…"
+
+	return true
+}
+
+// Replace replaces matches of the pattern in the field, the replacement may refer to groups as $1.
+type Replace struct {
+	Field       string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+func (processor Replace) Process(item *repository.NewsItem) bool {
+	value := field(item, processor.Field)
+	*value = processor.Pattern.ReplaceAllString(*value, processor.Replacement)
+
+	return true
+}
+
+// SetTags adds the tags to the news item.
+type SetTags struct {
+	Tags []string
+}
+
+func (processor SetTags) Process(item *repository.NewsItem) bool {
+	for _, tag := range processor.Tags {
+		if !hasTag(item.Tags, tag) {
+			item.Tags = append(item.Tags, tag)
+		}
+	}
+
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drop drops news whose field matches the pattern.
+type Drop struct {
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+func (processor Drop) Process(item *repository.NewsItem) bool {
+	return !processor.Pattern.MatchString(*field(item, processor.Field))
+}
@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrim(t *testing.T) {
+	item := repository.NewsItem{Title: "  Курс\n\tрубля  вырос ", Description: " текст ", Link: " https://news.ru/1 "}
+
+	assert.True(t, Trim{Fields: []string{Title}}.Process(&item))
+	assert.Equal(t, "Курс рубля вырос", item.Title)
+	assert.Equal(t, " текст ", item.Description)
+
+	Trim{Fields: textFields}.Process(&item)
+	assert.Equal(t, "текст", item.Description)
+	assert.Equal(t, "https://news.ru/1", item.Link)
+}
+
+func TestStripHTML(t *testing.T) {
+	item := repository.NewsItem{
+		Title:       "Нефть &amp; газ",
+		Description: `<p>Цены <b>выросли</b></p><script>alert(1)</script>`,
+		Link:        "https://news.ru/1?a=1&b=2",
+	}
+
+	assert.True(t, StripHTML{Fields: []string{Title, Description}}.Process(&item))
+	assert.Equal(t, "Нефть & газ", item.Title)
+	assert.Equal(t, "Цены выросли", item.Description)
+	assert.Equal(t, "https://news.ru/1?a=1&b=2", item.Link)
+}
+
+func TestTruncate(t *testing.T) {
+	item := repository.NewsItem{Title: "Курс рубля вырос, а нефть подешевела"}
+	assert.True(t, Truncate{Field: Title, Length: 18}.Process(&item))
+	assert.Equal(t, "Курс рубля вырос…", item.Title)
+
+	item.Title = "Короткий"
+	Truncate{Field: Title, Length: 20}.Process(&item)
+	assert.Equal(t, "Короткий", item.Title)
+
+	item.Title = "Длинноеслово"
+	Truncate{Field: Title, Length: 5}.Process(&item)
+	assert.Equal(t, "Длинн…", item.Title)
+}
+
+func TestReplace(t *testing.T) {
+	item := repository.NewsItem{Title: "12.05.2020 Курс рубля вырос", Link: "http://news.ru/amp/1"}
+
+	assert.True(t, Replace{Field: Title, Pattern: regexp.MustCompile(`^\d{2}\.\d{2}\.\d{4}\s+`)}.Process(&item))
+	assert.Equal(t, "Курс рубля вырос", item.Title)
+
+	Replace{Field: Link, Pattern: regexp.MustCompile(`^http://news.ru/amp/(.+)$`), Replacement: "https://news.ru/$1"}.Process(&item)
+	assert.Equal(t, "https://news.ru/1", item.Link)
+}
+
+func TestSetTags(t *testing.T) {
+	item := repository.NewsItem{Tags: []string{"Спорт"}}
+
+	assert.True(t, SetTags{Tags: []string{"спорт", "футбол"}}.Process(&item))
+	assert.Equal(t, []string{"Спорт", "футбол"}, item.Tags)
+}
+
+func TestDrop(t *testing.T) {
+	drop := Drop{Field: Title, Pattern: regexp.MustCompile(`(?i)^реклама`)}
+
+	assert.False(t, drop.Process(&repository.NewsItem{Title: "Реклама: банк"}))
+	assert.True(t, drop.Process(&repository.NewsItem{Title: "Банк снизил ставки"}))
+}
+
+func TestParse(t *testing.T) {
+	pipeline, err := Parse(`
+# очистка заголовков
+replace title ^\d{2}\.\d{2}\.\d{4}\s+
+strip_html
+trim
+truncate description 10
+rewrite_link ^http:// https://
+set_tags экономика, финансы
+drop title (?i)^реклама
+`)
+	assert.NoError(t, err)
+	assert.Len(t, pipeline, 7)
+
+	item := repository.NewsItem{Title: "12.05.2020  Курс <b>рубля</b>", Description: "Рубль вырос к доллару", Link: "http://news.ru/1"}
+	assert.True(t, pipeline.Process(&item))
+	assert.Equal(t, repository.NewsItem{
+		Title:       "Курс рубля",
+		Description: "Рубль…",
+		Link:        "https://news.ru/1",
+		Tags:        []string{"экономика", "финансы"},
+	}, item)
+
+	assert.False(t, pipeline.Process(&repository.NewsItem{Title: "Реклама банка"}))
+
+	pipeline, err = Parse("replace title ^Рубль Российский рубль")
+	assert.NoError(t, err)
+	item = repository.NewsItem{Title: "Рубль - доллар"}
+	pipeline.Process(&item)
+	assert.Equal(t, "Российский рубль - доллар", item.Title)
+
+	empty, err := Parse("\n  \n")
+	assert.NoError(t, err)
+	assert.True(t, empty.Process(&item))
+
+	for _, config := range []string{
+		"uppercase title",
+		"trim author",
+		"truncate title",
+		"truncate title ten",
+		"replace title (",
+		"rewrite_link ^http://",
+		"set_tags , ",
+		"drop link",
+	} {
+		_, err := Parse("trim\n" + config)
+		assert.Error(t, err, config)
+		assert.Contains(t, err.Error(), "line 2", config)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const feedSize = 50
+
+// userNewsFilter applies the unread query parameter of the logged in user.
+func userNewsFilter(filter repository.NewsFilter, query url.Values, user *repository.User) repository.NewsFilter {
+	if query.Get("unread") == "1" {
+		filter.UnreadBy = user.ID
+	}
+
+	return filter
+}
+
+// newsRow is a news item with its state for the logged in user.
+type newsRow struct {
+	repository.NewsItem
+	Read    bool
+	Starred bool
+	// Sources is the number of other sites which reported the story.
+	Sources int
+	// Permalink is the page of the news item returning to the current list.
+	Permalink string
+}
+
+func (app *application) newsRows(user *repository.User, items []repository.NewsItem) ([]newsRow, error) {
+	rows := make([]newsRow, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, newsRow{NewsItem: item})
+	}
+	if user == nil || len(items) == 0 {
+		return rows, nil
+	}
+
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	read, err := app.repository.GetReadMarks(user.ID, ids)
+	if err != nil {
+		return nil, err
+	}
+	starred, err := app.repository.GetBookmarks(user.ID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	isRead := make(map[int]bool, len(read))
+	for _, id := range read {
+		isRead[id] = true
+	}
+	isStarred := make(map[int]bool, len(starred))
+	for _, id := range starred {
+		isStarred[id] = true
+	}
+	for i := range rows {
+		rows[i].Read = isRead[rows[i].ID]
+		rows[i].Starred = isStarred[rows[i].ID]
+	}
+
+	return rows, nil
+}
+
+// goHandler redirects to the news item marking it read for the logged in user.
+func (app *application) goHandler(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/go/"))
+	if err != nil {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	}
+
+	item, err := app.repository.GetNewsItem(id)
+	if err == repository.ErrNotFound {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte("Страница не найдена"))
+
+		return
+	} else if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+		return
+	}
+
+	if user := app.user(req); user != nil {
+		err = app.repository.MarkRead(user.ID, item.ID)
+		if err != nil {
+			app.requestLog(req).WithError(err).Error("Fail mark news read in repository")
+		}
+	}
+
+	http.Redirect(res, req, item.Link, http.StatusFound)
+}
+
+// readHandler marks the news item read or unread, with upto=1 it also marks read
+// all newer news of the list the form was sent from.
+func (app *application) readHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	next := safeNext(req.FormValue("next"))
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, next, http.StatusSeeOther)
+
+		return
+	}
+
+	user := app.user(req)
+	if req.FormValue("upto") == "1" {
+		err = app.markReadUpTo(user, id, next)
+	} else if req.FormValue("read") == "1" {
+		err = app.repository.MarkRead(user.ID, id)
+	} else {
+		err = app.repository.MarkUnread(user.ID, id)
+	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail update read marks in repository")
+
+		return
+	}
+
+	http.Redirect(res, req, next, http.StatusSeeOther)
+}
+
+// markReadUpTo marks read news of the list at next up to the news item.
+func (app *application) markReadUpTo(user *repository.User, newsID int, next string) error {
+	list, err := url.Parse(next)
+	if err != nil {
+		return err
+	}
+
+	filter := userNewsFilter(newsFilterFromQuery(list.Query()), list.Query(), user)
+	if list.Path == "/starred" {
+		filter.StarredBy = user.ID
+	} else {
+		subscriptions, err := app.repository.GetSubscriptions(user.ID)
+		if err != nil {
+			return err
+		}
+		filter = restrictToSites(filter, subscriptions)
+	}
+
+	return app.repository.MarkReadUpTo(user.ID, newsID, filter)
+}
+
+func (app *application) starHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	next := safeNext(req.FormValue("next"))
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, next, http.StatusSeeOther)
+
+		return
+	}
+
+	user := app.user(req)
+	if req.FormValue("star") == "1" {
+		err = app.repository.AddBookmark(user.ID, id)
+	} else {
+		err = app.repository.DeleteBookmark(user.ID, id)
+	}
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail update bookmarks in repository")
+
+		return
+	}
+
+	http.Redirect(res, req, next, http.StatusSeeOther)
+}
+
+func (app *application) starredHandler(res http.ResponseWriter, req *http.Request) {
+	user := app.user(req)
+	filter := userNewsFilter(newsFilterFromQuery(req.URL.Query()), req.URL.Query(), user)
+	filter.StarredBy = user.ID
+
+	app.renderNews(res, req, "Избранное", filter, nil)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description,omitempty"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// starredFeedHandler serves the latest starred news of the user as RSS.
+func (app *application) starredFeedHandler(res http.ResponseWriter, req *http.Request) {
+	user := app.user(req)
+	page, err := app.repository.GetNews(repository.NewsCursor{}, feedSize, repository.NewsFilter{StarredBy: user.ID})
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get news from repository")
+
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Избранное - " + user.Login,
+			Link:        "http://" + req.Host + "/starred",
+			Description: "Избранные новости агрегатора",
+		},
+	}
+	for _, item := range page.Items {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: item.Description,
+			GUID:        item.Link,
+			PubDate:     item.PublishedAt.Format(time.RFC1123Z),
+		})
+	}
+
+	res.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	res.Write([]byte(xml.Header))
+	err = xml.NewEncoder(res).Encode(feed)
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail encode feed")
+	}
+}
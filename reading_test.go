@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainHandlerReadState(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1, Login: "user"}
+
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{UnreadBy: 1, Collapse: true}).
+		Return(repository.NewsPage{Total: 2, HasNext: true, Items: []repository.NewsItem{
+			{ID: 12, Title: "Заголовок 1", Link: "http://test1.ru/news/1"},
+			{ID: 11, Title: "Заголовок 2", Link: "http://test1.ru/news/2"},
+		}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 1, Url: "http://test1.ru"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetReadMarks", 1, []int{12, 11}).
+		Return([]int{11}, nil)
+	app.repository.(*mockedRepository).
+		On("GetBookmarks", 1, []int{12, 11}).
+		Return([]int{12}, nil)
+	app.repository.(*mockedRepository).
+		On("GetStorySources", []int{12, 11}).
+		Return([]repository.StorySources{}, nil)
+
+	req, _ := http.NewRequest("GET", "/?unread=1", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.mainHandler).ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<a target="_blank" href="/go/12">Заголовок 1</a>`)
+	assert.Contains(t, rr.Body.String(), `<article class="read">`)
+	assert.Contains(t, rr.Body.String(), "Убрать из избранного")
+	assert.Contains(t, rr.Body.String(), `name="unread" value="1" checked`)
+	assert.Contains(t, rr.Body.String(), `<a href="?before=11&amp;unread=1">-></a>`)
+	assert.Contains(t, rr.Body.String(), `name="next" value="/?unread=1"`)
+}
+
+func TestGoHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetNewsItem", 5).
+		Return(repository.NewsItem{ID: 5, Link: "http://test1.ru/news/5"}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNewsItem", 6).
+		Return(repository.NewsItem{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("MarkRead", 1, 5).
+		Return(nil)
+	handler := http.HandlerFunc(app.goHandler)
+
+	req, _ := http.NewRequest("GET", "/go/5", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "http://test1.ru/news/5", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertNotCalled(t, "MarkRead", 1, 5)
+
+	req, _ = http.NewRequest("GET", "/go/5", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, &repository.User{ID: 1}))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	app.repository.(*mockedRepository).AssertCalled(t, "MarkRead", 1, 5)
+
+	req, _ = http.NewRequest("GET", "/go/6", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestReadHandler(t *testing.T) {
+	app := getApplication()
+	user := &repository.User{ID: 1}
+	app.repository.(*mockedRepository).
+		On("MarkRead", 1, 3).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("MarkUnread", 1, 4).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("GetSubscriptions", 1).
+		Return([]int{1, 2}, nil)
+	app.repository.(*mockedRepository).
+		On("MarkReadUpTo", 1, 5, repository.NewsFilter{Search: "test", SiteIDs: []int{2}, UnreadBy: 1}).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("MarkReadUpTo", 1, 6, repository.NewsFilter{StarredBy: 1}).
+		Return(nil)
+	handler := http.HandlerFunc(app.readHandler)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/news/read", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withUser(req, user))
+
+		return rr
+	}
+
+	rr := post("id=3&read=1&next=%2F%3Fbefore%3D10")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/?before=10", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertCalled(t, "MarkRead", 1, 3)
+
+	rr = post("id=4&next=https%3A%2F%2Fevil.com")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertCalled(t, "MarkUnread", 1, 4)
+
+	rr = post("id=5&upto=1&next=%2F%3Fq%3Dtest%26site%3D2%26site%3D3%26unread%3D1")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "MarkReadUpTo", 1)
+
+	rr = post("id=6&upto=1&next=%2Fstarred")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/starred", rr.Header().Get("Location"))
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "MarkReadUpTo", 2)
+}
+
+func TestStarHandler(t *testing.T) {
+	app := getApplication()
+	user := &repository.User{ID: 1}
+	app.repository.(*mockedRepository).
+		On("AddBookmark", 1, 3).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("DeleteBookmark", 1, 4).
+		Return(nil)
+	handler := http.HandlerFunc(app.starHandler)
+
+	req, _ := http.NewRequest("POST", "/news/star", strings.NewReader("id=3&star=1&next=%2Fstarred"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/starred", rr.Header().Get("Location"))
+
+	req, _ = http.NewRequest("POST", "/news/star", strings.NewReader("id=4"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddBookmark", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "DeleteBookmark", 1)
+}
+
+func TestStarredHandlers(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1, Login: "user"}
+	items := []repository.NewsItem{{ID: 7, Title: "Избранная <новость>", Link: "http://test1.ru/news/7"}}
+
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, 10, repository.NewsFilter{StarredBy: 1}).
+		Return(repository.NewsPage{Total: 1, Items: items}, nil)
+	app.repository.(*mockedRepository).
+		On("GetNews", repository.NewsCursor{}, feedSize, repository.NewsFilter{StarredBy: 1}).
+		Return(repository.NewsPage{Total: 1, Items: items}, nil)
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetReadMarks", 1, []int{7}).
+		Return([]int{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetBookmarks", 1, []int{7}).
+		Return([]int{7}, nil)
+
+	req, _ := http.NewRequest("GET", "/starred", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.starredHandler).ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<h1>Избранное</h1>")
+	assert.Contains(t, rr.Body.String(), `action="/starred"`)
+
+	req, _ = http.NewRequest("GET", "/starred/rss", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.starredFeedHandler).ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/rss+xml; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `<rss version="2.0">`)
+	assert.Contains(t, rr.Body.String(), "<title>Избранная &lt;новость&gt;</title>")
+	assert.Contains(t, rr.Body.String(), "<link>http://test1.ru/news/7</link>")
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"time"
+)
+
+// Kinds of alert rules and site filters.
+const (
+	// AlertKeyword matches any of comma separated words.
+	AlertKeyword = "keyword"
+	// AlertRegex matches a regular expression.
+	AlertRegex = "regex"
+	// AlertQuery matches a full-text query of words, "phrases", -exclusions and OR.
+	AlertQuery = "query"
+)
+
+// AlertRule delivers new news matching the query to the webhook and the Telegram chat.
+type AlertRule struct {
+	ID     int
+	UserID int    `gorm:"not null;index"`
+	Name   string `gorm:"size:100;not null"`
+	Kind   string `gorm:"size:10;not null"`
+	Query  string `gorm:"size:500;not null"`
+	// SiteID restricts the rule to news of the site, 0 matches all sites.
+	SiteID     int
+	WebhookUrl string `gorm:"size:500;not null"`
+	// TelegramChat is the chat id or @channel name receiving matches, empty to disable.
+	TelegramChat string `gorm:"size:100;not null"`
+	// Secret signs webhook payloads.
+	Secret    string `gorm:"size:64;not null"`
+	CreatedAt time.Time
+}
+
+// AlertDelivery is the result of delivering a news item matched by a rule.
+type AlertDelivery struct {
+	ID         int
+	RuleID     int `gorm:"not null;index"`
+	NewsItemID int `gorm:"not null"`
+	Attempts   int
+	StatusCode int
+	Error      string
+	Delivered  bool
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (rep *repository) GetAlertRules() (rules []AlertRule, err error) {
+	err = rep.conn.Order("id").Find(&rules).Error
+
+	return
+}
+
+func (rep *repository) GetUserAlertRules(userID int) (rules []AlertRule, err error) {
+	err = rep.conn.Where("user_id = ?", userID).Order("id desc").Find(&rules).Error
+
+	return
+}
+
+func (rep *repository) AddAlertRule(rule *AlertRule) error {
+	return rep.conn.Create(rule).Error
+}
+
+func (rep *repository) DeleteAlertRule(userID int, id int) error {
+	return rep.conn.Where("user_id = ? AND id = ?", userID, id).Delete(&AlertRule{}).Error
+}
+
+func (rep *repository) AddAlertDelivery(delivery *AlertDelivery) error {
+	return rep.conn.Create(delivery).Error
+}
+
+// GetAlertDeliveries returns the latest deliveries of rules of the user.
+func (rep *repository) GetAlertDeliveries(userID int, limit int) (deliveries []AlertDelivery, err error) {
+	err = rep.conn.
+		Where("rule_id IN (SELECT id FROM alert_rules WHERE user_id = ?)", userID).
+		Order("id desc").
+		Limit(limit).
+		Find(&deliveries).Error
+
+	return
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"time"
+)
+
+// Scopes of api tokens, each scope includes the previous ones.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// ApiToken is a personal token for programmatic access, only the hash of the token is stored.
+type ApiToken struct {
+	ID         int
+	UserID     int    `gorm:"not null;index"`
+	Name       string `gorm:"size:100;not null"`
+	Hash       string `gorm:"size:64;unique;not null"`
+	Scope      string `gorm:"size:10;not null"`
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (rep *repository) AddApiToken(token *ApiToken) error {
+	return rep.conn.Create(token).Error
+}
+
+func (rep *repository) GetApiTokens(userID int) (tokens []ApiToken, err error) {
+	err = rep.conn.Where("user_id = ?", userID).Order("id desc").Find(&tokens).Error
+
+	return
+}
+
+func (rep *repository) GetApiTokenByHash(hash string) (token ApiToken, err error) {
+	err = rep.conn.Where("hash = ?", hash).First(&token).Error
+
+	return
+}
+
+func (rep *repository) DeleteApiToken(userID int, id int) error {
+	return rep.conn.Where("user_id = ? AND id = ?", userID, id).Delete(&ApiToken{}).Error
+}
+
+func (rep *repository) TouchApiToken(id int, usedAt time.Time) error {
+	return rep.conn.Model(&ApiToken{ID: id}).UpdateColumn("last_used_at", usedAt).Error
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetApiTokenByHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		rows  *sqlmock.Rows
+		token ApiToken
+		err   error
+	}{
+		{
+			name:  "found",
+			rows:  sqlmock.NewRows([]string{"id", "user_id", "hash", "scope"}).AddRow(2, 3, "abc", ScopeWrite),
+			token: ApiToken{ID: 2, UserID: 3, Hash: "abc", Scope: ScopeWrite},
+		},
+		{
+			name: "not found",
+			rows: sqlmock.NewRows([]string{"id"}),
+			err:  gorm.ErrRecordNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, mock := newMockedRepository(t)
+			mock.ExpectQuery(`SELECT \* FROM "api_tokens" WHERE \(hash = \$1\) ORDER BY "api_tokens"\."id" ASC LIMIT 1`).
+				WithArgs("abc").
+				WillReturnRows(tt.rows)
+
+			token, err := rep.GetApiTokenByHash("abc")
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.token, token)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteApiToken(t *testing.T) {
+	rep, mock := newMockedRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "api_tokens" WHERE \(user_id = \$1 AND id = \$2\)`).
+		WithArgs(3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, rep.DeleteApiToken(3, 2))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTouchApiToken(t *testing.T) {
+	usedAt := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	rep, mock := newMockedRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "api_tokens" SET "last_used_at" = \$1 WHERE "api_tokens"\."id" = \$2`).
+		WithArgs(usedAt, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, rep.TouchApiToken(2, usedAt))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"time"
+)
+
+// Frequencies of digests.
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Digest periodically emails news of the user subscriptions matching the saved search.
+type Digest struct {
+	ID        int
+	UserID    int    `gorm:"not null;index"`
+	Email     string `gorm:"size:250;not null"`
+	Frequency string `gorm:"size:10;not null"`
+	// Search is the saved search as news filter query parameters, empty for all news.
+	Search string `gorm:"size:500;not null"`
+	// LastNewsID is the newest news item already sent, the next digest starts after it.
+	LastNewsID int
+	LastSentAt time.Time `gorm:"not null"`
+	CreatedAt  time.Time
+}
+
+func (rep *repository) GetDigests() (digests []Digest, err error) {
+	err = rep.conn.Order("id").Find(&digests).Error
+
+	return
+}
+
+func (rep *repository) GetUserDigests(userID int) (digests []Digest, err error) {
+	err = rep.conn.Where("user_id = ?", userID).Order("id desc").Find(&digests).Error
+
+	return
+}
+
+func (rep *repository) AddDigest(digest *Digest) error {
+	return rep.conn.Create(digest).Error
+}
+
+func (rep *repository) DeleteDigest(userID int, id int) error {
+	return rep.conn.Where("user_id = ? AND id = ?", userID, id).Delete(&Digest{}).Error
+}
+
+// MarkDigestSent moves the digest cursor to the last sent news item.
+func (rep *repository) MarkDigestSent(id int, lastNewsID int, sentAt time.Time) error {
+	return rep.conn.Model(&Digest{ID: id}).Updates(map[string]interface{}{
+		"last_news_id": lastNewsID,
+		"last_sent_at": sentAt,
+	}).Error
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"time"
+)
+
+type ParseRun struct {
+	ID         int
+	SiteID     int       `gorm:"not null;index"`
+	StartedAt  time.Time `gorm:"not null;index"`
+	FinishedAt time.Time `gorm:"not null"`
+	StatusCode int
+	Bytes      int64
+	ItemsFound int
+	Inserted   int
+	// Filtered is the number of found items dropped by the site processors and filters.
+	Filtered int
+	Error    string
+}
+
+// Duration returns how long the run took.
+func (run ParseRun) Duration() time.Duration {
+	return run.FinishedAt.Sub(run.StartedAt)
+}
+
+// DailyStat aggregates parse runs of a site started in one day.
+type DailyStat struct {
+	Day         time.Time
+	Runs        int
+	Inserted    int
+	AvgDuration float64
+}
+
+func (rep *repository) AddParseRun(run *ParseRun) error {
+	return rep.conn.Create(run).Error
+}
+
+func (rep *repository) GetParseRuns(siteID int, limit int) (runs []ParseRun, err error) {
+	err = rep.conn.Where("site_id = ?", siteID).Order("started_at desc").Limit(limit).Find(&runs).Error
+
+	return
+}
+
+func (rep *repository) GetDailyStats(siteID int, since time.Time) (stats []DailyStat, err error) {
+	err = rep.conn.Model(&ParseRun{}).
+		Select("date_trunc('day', started_at) AS day, "+
+			"count(*) AS runs, "+
+			"coalesce(sum(inserted), 0) AS inserted, "+
+			"coalesce(avg(extract(epoch from finished_at - started_at)), 0) AS avg_duration").
+		Where("site_id = ? AND started_at >= ?", siteID, since).
+		Group("day").
+		Order("day").
+		Scan(&stats).Error
+
+	return
+}
+
+func (rep *repository) DeleteParseRunsBefore(before time.Time) error {
+	return rep.conn.Where("started_at < ?", before).Delete(&ParseRun{}).Error
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadMark is a news item seen by a user.
+type ReadMark struct {
+	UserID     int `gorm:"primary_key;auto_increment:false"`
+	NewsItemID int `gorm:"primary_key;auto_increment:false"`
+	CreatedAt  time.Time
+}
+
+// Bookmark is a news item starred by a user.
+type Bookmark struct {
+	UserID     int `gorm:"primary_key;auto_increment:false"`
+	NewsItemID int `gorm:"primary_key;auto_increment:false"`
+	CreatedAt  time.Time
+}
+
+// UnreadCount is the number of news of a site not read by a user.
+type UnreadCount struct {
+	SiteID int
+	Count  int
+}
+
+func (rep *repository) GetNewsItem(id int) (item NewsItem, err error) {
+	err = rep.conn.First(&item, id).Error
+	if err != nil {
+		return
+	}
+	items := []NewsItem{item}
+	err = rep.loadTags(items)
+
+	return items[0], err
+}
+
+func (rep *repository) MarkRead(userID int, newsID int) error {
+	return rep.conn.FirstOrCreate(&ReadMark{}, ReadMark{UserID: userID, NewsItemID: newsID}).Error
+}
+
+func (rep *repository) MarkUnread(userID int, newsID int) error {
+	return rep.conn.Where("user_id = ? AND news_item_id = ?", userID, newsID).Delete(&ReadMark{}).Error
+}
+
+// MarkReadUpTo marks read the news item and all newer news matching the filter.
+func (rep *repository) MarkReadUpTo(userID int, newsID int, filter NewsFilter) error {
+	items := rep.filterNews(filter).Select(fmt.Sprintf("%d, id, now()", userID)).Where("id >= ?", newsID).QueryExpr()
+
+	return rep.conn.Exec("INSERT INTO read_marks (user_id, news_item_id, created_at) ? ON CONFLICT DO NOTHING", items).Error
+}
+
+// GetReadMarks returns which of the news items are read by the user.
+func (rep *repository) GetReadMarks(userID int, newsIDs []int) (read []int, err error) {
+	if len(newsIDs) == 0 {
+		return
+	}
+	err = rep.conn.Model(&ReadMark{}).Where("user_id = ? AND news_item_id IN (?)", userID, newsIDs).Pluck("news_item_id", &read).Error
+
+	return
+}
+
+func (rep *repository) GetUnreadCounts(userID int) (counts []UnreadCount, err error) {
+	err = rep.filterNews(NewsFilter{UnreadBy: userID}).
+		Select("site_id, count(*) AS count").
+		Group("site_id").
+		Scan(&counts).Error
+
+	return
+}
+
+func (rep *repository) AddBookmark(userID int, newsID int) error {
+	return rep.conn.FirstOrCreate(&Bookmark{}, Bookmark{UserID: userID, NewsItemID: newsID}).Error
+}
+
+func (rep *repository) DeleteBookmark(userID int, newsID int) error {
+	return rep.conn.Where("user_id = ? AND news_item_id = ?", userID, newsID).Delete(&Bookmark{}).Error
+}
+
+// GetBookmarks returns which of the news items are starred by the user.
+func (rep *repository) GetBookmarks(userID int, newsIDs []int) (starred []int, err error) {
+	if len(newsIDs) == 0 {
+		return
+	}
+	err = rep.conn.Model(&Bookmark{}).Where("user_id = ? AND news_item_id IN (?)", userID, newsIDs).Pluck("news_item_id", &starred).Error
+
+	return
+}
@@ -2,13 +2,19 @@ package repository
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/onauryzbaev/go_news_final_/canonical"
 )
 
+// ErrNotFound is returned when a requested record does not exist.
+var ErrNotFound = gorm.ErrRecordNotFound
+
 type Site struct {
-	ID              int
-	IsRss           bool   `gorm:"not null"`
+	ID int
+	// Type is the name of the source parser of the site, like rss or html.
+	Type            string `gorm:"size:50;not null;default:'html'"`
 	Url             string `gorm:"size:500;unique;not null"`
 	NewsItemPath    string `gorm:"size:100"`
 	TitlePath       string `gorm:"size:100"`
@@ -16,6 +22,17 @@ type Site struct {
 	LinkPath        string `gorm:"size:100"`
 	DatePath        string `gorm:"size:100"`
 	ImagePath       string `gorm:"size:100"`
+	CategoryPath    string `gorm:"size:100"`
+	// DefaultTags are comma separated tags added to every news item of the site.
+	DefaultTags string `gorm:"size:500"`
+	// FetchContent enables fetching the article of every new news item,
+	// extracted with the ContentPath selector or a readability heuristic if empty.
+	FetchContent bool   `gorm:"not null;default:false"`
+	ContentPath  string `gorm:"size:100"`
+	// Processors is the pipeline configuration transforming parsed news of the site, one processor per line.
+	Processors string
+	// Options is a JSON object with the settings of the source type which have no column, see Option.
+	Options string
 }
 
 type NewsItem struct {
@@ -25,8 +42,51 @@ type NewsItem struct {
 	Title       string `gorm:"size:250"`
 	Description string
 	Link        string `gorm:"size:500;unique;not null"`
-	Date        string `gorm:"size:100"`
-	Image       string `gorm:"size:500"`
+	// CanonicalLink is the normalized link news are deduplicated on, Link is the original one.
+	CanonicalLink string `gorm:"size:500"`
+	Date          string `gorm:"size:100"`
+	Image         string `gorm:"size:500"`
+	// PublishedAt is the parsed publication date, the insertion time if Date could not be parsed.
+	PublishedAt time.Time `gorm:"not null;default:now();index"`
+	// Fingerprint is the SimHash of the title and description, 0 for too short texts.
+	Fingerprint int64 `gorm:"not null;default:0"`
+	// StoryID is the first news item of the story this one reports again, 0 if it starts a story.
+	StoryID int `gorm:"not null;default:0;index"`
+	// Content is the sanitized html of the article, ContentText is its plain text,
+	// both are empty unless the site fetches content.
+	Content     string
+	ContentText string
+	// Tags are the categories of the source, set by processors and default tags of the site.
+	// They are stored in the tags table and loaded by GetNews and GetNewsItem.
+	Tags []string `gorm:"-"`
+}
+
+// Story returns id of the story the news item belongs to.
+func (item NewsItem) Story() int {
+	if item.StoryID > 0 {
+		return item.StoryID
+	}
+
+	return item.ID
+}
+
+// NewsFilter restricts news returned by GetNews.
+type NewsFilter struct {
+	Search   string
+	SiteIDs  []int
+	From     time.Time
+	To       time.Time
+	HasImage bool
+	// UnreadBy selects news not read by the user.
+	UnreadBy int
+	// StarredBy selects news starred by the user.
+	StarredBy int
+	// Story selects news of the story.
+	Story int
+	// Tag selects news with the tag.
+	Tag string
+	// Collapse selects only the first matching news item of every story.
+	Collapse bool
 }
 
 type repository struct {
@@ -37,9 +97,130 @@ func NewRepository(conn *gorm.DB) *repository {
 	return &repository{conn}
 }
 
-func (rep *repository) Migrate() {
-	rep.conn.AutoMigrate(&Site{}, &NewsItem{})
-	rep.conn.Model(&NewsItem{}).AddForeignKey("site_id", "sites(id)", "CASCADE", "CASCADE")
+func (rep *repository) Migrate() error {
+	err := rep.conn.AutoMigrate(&Site{}, &NewsItem{}, &ParseRun{}, &User{}, &Session{}, &Subscription{}, &ApiToken{}, &ReadMark{}, &Bookmark{}, &AlertRule{}, &AlertDelivery{}, &Digest{}, &WebSubSubscription{}, &SiteFilter{}, &Tag{}, &NewsItemTag{}, &PrunedLink{}).Error
+	if err != nil {
+		return err
+	}
+
+	foreignKeys := []struct {
+		model interface{}
+		field string
+		dest  string
+	}{
+		{&NewsItem{}, "site_id", "sites(id)"},
+		{&ParseRun{}, "site_id", "sites(id)"},
+		{&Session{}, "user_id", "users(id)"},
+		{&Subscription{}, "user_id", "users(id)"},
+		{&Subscription{}, "site_id", "sites(id)"},
+		{&ApiToken{}, "user_id", "users(id)"},
+		{&ReadMark{}, "user_id", "users(id)"},
+		{&ReadMark{}, "news_item_id", "news_items(id)"},
+		{&Bookmark{}, "user_id", "users(id)"},
+		{&Bookmark{}, "news_item_id", "news_items(id)"},
+		{&AlertRule{}, "user_id", "users(id)"},
+		{&AlertDelivery{}, "rule_id", "alert_rules(id)"},
+		{&AlertDelivery{}, "news_item_id", "news_items(id)"},
+		{&Digest{}, "user_id", "users(id)"},
+		{&WebSubSubscription{}, "site_id", "sites(id)"},
+		{&SiteFilter{}, "site_id", "sites(id)"},
+		{&NewsItemTag{}, "news_item_id", "news_items(id)"},
+		{&NewsItemTag{}, "tag_id", "tags(id)"},
+	}
+	for _, key := range foreignKeys {
+		err = rep.conn.Model(key.model).AddForeignKey(key.field, key.dest, "CASCADE", "CASCADE").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rep.migrateSiteTypes()
+	if err != nil {
+		return err
+	}
+
+	return rep.migrateCanonicalLinks()
+}
+
+const canonicalLinkIndex = "idx_news_items_canonical_link"
+
+// migrateCanonicalLinks fills canonical links of news inserted before they were stored
+// and merges news with the same canonical link into the oldest one before making it unique.
+// Read marks, bookmarks, tags and alert deliveries of the merged news move to the kept one.
+func (rep *repository) migrateCanonicalLinks() error {
+	if rep.conn.Dialect().HasIndex("news_items", canonicalLinkIndex) {
+		return nil
+	}
+
+	tx := rep.conn.Begin()
+	err := fillCanonicalLinks(tx)
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	statements := []string{
+		`CREATE TEMP TABLE news_duplicates ON COMMIT DROP AS
+			SELECT id, keep_id FROM (
+				SELECT id, min(id) OVER (PARTITION BY canonical_link) AS keep_id FROM news_items
+			) AS grouped WHERE id <> keep_id`,
+		`INSERT INTO read_marks (user_id, news_item_id, created_at)
+			SELECT marks.user_id, duplicates.keep_id, min(marks.created_at)
+			FROM read_marks AS marks JOIN news_duplicates AS duplicates ON duplicates.id = marks.news_item_id
+			GROUP BY marks.user_id, duplicates.keep_id
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO bookmarks (user_id, news_item_id, created_at)
+			SELECT marks.user_id, duplicates.keep_id, min(marks.created_at)
+			FROM bookmarks AS marks JOIN news_duplicates AS duplicates ON duplicates.id = marks.news_item_id
+			GROUP BY marks.user_id, duplicates.keep_id
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO news_item_tags (news_item_id, tag_id)
+			SELECT DISTINCT duplicates.keep_id, tags.tag_id
+			FROM news_item_tags AS tags JOIN news_duplicates AS duplicates ON duplicates.id = tags.news_item_id
+			ON CONFLICT DO NOTHING`,
+		`UPDATE alert_deliveries SET news_item_id = duplicates.keep_id
+			FROM news_duplicates AS duplicates WHERE alert_deliveries.news_item_id = duplicates.id`,
+		`DELETE FROM news_items WHERE id IN (SELECT id FROM news_duplicates)`,
+	}
+	for _, statement := range statements {
+		err = tx.Exec(statement).Error
+		if err != nil {
+			tx.Rollback()
+
+			return err
+		}
+	}
+
+	err = tx.Model(&NewsItem{}).AddUniqueIndex(canonicalLinkIndex, "canonical_link").Error
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func fillCanonicalLinks(tx *gorm.DB) error {
+	var news []NewsItem
+	err := tx.Select("id, link").Where("canonical_link IS NULL OR canonical_link = ''").Find(&news).Error
+	if err != nil {
+		return err
+	}
+
+	for _, item := range news {
+		err = tx.Model(&NewsItem{}).Where("id = ?", item.ID).UpdateColumn("canonical_link", canonical.Link(item.Link)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rep *repository) Ping() error {
+	return rep.conn.DB().Ping()
 }
 
 func (rep *repository) GetSites() (sites []Site, err error) {
@@ -48,6 +229,12 @@ func (rep *repository) GetSites() (sites []Site, err error) {
 	return
 }
 
+func (rep *repository) GetSite(id int) (site Site, err error) {
+	err = rep.conn.First(&site, id).Error
+
+	return
+}
+
 func (rep *repository) AddSite(site *Site) error {
 	return rep.conn.FirstOrCreate(site, Site{Url: site.Url}).Error
 }
@@ -56,20 +243,145 @@ func (rep *repository) DeleteSite(id int) error {
 	return rep.conn.Delete(&Site{ID: id}).Error
 }
 
-func (rep *repository) GetNews(offset int, limit int, search string) (news []NewsItem, err error) {
-	q := rep.conn.Order("id desc").Offset(offset).Limit(limit)
-	if search != "" {
-		q = q.Where("title ILIKE ?", fmt.Sprintf("%%%s%%", search))
+// NewsCursor points to a page of news ordered by id desc.
+// Before selects items older than the id, After selects items newer than the id.
+type NewsCursor struct {
+	Before int
+	After  int
+}
+
+// NewsPage is a page of news selected by a cursor.
+type NewsPage struct {
+	Items   []NewsItem
+	Total   int
+	HasNext bool
+	HasPrev bool
+}
+
+// NextCursor returns cursor of the page following this one.
+func (page NewsPage) NextCursor() NewsCursor {
+	if len(page.Items) == 0 {
+		return NewsCursor{}
+	}
+
+	return NewsCursor{Before: page.Items[len(page.Items)-1].ID}
+}
+
+// PrevCursor returns cursor of the page preceding this one.
+func (page NewsPage) PrevCursor() NewsCursor {
+	if len(page.Items) == 0 {
+		return NewsCursor{}
+	}
+
+	return NewsCursor{After: page.Items[0].ID}
+}
+
+func (rep *repository) filterNews(filter NewsFilter) *gorm.DB {
+	q := rep.conn.Model(&NewsItem{})
+	if filter.Search != "" {
+		search := fmt.Sprintf("%%%s%%", filter.Search)
+		q = q.Where("title ILIKE ? OR content_text ILIKE ?", search, search)
+	}
+	if len(filter.SiteIDs) > 0 {
+		q = q.Where("site_id IN (?)", filter.SiteIDs)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("published_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("published_at < ?", filter.To)
+	}
+	if filter.HasImage {
+		q = q.Where("image <> ''")
+	}
+	if filter.UnreadBy > 0 {
+		q = q.Where("id NOT IN (SELECT news_item_id FROM read_marks WHERE user_id = ?)", filter.UnreadBy)
+	}
+	if filter.StarredBy > 0 {
+		q = q.Where("id IN (SELECT news_item_id FROM bookmarks WHERE user_id = ?)", filter.StarredBy)
+	}
+	if filter.Story > 0 {
+		q = q.Where("id = ? OR story_id = ?", filter.Story, filter.Story)
+	}
+	if tag := NormalizeTag(filter.Tag); tag != "" {
+		q = q.Where(
+			"id IN (SELECT news_item_tags.news_item_id FROM news_item_tags JOIN tags ON tags.id = news_item_tags.tag_id WHERE tags.name = ?)",
+			tag,
+		)
+	}
+	if filter.Collapse {
+		filter.Collapse = false
+		first := rep.filterNews(filter).Select("min(id)").Group(storyExpr).SubQuery()
+		q = q.Where("id IN (?)", first)
+	}
+
+	return q
+}
+
+func (rep *repository) GetNews(cursor NewsCursor, limit int, filter NewsFilter) (page NewsPage, err error) {
+	err = rep.filterNews(filter).Count(&page.Total).Error
+	if err != nil {
+		return
 	}
-	err = q.Find(&news).Error
+
+	q := rep.filterNews(filter).Limit(limit + 1)
+	if cursor.After > 0 {
+		err = q.Where("id > ?", cursor.After).Order("id asc").Find(&page.Items).Error
+		if err != nil {
+			return
+		}
+		page.HasPrev = len(page.Items) > limit
+		var older []int
+		err = rep.filterNews(filter).Where("id <= ?", cursor.After).Limit(1).Pluck("id", &older).Error
+		if err != nil {
+			return
+		}
+		page.HasNext = len(older) > 0
+		if page.HasPrev {
+			page.Items = page.Items[:limit]
+		}
+		for i, j := 0, len(page.Items)-1; i < j; i, j = i+1, j-1 {
+			page.Items[i], page.Items[j] = page.Items[j], page.Items[i]
+		}
+		err = rep.loadTags(page.Items)
+
+		return
+	}
+
+	if cursor.Before > 0 {
+		q = q.Where("id < ?", cursor.Before)
+	}
+	err = q.Order("id desc").Find(&page.Items).Error
+	if err != nil {
+		return
+	}
+	page.HasNext = len(page.Items) > limit
+	page.HasPrev = cursor.Before > 0
+	if page.HasNext {
+		page.Items = page.Items[:limit]
+	}
+	err = rep.loadTags(page.Items)
 
 	return
 }
 
+// GetNewsAfter returns up to limit news matching the filter with ids greater than afterID in ascending order,
+// it lets all matching news be read in batches.
+func (rep *repository) GetNewsAfter(afterID int, limit int, filter NewsFilter) (news []NewsItem, err error) {
+	err = rep.filterNews(filter).Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&news).Error
+	if err != nil {
+		return
+	}
+	err = rep.loadTags(news)
+
+	return
+}
+
+// HasNewsItem reports whether the news item is stored or was pruned, matching its link or canonical link.
 func (rep *repository) HasNewsItem(item NewsItem) (bool, error) {
-	q := rep.conn.Where("link = ?", item.Link).First(&NewsItem{})
+	q := rep.conn.Where("link = ? OR canonical_link = ?", item.Link, canonicalLink(item)).First(&NewsItem{})
 	if q.RecordNotFound() {
-		return false, nil
+		return rep.isPruned(item.Link, canonicalLink(item))
 	} else if q.Error != nil {
 		return false, q.Error
 	}
@@ -78,5 +390,28 @@ func (rep *repository) HasNewsItem(item NewsItem) (bool, error) {
 }
 
 func (rep *repository) AddNewsItem(item *NewsItem) error {
-	return rep.conn.Create(item).Error
+	item.CanonicalLink = canonicalLink(*item)
+	item.Tags = NormalizeTags(item.Tags)
+
+	tx := rep.conn.Begin()
+	err := tx.Create(item).Error
+	if err == nil {
+		err = addTags(tx, item.ID, item.Tags)
+	}
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// canonicalLink returns the canonical link of the news item, derived from the link if not set.
+func canonicalLink(item NewsItem) string {
+	if item.CanonicalLink != "" {
+		return item.CanonicalLink
+	}
+
+	return canonical.Link(item.Link)
 }
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockedRepository returns the repository on a mocked postgres connection.
+func newMockedRepository(t *testing.T) (*repository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := gorm.Open("postgres", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRepository(conn), mock
+}
+
+func TestMigrateCanonicalLinks(t *testing.T) {
+	rep, mock := newMockedRepository(t)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM pg_indexes`).
+		WithArgs("news_items", canonicalLinkIndex).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, link FROM "news_items" WHERE \(canonical_link IS NULL OR canonical_link = ''\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "link"}).AddRow(3, "https://news.ru/1?utm_source=rss"))
+	mock.ExpectExec(`UPDATE "news_items" SET "canonical_link" = \$1 WHERE \(id = \$2\)`).
+		WithArgs("https://news.ru/1", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`CREATE TEMP TABLE news_duplicates`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO read_marks .* duplicates\.keep_id`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO bookmarks .* duplicates\.keep_id`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO news_item_tags \(news_item_id, tag_id\)\s+SELECT DISTINCT duplicates\.keep_id, tags\.tag_id .* ON CONFLICT DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE alert_deliveries SET news_item_id = duplicates\.keep_id`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM news_items WHERE id IN \(SELECT id FROM news_duplicates\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`CREATE UNIQUE INDEX idx_news_items_canonical_link ON "news_items"\(canonical_link\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.NoError(t, rep.migrateCanonicalLinks())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateCanonicalLinksDone(t *testing.T) {
+	rep, mock := newMockedRepository(t)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM pg_indexes`).
+		WithArgs("news_items", canonicalLinkIndex).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	assert.NoError(t, rep.migrateCanonicalLinks())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetNews(t *testing.T) {
+	newsRows := func(ids ...int) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "site_id", "title"})
+		for _, id := range ids {
+			rows.AddRow(id, 1, "news")
+		}
+		return rows
+	}
+	tests := []struct {
+		name    string
+		cursor  NewsCursor
+		expect  func(mock sqlmock.Sqlmock)
+		ids     []int
+		hasNext bool
+		hasPrev bool
+	}{
+		{
+			name:   "first page",
+			cursor: NewsCursor{},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "news_items" +ORDER BY id desc LIMIT 3`).
+					WillReturnRows(newsRows(9, 8, 7))
+			},
+			ids:     []int{9, 8},
+			hasNext: true,
+		},
+		{
+			name:   "last page",
+			cursor: NewsCursor{Before: 7},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "news_items" WHERE \(id < \$1\) ORDER BY id desc LIMIT 3`).
+					WithArgs(7).
+					WillReturnRows(newsRows(6))
+			},
+			ids:     []int{6},
+			hasPrev: true,
+		},
+		{
+			name:   "previous page",
+			cursor: NewsCursor{After: 6},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "news_items" WHERE \(id > \$1\) ORDER BY id asc LIMIT 3`).
+					WithArgs(6).
+					WillReturnRows(newsRows(7, 8, 9))
+				mock.ExpectQuery(`SELECT id FROM "news_items" WHERE \(id <= \$1\) LIMIT 1`).
+					WithArgs(6).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+			},
+			ids:     []int{8, 7},
+			hasNext: true,
+			hasPrev: true,
+		},
+		{
+			name:   "previous page without older news",
+			cursor: NewsCursor{After: 6},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "news_items" WHERE \(id > \$1\) ORDER BY id asc LIMIT 3`).
+					WithArgs(6).
+					WillReturnRows(newsRows(7, 8))
+				mock.ExpectQuery(`SELECT id FROM "news_items" WHERE \(id <= \$1\) LIMIT 1`).
+					WithArgs(6).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			ids: []int{8, 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, mock := newMockedRepository(t)
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "news_items"`).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+			tt.expect(mock)
+			mock.ExpectQuery(`SELECT news_item_tags\.news_item_id, tags\.name FROM "news_item_tags"`).
+				WillReturnRows(sqlmock.NewRows([]string{"news_item_id", "name"}))
+
+			page, err := rep.GetNews(tt.cursor, 2, NewsFilter{})
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			var ids []int
+			for _, item := range page.Items {
+				ids = append(ids, item.ID)
+			}
+			assert.Equal(t, tt.ids, ids)
+			assert.Equal(t, 4, page.Total)
+			assert.Equal(t, tt.hasNext, page.HasNext)
+			assert.Equal(t, tt.hasPrev, page.HasPrev)
+		})
+	}
+}
+
+func TestFilterNews(t *testing.T) {
+	from := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 9, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter NewsFilter
+		where  string
+		args   []driver.Value
+	}{
+		{
+			name:   "search",
+			filter: NewsFilter{Search: "нефть"},
+			where:  `\(title ILIKE \$1 OR content_text ILIKE \$2\)`,
+			args:   []driver.Value{"%нефть%", "%нефть%"},
+		},
+		{
+			name:   "sites and dates",
+			filter: NewsFilter{SiteIDs: []int{1, 2}, From: from, To: to},
+			where:  `\(site_id IN \(\$1,\$2\)\) AND \(published_at >= \$3\) AND \(published_at < \$4\)`,
+			args:   []driver.Value{1, 2, from, to},
+		},
+		{
+			name:   "image",
+			filter: NewsFilter{HasImage: true},
+			where:  `\(image <> ''\)`,
+		},
+		{
+			name:   "unread and starred",
+			filter: NewsFilter{UnreadBy: 3, StarredBy: 3},
+			where: `\(id NOT IN \(SELECT news_item_id FROM read_marks WHERE user_id = \$1\)\) ` +
+				`AND \(id IN \(SELECT news_item_id FROM bookmarks WHERE user_id = \$2\)\)`,
+			args: []driver.Value{3, 3},
+		},
+		{
+			name:   "story",
+			filter: NewsFilter{Story: 5},
+			where:  `\(id = \$1 OR story_id = \$2\)`,
+			args:   []driver.Value{5, 5},
+		},
+		{
+			name:   "tag",
+			filter: NewsFilter{Tag: "  Экономика "},
+			where:  `\(id IN \(SELECT news_item_tags\.news_item_id FROM news_item_tags JOIN tags ON tags\.id = news_item_tags\.tag_id WHERE tags\.name = \$1\)\)`,
+			args:   []driver.Value{"экономика"},
+		},
+		{
+			name:   "blank tag",
+			filter: NewsFilter{Tag: " "},
+		},
+		{
+			name:   "collapse",
+			filter: NewsFilter{Collapse: true, SiteIDs: []int{1}},
+			where: `\(site_id IN \(\$1\)\) AND \(id IN \(\(SELECT min\(id\) FROM "news_items" +WHERE \(site_id IN \(\$2\)\) ` +
+				`GROUP BY coalesce\(nullif\(story_id, 0\), id\)\)\)\)`,
+			args: []driver.Value{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, mock := newMockedRepository(t)
+			query := `SELECT \* FROM "news_items" WHERE `
+			if tt.where != "" {
+				query += tt.where + ` AND `
+			}
+			query += `\(id > \$\d+\) ORDER BY id asc LIMIT 10$`
+			mock.ExpectQuery(query).
+				WithArgs(append(tt.args, 0)...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			news, err := rep.GetNewsAfter(0, 10, tt.filter)
+			assert.NoError(t, err)
+			assert.Empty(t, news)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
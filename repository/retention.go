@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RetentionPolicy selects news which are no longer kept.
+type RetentionPolicy struct {
+	// Before expires news published before the time, zero keeps news of any age.
+	Before time.Time
+	// MaxPerSite expires news of a site beyond the newest MaxPerSite ones, 0 keeps any number.
+	MaxPerSite int
+	// KeepStarred keeps news starred by any user even if they are expired.
+	KeepStarred bool
+}
+
+// PrunedLink is the tombstone of a pruned news item. HasNewsItem finds news by their tombstones,
+// so news pruned while they are still in the feed of the site are not inserted again.
+type PrunedLink struct {
+	ID            int
+	Link          string    `gorm:"size:500;not null;index"`
+	CanonicalLink string    `gorm:"size:500;index"`
+	PrunedAt      time.Time `gorm:"not null;default:now()"`
+}
+
+// Enabled reports whether the policy expires any news.
+func (policy RetentionPolicy) Enabled() bool {
+	return !policy.Before.IsZero() || policy.MaxPerSite > 0
+}
+
+func (rep *repository) expiredNews(policy RetentionPolicy) *gorm.DB {
+	q := rep.conn.Model(&NewsItem{})
+	switch {
+	case !policy.Before.IsZero() && policy.MaxPerSite > 0:
+		q = q.Where("published_at < ? OR id IN (?)", policy.Before, rep.beyondSiteLimit(policy.MaxPerSite))
+	case !policy.Before.IsZero():
+		q = q.Where("published_at < ?", policy.Before)
+	case policy.MaxPerSite > 0:
+		q = q.Where("id IN (?)", rep.beyondSiteLimit(policy.MaxPerSite))
+	default:
+		q = q.Where("false")
+	}
+	if policy.KeepStarred {
+		q = q.Where("id NOT IN (SELECT news_item_id FROM bookmarks)")
+	}
+
+	return q
+}
+
+// beyondSiteLimit selects ids of news of every site older than the newest limit ones.
+func (rep *repository) beyondSiteLimit(limit int) interface{} {
+	ranked := rep.conn.Model(&NewsItem{}).
+		Select("id, row_number() OVER (PARTITION BY site_id ORDER BY published_at DESC, id DESC) AS position").
+		QueryExpr()
+
+	return gorm.Expr("SELECT id FROM (?) AS ranked WHERE position > ?", ranked, limit)
+}
+
+// GetExpiredNews returns up to limit oldest news expired by the policy with their tags.
+func (rep *repository) GetExpiredNews(policy RetentionPolicy, limit int) (news []NewsItem, err error) {
+	err = rep.expiredNews(policy).Order("id").Limit(limit).Find(&news).Error
+	if err != nil {
+		return
+	}
+	err = rep.loadTags(news)
+
+	return
+}
+
+// DeleteNews deletes the news items together with their read marks, bookmarks, tags and alert deliveries,
+// leaving tombstones of their links.
+func (rep *repository) DeleteNews(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx := rep.conn.Begin()
+	err := tx.Exec("INSERT INTO pruned_links (link, canonical_link, pruned_at) SELECT link, canonical_link, now() FROM news_items WHERE id IN (?)", ids).Error
+	if err == nil {
+		err = tx.Where("id IN (?)", ids).Delete(&NewsItem{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// DeletePrunedLinksBefore deletes tombstones of news pruned before the time.
+func (rep *repository) DeletePrunedLinksBefore(before time.Time) error {
+	return rep.conn.Where("pruned_at < ?", before).Delete(&PrunedLink{}).Error
+}
+
+// isPruned reports whether a news item with the link or the canonical link was pruned.
+func (rep *repository) isPruned(link string, canonicalLink string) (bool, error) {
+	q := rep.conn.Where("link = ? OR canonical_link = ?", link, canonicalLink).First(&PrunedLink{})
+	if q.RecordNotFound() {
+		return false, nil
+	} else if q.Error != nil {
+		return false, q.Error
+	}
+
+	return true, nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetExpiredNews(t *testing.T) {
+	before := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		query  string
+		args   []driver.Value
+	}{
+		{
+			name:   "age",
+			policy: RetentionPolicy{Before: before},
+			query:  `SELECT \* FROM "news_items" WHERE \(published_at < \$1\) ORDER BY "id" LIMIT 10`,
+			args:   []driver.Value{before},
+		},
+		{
+			name:   "site limit",
+			policy: RetentionPolicy{MaxPerSite: 100},
+			query: `SELECT \* FROM "news_items" WHERE \(id IN \(SELECT id FROM \(SELECT id, row_number\(\) OVER ` +
+				`\(PARTITION BY site_id ORDER BY published_at DESC, id DESC\) AS position FROM "news_items" +\) AS ranked ` +
+				`WHERE position > \$1\)\) ORDER BY "id" LIMIT 10`,
+			args: []driver.Value{100},
+		},
+		{
+			name:   "age and site limit keeping starred",
+			policy: RetentionPolicy{Before: before, MaxPerSite: 100, KeepStarred: true},
+			query: `SELECT \* FROM "news_items" WHERE \(published_at < \$1 OR id IN \(SELECT id FROM .* WHERE position > \$2\)\) ` +
+				`AND \(id NOT IN \(SELECT news_item_id FROM bookmarks\)\) ORDER BY "id" LIMIT 10`,
+			args: []driver.Value{before, 100},
+		},
+		{
+			name:   "disabled",
+			policy: RetentionPolicy{},
+			query:  `SELECT \* FROM "news_items" WHERE \(false\) ORDER BY "id" LIMIT 10`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, mock := newMockedRepository(t)
+			mock.ExpectQuery(tt.query).
+				WithArgs(tt.args...).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			news, err := rep.GetExpiredNews(tt.policy, 10)
+			assert.NoError(t, err)
+			assert.Empty(t, news)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteNews(t *testing.T) {
+	rep, mock := newMockedRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO pruned_links \(link, canonical_link, pruned_at\) SELECT link, canonical_link, now\(\) FROM news_items WHERE id IN \(\$1,\$2\)`).
+		WithArgs(1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM "news_items" WHERE \(id IN \(\$1,\$2\)\)`).
+		WithArgs(1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	assert.NoError(t, rep.DeleteNews([]int{1, 2}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rep, mock = newMockedRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO pruned_links`).
+		WithArgs(1).
+		WillReturnError(errors.New("failed"))
+	mock.ExpectRollback()
+
+	assert.EqualError(t, rep.DeleteNews([]int{1}), "failed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rep, mock = newMockedRepository(t)
+	assert.NoError(t, rep.DeleteNews(nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeletePrunedLinksBefore(t *testing.T) {
+	before := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	rep, mock := newMockedRepository(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "pruned_links" WHERE \(pruned_at < \$1\)`).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	assert.NoError(t, rep.DeletePrunedLinksBefore(before))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHasNewsItem(t *testing.T) {
+	item := NewsItem{Link: "https://news.ru/1?utm_source=rss"}
+	tests := []struct {
+		name   string
+		news   *sqlmock.Rows
+		pruned *sqlmock.Rows
+		has    bool
+	}{
+		{
+			name: "stored",
+			news: sqlmock.NewRows([]string{"id"}).AddRow(1),
+			has:  true,
+		},
+		{
+			name:   "pruned",
+			news:   sqlmock.NewRows([]string{"id"}),
+			pruned: sqlmock.NewRows([]string{"id"}).AddRow(1),
+			has:    true,
+		},
+		{
+			name:   "new",
+			news:   sqlmock.NewRows([]string{"id"}),
+			pruned: sqlmock.NewRows([]string{"id"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep, mock := newMockedRepository(t)
+			mock.ExpectQuery(`SELECT \* FROM "news_items" WHERE \(link = \$1 OR canonical_link = \$2\)`).
+				WithArgs(item.Link, "https://news.ru/1").
+				WillReturnRows(tt.news)
+			if tt.pruned != nil {
+				mock.ExpectQuery(`SELECT \* FROM "pruned_links" WHERE \(link = \$1 OR canonical_link = \$2\)`).
+					WithArgs(item.Link, "https://news.ru/1").
+					WillReturnRows(tt.pruned)
+			}
+
+			has, err := rep.HasNewsItem(item)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.has, has)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"time"
+)
+
+// Actions of site filters.
+const (
+	// FilterInclude keeps only news matching one of the include filters of the site.
+	FilterInclude = "include"
+	// FilterExclude drops news matching the filter.
+	FilterExclude = "exclude"
+)
+
+// Fields of news items matched by site filters.
+const (
+	FilterTitle       = "title"
+	FilterDescription = "description"
+	FilterLink        = "link"
+	// FilterText matches the title and the description.
+	FilterText = "text"
+	// FilterCategory matches each of the categories imported from the source.
+	FilterCategory = "category"
+)
+
+// SiteFilter includes or excludes news of the site before they are inserted.
+// The query is matched as the alert rule query of the same kind.
+type SiteFilter struct {
+	ID        int
+	SiteID    int    `gorm:"not null;index"`
+	Action    string `gorm:"size:10;not null"`
+	Field     string `gorm:"size:20;not null"`
+	Kind      string `gorm:"size:10;not null"`
+	Query     string `gorm:"size:500;not null"`
+	CreatedAt time.Time
+}
+
+func (rep *repository) GetSiteFilters(siteID int) (filters []SiteFilter, err error) {
+	err = rep.conn.Where("site_id = ?", siteID).Order("id").Find(&filters).Error
+
+	return
+}
+
+func (rep *repository) AddSiteFilter(filter *SiteFilter) error {
+	return rep.conn.Create(filter).Error
+}
+
+func (rep *repository) DeleteSiteFilter(siteID int, id int) error {
+	return rep.conn.Where("site_id = ? AND id = ?", siteID, id).Delete(&SiteFilter{}).Error
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"encoding/json"
+)
+
+// columns returns the options of the site stored in columns of the sites table.
+func (site *Site) columns() map[string]*string {
+	return map[string]*string{
+		"news_item_path":   &site.NewsItemPath,
+		"title_path":       &site.TitlePath,
+		"description_path": &site.DescriptionPath,
+		"link_path":        &site.LinkPath,
+		"date_path":        &site.DatePath,
+		"image_path":       &site.ImagePath,
+		"category_path":    &site.CategoryPath,
+	}
+}
+
+// Option returns the setting of the source type of the site, empty if it is not set.
+func (site Site) Option(name string) string {
+	if column, ok := site.columns()[name]; ok {
+		return *column
+	}
+
+	return site.OptionMap()[name]
+}
+
+// OptionMap returns the settings of the source type stored in Options.
+func (site Site) OptionMap() map[string]string {
+	options := map[string]string{}
+	if site.Options != "" {
+		// Options are only written by SetOption, a broken value reads as no options
+		json.Unmarshal([]byte(site.Options), &options)
+	}
+
+	return options
+}
+
+// SetOption sets the setting of the source type of the site, empty values are removed.
+func (site *Site) SetOption(name string, value string) {
+	if column, ok := site.columns()[name]; ok {
+		*column = value
+
+		return
+	}
+
+	options := site.OptionMap()
+	if value == "" {
+		delete(options, name)
+	} else {
+		options[name] = value
+	}
+	if len(options) == 0 {
+		site.Options = ""
+
+		return
+	}
+	data, _ := json.Marshal(options)
+	site.Options = string(data)
+}
+
+// migrateSiteTypes replaces the is_rss flag of sites added before source types with the rss and html types.
+func (rep *repository) migrateSiteTypes() error {
+	if !rep.conn.Dialect().HasColumn("sites", "is_rss") {
+		return nil
+	}
+
+	tx := rep.conn.Begin()
+	err := tx.Exec("UPDATE sites SET type = CASE WHEN is_rss THEN 'rss' ELSE 'html' END").Error
+	if err == nil {
+		err = tx.Model(&Site{}).DropColumn("is_rss").Error
+	}
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit().Error
+}
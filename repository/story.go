@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"time"
+)
+
+// storyExpr is the story id of a news item in queries.
+const storyExpr = "coalesce(nullif(story_id, 0), id)"
+
+// StoryFingerprint is the fingerprint of a recent news item new news are compared with.
+type StoryFingerprint struct {
+	ID          int
+	SiteID      int
+	StoryID     int
+	Fingerprint int64
+}
+
+// Story returns id of the story the news item belongs to.
+func (fingerprint StoryFingerprint) Story() int {
+	if fingerprint.StoryID > 0 {
+		return fingerprint.StoryID
+	}
+
+	return fingerprint.ID
+}
+
+// StorySources is the number of sites which reported the story.
+type StorySources struct {
+	StoryID int
+	Sources int
+}
+
+func (rep *repository) GetStoryFingerprints(since time.Time) (fingerprints []StoryFingerprint, err error) {
+	err = rep.conn.Model(&NewsItem{}).
+		Select("id, site_id, story_id, fingerprint").
+		Where("fingerprint <> 0 AND published_at >= ?", since).
+		Order("id").
+		Scan(&fingerprints).Error
+
+	return
+}
+
+func (rep *repository) GetStorySources(storyIDs []int) (sources []StorySources, err error) {
+	if len(storyIDs) == 0 {
+		return
+	}
+
+	err = rep.conn.Model(&NewsItem{}).
+		Select(storyExpr+" AS story_id, count(DISTINCT site_id) AS sources").
+		Where("id IN (?) OR story_id IN (?)", storyIDs, storyIDs).
+		Group(storyExpr).
+		Scan(&sources).Error
+
+	return
+}
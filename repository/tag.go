@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxTagLength is the maximal number of characters of a tag name.
+const maxTagLength = 100
+
+// Tag is a category of news, names are normalized by NormalizeTag.
+type Tag struct {
+	ID   int
+	Name string `gorm:"size:100;unique;not null"`
+}
+
+// NewsItemTag assigns the tag to the news item.
+type NewsItemTag struct {
+	NewsItemID int `gorm:"primary_key;auto_increment:false"`
+	TagID      int `gorm:"primary_key;auto_increment:false;index"`
+}
+
+// TagCount is a tag with the number of its news.
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// NormalizeTag returns the tag in lower case with collapsed whitespace, empty for a blank tag.
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if runes := []rune(tag); len(runes) > maxTagLength {
+		tag = strings.TrimSpace(string(runes[:maxTagLength]))
+	}
+
+	return tag
+}
+
+// NormalizeTags normalizes the tags dropping blank and repeated ones.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
+}
+
+// SplitTags splits comma separated tags.
+func SplitTags(value string) []string {
+	return NormalizeTags(strings.Split(value, ","))
+}
+
+// addTags creates missing tags and assigns them to the news item.
+func addTags(tx *gorm.DB, newsID int, tags []string) error {
+	for _, tag := range tags {
+		err := tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT DO NOTHING", tag).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(
+			"INSERT INTO news_item_tags (news_item_id, tag_id) SELECT ?, id FROM tags WHERE name = ? ON CONFLICT DO NOTHING",
+			newsID, tag,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadTags sets tags of the news items.
+func (rep *repository) loadTags(items []NewsItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	var rows []struct {
+		NewsItemID int
+		Name       string
+	}
+	err := rep.conn.Table("news_item_tags").
+		Select("news_item_tags.news_item_id, tags.name").
+		Joins("JOIN tags ON tags.id = news_item_tags.tag_id").
+		Where("news_item_tags.news_item_id IN (?)", ids).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[int][]string, len(items))
+	for _, row := range rows {
+		tags[row.NewsItemID] = append(tags[row.NewsItemID], row.Name)
+	}
+	for i := range items {
+		items[i].Tags = tags[items[i].ID]
+	}
+
+	return nil
+}
+
+// GetTags returns the tags with most news first.
+func (rep *repository) GetTags(limit int) (tags []TagCount, err error) {
+	err = rep.conn.Table("tags").
+		Select("tags.name, count(*) AS count").
+		Joins("JOIN news_item_tags ON news_item_tags.tag_id = tags.id").
+		Group("tags.name").
+		Order("count DESC, tags.name").
+		Limit(limit).
+		Scan(&tags).Error
+
+	return
+}
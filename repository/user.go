@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"time"
+)
+
+type User struct {
+	ID           int
+	Login        string `gorm:"size:100;unique;not null"`
+	PasswordHash string `gorm:"size:100;not null"`
+	IsAdmin      bool   `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// Session is a login session, ID is the hash of the token stored in the session cookie.
+type Session struct {
+	ID        string    `gorm:"primary_key;size:64"`
+	UserID    int       `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Subscription is a site chosen by a user for the main page.
+type Subscription struct {
+	UserID int `gorm:"primary_key;auto_increment:false"`
+	SiteID int `gorm:"primary_key;auto_increment:false"`
+}
+
+func (rep *repository) CountUsers() (count int, err error) {
+	err = rep.conn.Model(&User{}).Count(&count).Error
+
+	return
+}
+
+func (rep *repository) AddUser(user *User) error {
+	return rep.conn.Create(user).Error
+}
+
+func (rep *repository) GetUser(id int) (user User, err error) {
+	err = rep.conn.First(&user, id).Error
+
+	return
+}
+
+func (rep *repository) GetUserByLogin(login string) (user User, err error) {
+	err = rep.conn.Where("login = ?", login).First(&user).Error
+
+	return
+}
+
+func (rep *repository) AddSession(session *Session) error {
+	return rep.conn.Create(session).Error
+}
+
+// GetSession returns not expired session.
+func (rep *repository) GetSession(id string) (session Session, err error) {
+	err = rep.conn.Where("id = ? AND expires_at > ?", id, time.Now()).First(&session).Error
+
+	return
+}
+
+func (rep *repository) DeleteSession(id string) error {
+	return rep.conn.Where("id = ?", id).Delete(&Session{}).Error
+}
+
+func (rep *repository) GetSubscriptions(userID int) (siteIDs []int, err error) {
+	err = rep.conn.Model(&Subscription{}).Where("user_id = ?", userID).Order("site_id").Pluck("site_id", &siteIDs).Error
+
+	return
+}
+
+func (rep *repository) Subscribe(userID int, siteID int) error {
+	return rep.conn.FirstOrCreate(&Subscription{}, Subscription{UserID: userID, SiteID: siteID}).Error
+}
+
+func (rep *repository) Unsubscribe(userID int, siteID int) error {
+	return rep.conn.Where("user_id = ? AND site_id = ?", userID, siteID).Delete(&Subscription{}).Error
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"time"
+)
+
+// WebSubSubscription is a push subscription of a site feed at its WebSub hub.
+type WebSubSubscription struct {
+	SiteID int    `gorm:"primary_key;auto_increment:false"`
+	Hub    string `gorm:"size:500;not null"`
+	Topic  string `gorm:"size:500;not null"`
+	// Secret signs the content pushed by the hub.
+	Secret string `gorm:"size:64;not null"`
+	// Verified is set when the hub confirmed the subscription until LeaseExpiresAt.
+	Verified       bool
+	LeaseExpiresAt time.Time
+	// RequestedAt is when the subscription was last requested from the hub, zero once the hub verified it.
+	RequestedAt time.Time
+	UpdatedAt   time.Time
+}
+
+// Active reports whether the hub pushes updates of the site at the moment.
+func (sub WebSubSubscription) Active(now time.Time) bool {
+	return sub.Verified && now.Before(sub.LeaseExpiresAt)
+}
+
+func (rep *repository) GetWebSubSubscription(siteID int) (sub WebSubSubscription, err error) {
+	err = rep.conn.Where("site_id = ?", siteID).First(&sub).Error
+
+	return
+}
+
+func (rep *repository) GetWebSubSubscriptions() (subs []WebSubSubscription, err error) {
+	err = rep.conn.Order("site_id").Find(&subs).Error
+
+	return
+}
+
+func (rep *repository) SaveWebSubSubscription(sub *WebSubSubscription) error {
+	return rep.conn.Save(sub).Error
+}
+
+func (rep *repository) DeleteWebSubSubscription(siteID int) error {
+	return rep.conn.Where("site_id = ?", siteID).Delete(&WebSubSubscription{}).Error
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// pruneBatchSize is the number of expired news archived and deleted at once.
+const pruneBatchSize = 500
+
+// archivedNewsItem is a line of the news archive, it keeps everything stored about the news item.
+type archivedNewsItem struct {
+	ID            int       `json:"id"`
+	SiteID        int       `json:"site_id"`
+	SiteUrl       string    `json:"site_url"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Link          string    `json:"link"`
+	CanonicalLink string    `json:"canonical_link"`
+	Date          string    `json:"date"`
+	Image         string    `json:"image"`
+	PublishedAt   time.Time `json:"published_at"`
+	Fingerprint   int64     `json:"fingerprint"`
+	StoryID       int       `json:"story_id"`
+	Content       string    `json:"content,omitempty"`
+	ContentText   string    `json:"content_text,omitempty"`
+	Tags          []string  `json:"tags"`
+}
+
+// newsArchive writes pruned news to a gzip compressed JSON lines file.
+type newsArchive struct {
+	file    *os.File
+	gzip    *gzip.Writer
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// createNewsArchive creates the archive file of the prune started at now in the dir.
+func createNewsArchive(dir string, now time.Time) (*newsArchive, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, "news-"+now.UTC().Format("20060102-150405")+".jsonl.gz")
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(file)
+	buf := bufio.NewWriter(zw)
+
+	return &newsArchive{file: file, gzip: zw, buf: buf, encoder: json.NewEncoder(buf)}, nil
+}
+
+// write appends the news to the archive and syncs it to the disk,
+// so the news can be deleted once it returns.
+func (archive *newsArchive) write(news []repository.NewsItem, sites map[int]string) error {
+	for _, item := range news {
+		tags := item.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		err := archive.encoder.Encode(archivedNewsItem{
+			ID:            item.ID,
+			SiteID:        item.SiteID,
+			SiteUrl:       sites[item.SiteID],
+			Title:         item.Title,
+			Description:   item.Description,
+			Link:          item.Link,
+			CanonicalLink: item.CanonicalLink,
+			Date:          item.Date,
+			Image:         item.Image,
+			PublishedAt:   item.PublishedAt,
+			Fingerprint:   item.Fingerprint,
+			StoryID:       item.StoryID,
+			Content:       item.Content,
+			ContentText:   item.ContentText,
+			Tags:          tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := archive.buf.Flush()
+	if err != nil {
+		return err
+	}
+	err = archive.gzip.Flush()
+	if err != nil {
+		return err
+	}
+
+	return archive.file.Sync()
+}
+
+func (archive *newsArchive) name() string {
+	return archive.file.Name()
+}
+
+// close finishes the archive, an archive without news is removed.
+func (archive *newsArchive) close(empty bool) error {
+	err := archive.gzip.Close()
+	if closeErr := archive.file.Close(); err == nil {
+		err = closeErr
+	}
+	if empty {
+		return os.Remove(archive.file.Name())
+	}
+
+	return err
+}
+
+// retentionPolicy returns the policy selecting news which are expired at now.
+func (app *application) retentionPolicy(now time.Time) repository.RetentionPolicy {
+	policy := repository.RetentionPolicy{MaxPerSite: app.newsPerSite, KeepStarred: app.keepStarred}
+	if app.newsRetention > 0 {
+		policy.Before = now.Add(-app.newsRetention)
+	}
+
+	return policy
+}
+
+// expired reports whether the news item is too old to be inserted, it would be pruned right away.
+func (app *application) expired(item repository.NewsItem, now time.Time) bool {
+	return app.newsRetention > 0 && !item.PublishedAt.IsZero() && item.PublishedAt.Before(now.Add(-app.newsRetention))
+}
+
+func (app *application) pruning() {
+	if !app.retentionPolicy(time.Now()).Enabled() {
+		app.log.Info("News pruning is disabled without retention")
+
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(app.pruneInterval)
+		for {
+			select {
+			case <-app.stop:
+				return
+			case now := <-ticker.C:
+				app.pruneNews(now)
+			}
+		}
+	}()
+}
+
+// pruneNews archives and deletes news expired by the retention policy and returns the number of deleted news.
+// News are deleted only after they are written to the archive, news archived but failed to be deleted
+// are archived again by the next prune.
+func (app *application) pruneNews(now time.Time) (pruned int) {
+	policy := app.retentionPolicy(now)
+	pruneLog := app.log.WithField("before", policy.Before).WithField("max_per_site", policy.MaxPerSite)
+	defer func() {
+		newsPruned.Add(float64(pruned))
+		if pruned > 0 {
+			pruneLog.WithField("pruned", pruned).Info("Pruned expired news")
+		}
+	}()
+
+	if app.linksRetention > 0 {
+		err := app.repository.DeletePrunedLinksBefore(now.Add(-app.linksRetention))
+		if err != nil {
+			pruneLog.WithError(err).Error("Failed delete old pruned links from repository")
+		}
+	}
+
+	var archive *newsArchive
+	sites := make(map[int]string)
+	if app.archiveDir != "" {
+		list, err := app.repository.GetSites()
+		if err != nil {
+			pruneLog.WithError(err).Error("Failed get sites from repository")
+
+			return
+		}
+		for _, site := range list {
+			sites[site.ID] = site.Url
+		}
+		archive, err = createNewsArchive(app.archiveDir, now)
+		if err != nil {
+			pruneLog.WithError(err).Error("Failed create news archive")
+
+			return
+		}
+		pruneLog = pruneLog.WithField("archive", archive.name())
+		defer func() {
+			if err := archive.close(pruned == 0); err != nil {
+				pruneLog.WithError(err).Error("Failed close news archive")
+			}
+		}()
+	}
+
+	for {
+		news, err := app.repository.GetExpiredNews(policy, pruneBatchSize)
+		if err != nil {
+			pruneLog.WithError(err).Error("Failed get expired news from repository")
+
+			return
+		}
+		if len(news) == 0 {
+			return
+		}
+		if archive != nil {
+			err = archive.write(news, sites)
+			if err != nil {
+				pruneLog.WithError(err).Error("Failed write news archive")
+
+				return
+			}
+		}
+
+		ids := make([]int, len(news))
+		for i, item := range news {
+			ids[i] = item.ID
+		}
+		err = app.repository.DeleteNews(ids)
+		if err != nil {
+			pruneLog.WithError(err).Error("Failed delete expired news from repository")
+
+			return
+		}
+		pruned += len(news)
+		if len(news) < pruneBatchSize {
+			return
+		}
+	}
+}
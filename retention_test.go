@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	app := getApplication()
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.False(t, app.retentionPolicy(now).Enabled())
+
+	app.newsRetention = 30 * 24 * time.Hour
+	app.newsPerSite = 1000
+	app.keepStarred = true
+	policy := app.retentionPolicy(now)
+	assert.True(t, policy.Enabled())
+	assert.Equal(t, repository.RetentionPolicy{Before: time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC), MaxPerSite: 1000, KeepStarred: true}, policy)
+
+	assert.True(t, app.expired(repository.NewsItem{PublishedAt: now.AddDate(0, -2, 0)}, now))
+	assert.False(t, app.expired(repository.NewsItem{PublishedAt: now.AddDate(0, 0, -1)}, now))
+	assert.False(t, app.expired(repository.NewsItem{}, now))
+}
+
+func readArchive(t *testing.T, name string) (items []archivedNewsItem) {
+	file, err := os.Open(name)
+	assert.Nil(t, err)
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	assert.Nil(t, err)
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var item archivedNewsItem
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &item))
+		items = append(items, item)
+	}
+	assert.Nil(t, scanner.Err())
+
+	return
+}
+
+func TestPruneNews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	app := getApplication()
+	app.newsRetention = 24 * time.Hour
+	app.linksRetention = 30 * 24 * time.Hour
+	app.archiveDir = dir
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	policy := repository.RetentionPolicy{Before: now.Add(-24 * time.Hour)}
+
+	app.repository.(*mockedRepository).
+		On("DeletePrunedLinksBefore", time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)).
+		Return(nil)
+
+	batch := make([]repository.NewsItem, pruneBatchSize)
+	ids := make([]int, pruneBatchSize)
+	for i := range batch {
+		batch[i] = repository.NewsItem{ID: i + 1, SiteID: 1, Link: "http://test1.ru/news/old"}
+		ids[i] = i + 1
+	}
+	last := []repository.NewsItem{
+		{ID: 600, SiteID: 2, Title: "Нефть", Link: "http://test2.ru/news/600", PublishedAt: now.AddDate(0, 0, -3), Tags: []string{"экономика"}},
+	}
+
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{{ID: 1, Url: "http://test1.ru"}, {ID: 2, Url: "http://test2.ru"}}, nil)
+	app.repository.(*mockedRepository).
+		On("GetExpiredNews", policy, pruneBatchSize).
+		Return(batch, nil).Once()
+	app.repository.(*mockedRepository).
+		On("GetExpiredNews", policy, pruneBatchSize).
+		Return(last, nil).Once()
+	app.repository.(*mockedRepository).
+		On("DeleteNews", ids).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("DeleteNews", []int{600}).
+		Return(nil)
+
+	assert.Equal(t, pruneBatchSize+1, app.pruneNews(now))
+	app.repository.(*mockedRepository).AssertExpectations(t)
+
+	archived := readArchive(t, filepath.Join(dir, "news-20191001-120000.jsonl.gz"))
+	assert.Len(t, archived, pruneBatchSize+1)
+	assert.Equal(t, archivedNewsItem{
+		ID:          600,
+		SiteID:      2,
+		SiteUrl:     "http://test2.ru",
+		Title:       "Нефть",
+		Link:        "http://test2.ru/news/600",
+		PublishedAt: now.AddDate(0, 0, -3),
+		Tags:        []string{"экономика"},
+	}, archived[pruneBatchSize])
+}
+
+func TestPruneNewsNothingExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	app := getApplication()
+	app.newsPerSite = 100
+	app.keepStarred = true
+	app.archiveDir = dir
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	app.repository.(*mockedRepository).
+		On("GetSites").
+		Return([]repository.Site{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetExpiredNews", repository.RetentionPolicy{MaxPerSite: 100, KeepStarred: true}, pruneBatchSize).
+		Return([]repository.NewsItem{}, nil)
+
+	assert.Equal(t, 0, app.pruneNews(now))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestPruneNewsDeleteFailed(t *testing.T) {
+	app := getApplication()
+	app.newsRetention = 24 * time.Hour
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	policy := repository.RetentionPolicy{Before: now.Add(-24 * time.Hour)}
+
+	app.repository.(*mockedRepository).
+		On("GetExpiredNews", policy, pruneBatchSize).
+		Return([]repository.NewsItem{{ID: 1}, {ID: 2}}, nil)
+	app.repository.(*mockedRepository).
+		On("DeleteNews", []int{1, 2}).
+		Return(errors.New("db error"))
+
+	assert.Equal(t, 0, app.pruneNews(now))
+	app.repository.(*mockedRepository).AssertExpectations(t)
+}
+
+func TestPrunedNewsNotInsertedAgain(t *testing.T) {
+	app := getApplication()
+	app.newsPerSite = 1
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	site := repository.Site{ID: 1, Url: "http://test1.ru/rss", Type: parser.TypeRss}
+	feed := []repository.NewsItem{
+		{Title: "Новость 2", Link: "http://test1.ru/news/2", PublishedAt: now},
+		// undated news are not skipped as expired before insert
+		{Title: "Новость 1", Link: "http://test1.ru/news/1"},
+	}
+
+	// stored and pruned links stand for the news and tombstones of the repository
+	stored := map[string]bool{}
+	pruned := map[string]bool{}
+	app.parser.(*mockedParser).
+		On("Parse", site).
+		Return(feed, parser.FetchInfo{StatusCode: http.StatusOK}, nil)
+	app.repository.(*mockedRepository).On("GetSiteFilters", 1).Return([]repository.SiteFilter{}, nil)
+	app.repository.(*mockedRepository).On("AddParseRun", mock.Anything).Return(nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.MatchedBy(func(item repository.NewsItem) bool { return stored[item.Link] || pruned[item.Link] })).
+		Return(true, nil)
+	app.repository.(*mockedRepository).
+		On("HasNewsItem", mock.Anything).
+		Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.Anything).
+		Run(func(args mock.Arguments) { stored[args.Get(0).(*repository.NewsItem).Link] = true }).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("GetExpiredNews", repository.RetentionPolicy{MaxPerSite: 1}, pruneBatchSize).
+		Return([]repository.NewsItem{{ID: 2, SiteID: 1, Link: "http://test1.ru/news/1"}}, nil).Once()
+	app.repository.(*mockedRepository).
+		On("DeleteNews", []int{2}).
+		Run(func(args mock.Arguments) {
+			delete(stored, "http://test1.ru/news/1")
+			pruned["http://test1.ru/news/1"] = true
+		}).
+		Return(nil)
+
+	assert.Len(t, app.parseSite(site), 2)
+	assert.Equal(t, 1, app.pruneNews(now))
+	assert.Empty(t, app.parseSite(site))
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddNewsItem", 2)
+}
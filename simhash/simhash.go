@@ -0,0 +1,63 @@
+// Package simhash computes similarity fingerprints of texts, near-identical texts
+// have fingerprints differing in a few bits.
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// minWords is the number of words below which texts are too short to compare.
+const minWords = 3
+
+// Fingerprint returns the 64 bit SimHash of words and word pairs of the normalized text,
+// 0 if the text has fewer than minWords words.
+func Fingerprint(text string) uint64 {
+	words := Words(text)
+	if len(words) < minWords {
+		return 0
+	}
+
+	var weights [64]int
+	add := func(feature string) {
+		hash := fnv.New64a()
+		hash.Write([]byte(feature))
+		sum := hash.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	for i, word := range words {
+		add(word)
+		if i > 0 {
+			add(words[i-1] + " " + word)
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+
+	return fingerprint
+}
+
+// Words splits the text to lower case words of letters and digits.
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Distance returns the number of bits the fingerprints differ in.
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
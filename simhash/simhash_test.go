@@ -0,0 +1,28 @@
+package simhash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWords(t *testing.T) {
+	assert.Equal(t, []string{"цб", "повысил", "ставку", "до", "16"}, Words("ЦБ повысил ставку — до 16%!"))
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Zero(t, Fingerprint("Короткий текст"))
+	assert.Equal(t, Fingerprint("ЦБ повысил ключевую ставку"), Fingerprint("цб: повысил ключевую ставку!"))
+
+	original := Fingerprint("Банк России повысил ключевую ставку до 16% годовых. Решение принято на внеочередном заседании совета директоров, сообщает пресс-служба регулятора")
+	reprint := Fingerprint("Банк России повысил ключевую ставку до 16% годовых. Решение принято на внеочередном заседании совета директоров, сообщила пресс-служба регулятора")
+	other := Fingerprint("Сборная России по хоккею обыграла команду Финляндии в финале турнира со счетом 3:2 в овертайме")
+	assert.LessOrEqual(t, Distance(original, reprint), 6)
+	assert.Greater(t, Distance(original, other), 12)
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, Distance(5, 5))
+	assert.Equal(t, 2, Distance(0, 3))
+	assert.Equal(t, 64, Distance(0, ^uint64(0)))
+}
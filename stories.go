@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/simhash"
+)
+
+const (
+	// storyWindow is how far back news are compared with new ones.
+	storyWindow = 48 * time.Hour
+	// storyDistance is the maximal number of differing fingerprint bits of news of one story.
+	storyDistance = 6
+)
+
+// storyMatcher assigns new news to stories of recent news of other sites with similar fingerprints.
+// News of the same site are never grouped, since only the first news item of a story is listed.
+// Fingerprints of recent news are loaded on the first news with a fingerprint.
+type storyMatcher struct {
+	repository   Repository
+	loaded       bool
+	fingerprints []repository.StoryFingerprint
+}
+
+func (app *application) newStoryMatcher() *storyMatcher {
+	return &storyMatcher{repository: app.repository}
+}
+
+// assign sets fingerprint and story of the news item.
+func (matcher *storyMatcher) assign(item *repository.NewsItem) error {
+	item.Fingerprint = int64(simhash.Fingerprint(item.Title + " " + item.Description))
+	if item.Fingerprint == 0 {
+		return nil
+	}
+
+	if !matcher.loaded {
+		fingerprints, err := matcher.repository.GetStoryFingerprints(time.Now().Add(-storyWindow))
+		if err != nil {
+			return err
+		}
+		matcher.fingerprints = fingerprints
+		matcher.loaded = true
+	}
+
+	best := storyDistance + 1
+	for _, fingerprint := range matcher.fingerprints {
+		if fingerprint.SiteID == item.SiteID {
+			continue
+		}
+		distance := simhash.Distance(uint64(item.Fingerprint), uint64(fingerprint.Fingerprint))
+		if distance < best {
+			best = distance
+			item.StoryID = fingerprint.Story()
+		}
+	}
+
+	return nil
+}
+
+// add makes the inserted news item a candidate for the following news.
+func (matcher *storyMatcher) add(item repository.NewsItem) {
+	if item.Fingerprint != 0 {
+		matcher.fingerprints = append(matcher.fingerprints, repository.StoryFingerprint{
+			ID:          item.ID,
+			SiteID:      item.SiteID,
+			StoryID:     item.StoryID,
+			Fingerprint: item.Fingerprint,
+		})
+	}
+}
+
+// storySources returns the number of other sites which reported stories of the news by story id.
+func (app *application) storySources(items []repository.NewsItem) (map[int]int, error) {
+	if len(items) == 0 {
+		return map[int]int{}, nil
+	}
+
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Story())
+	}
+	sources, err := app.repository.GetStorySources(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	others := make(map[int]int, len(sources))
+	for _, story := range sources {
+		if story.Sources > 1 {
+			others[story.StoryID] = story.Sources - 1
+		}
+	}
+
+	return others, nil
+}
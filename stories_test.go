@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/onauryzbaev/go_news_final_/simhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStoryMatcher(t *testing.T) {
+	app := getApplication()
+	wire := "Банк России повысил ключевую ставку до 16% годовых на внеочередном заседании"
+	app.repository.(*mockedRepository).
+		On("GetStoryFingerprints", mock.Anything).
+		Return([]repository.StoryFingerprint{
+			{ID: 5, SiteID: 1, Fingerprint: int64(simhash.Fingerprint("Сборная России по хоккею обыграла Финляндию в финале турнира"))},
+			{ID: 7, SiteID: 1, StoryID: 3, Fingerprint: int64(simhash.Fingerprint(wire + " "))},
+		}, nil).Once()
+
+	matcher := app.newStoryMatcher()
+	short := repository.NewsItem{Title: "Коротко"}
+	assert.NoError(t, matcher.assign(&short))
+	assert.Zero(t, short.Fingerprint)
+	app.repository.(*mockedRepository).AssertNotCalled(t, "GetStoryFingerprints", mock.Anything)
+
+	reprint := repository.NewsItem{SiteID: 2, Title: "Банк России повысил ключевую ставку", Description: "до 16% годовых на внеочередном заседании"}
+	assert.NoError(t, matcher.assign(&reprint))
+	assert.NotZero(t, reprint.Fingerprint)
+	assert.Equal(t, 3, reprint.StoryID)
+
+	other := repository.NewsItem{ID: 9, SiteID: 2, Title: "Минфин разместил облигации федерального займа на 50 млрд рублей"}
+	assert.NoError(t, matcher.assign(&other))
+	assert.Zero(t, other.StoryID)
+	matcher.add(other)
+
+	again := repository.NewsItem{SiteID: 1, Title: "Минфин разместил облигации федерального займа на 50 млрд рублей"}
+	assert.NoError(t, matcher.assign(&again))
+	assert.Equal(t, 9, again.StoryID)
+
+	// an update of a news item by the same site stays a separate news item
+	update := repository.NewsItem{SiteID: 1, Title: "Банк России повысил ключевую ставку", Description: "до 16% годовых на внеочередном заседании"}
+	assert.NoError(t, matcher.assign(&update))
+	assert.NotZero(t, update.Fingerprint)
+	assert.Zero(t, update.StoryID)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetStoryFingerprints", 1)
+
+	app.repository.(*mockedRepository).
+		On("GetStoryFingerprints", mock.Anything).
+		Return([]repository.StoryFingerprint{}, errors.New("test repository error"))
+	failed := repository.NewsItem{Title: wire}
+	assert.Error(t, app.newStoryMatcher().assign(&failed))
+	assert.Zero(t, failed.StoryID)
+}
+
+func TestStorySources(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetStorySources", []int{3, 4}).
+		Return([]repository.StorySources{{StoryID: 3, Sources: 4}, {StoryID: 4, Sources: 1}}, nil)
+
+	sources, err := app.storySources([]repository.NewsItem{{ID: 7, StoryID: 3}, {ID: 4}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{3: 3}, sources)
+
+	sources, err = app.storySources(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, sources)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetStorySources", 1)
+}
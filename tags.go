@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+// tagsLimit is the number of most used tags listed on the tags page.
+const tagsLimit = 200
+
+// hasTag reports whether the normalized tag is one of the tags of a news item.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *application) tagsHandler(res http.ResponseWriter, req *http.Request) {
+	tags, err := app.repository.GetTags(tagsLimit)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get tags from repository")
+
+		return
+	}
+
+	err = app.render(
+		res,
+		"tags.tmpl",
+		struct {
+			User *repository.User
+			Tags []repository.TagCount
+		}{
+			app.user(req), tags,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseSiteDefaultTags(t *testing.T) {
+	app := getApplication()
+	site := repository.Site{ID: 1, Url: "http://test1.ru", Type: parser.TypeRss, DefaultTags: "Экономика, , Россия"}
+	app.parser.(*mockedParser).
+		On("Parse", site).
+		Return([]repository.NewsItem{{Link: "http://test1.ru/news/1", Tags: []string{"Нефть"}}}, parser.FetchInfo{StatusCode: http.StatusOK}, nil)
+	app.repository.(*mockedRepository).On("GetSiteFilters", 1).Return([]repository.SiteFilter{}, nil)
+	app.repository.(*mockedRepository).On("HasNewsItem", mock.Anything).Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.MatchedBy(func(item *repository.NewsItem) bool {
+			return assert.ObjectsAreEqual([]string{"Нефть", "экономика", "россия"}, item.Tags)
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).On("AddParseRun", mock.Anything).Return(nil)
+
+	inserted := app.parseSite(site)
+	assert.Len(t, inserted, 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddNewsItem", 1)
+}
+
+func TestTagsHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	app.repository.(*mockedRepository).
+		On("GetTags", tagsLimit).
+		Return([]repository.TagCount{{Name: "экономика", Count: 12}, {Name: "нефть и газ", Count: 3}}, nil)
+
+	req, _ := http.NewRequest("GET", "/tags", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.tagsHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<a href="/?tag=%d1%8d%d0%ba%d0%be%d0%bd%d0%be%d0%bc%d0%b8%d0%ba%d0%b0">#экономика <small>12</small></a>`)
+	assert.Contains(t, rr.Body.String(), `/?tag=%d0%bd%d0%b5%d1%84%d1%82%d1%8c%20%d0%b8%20%d0%b3%d0%b0%d0%b7`)
+}
@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBaseURL is the address of the Telegram Bot API.
+	DefaultBaseURL = "https://api.telegram.org"
+	// maxRetries is the number of retries of a request rejected with 429 Too Many Requests.
+	maxRetries = 3
+)
+
+// Config holds bot settings, zero intervals disable rate limiting.
+type Config struct {
+	Token   string
+	BaseURL string
+	// ChatInterval is the minimal interval between messages to one chat.
+	ChatInterval time.Duration
+	// GlobalInterval is the minimal interval between any messages of the bot.
+	GlobalInterval time.Duration
+}
+
+// Message is a message in Telegram HTML format, sent as a photo with caption when Photo is set.
+type Message struct {
+	Text  string
+	Photo string
+}
+
+// Error is returned when the Bot API rejects a request.
+type Error struct {
+	Code        int
+	Description string
+	RetryAfter  int
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("telegram api error %d: %s", err.Code, err.Description)
+}
+
+type response struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type bot struct {
+	config Config
+	client *http.Client
+	sleep  func(time.Duration)
+
+	mu       sync.Mutex
+	lastSent time.Time
+	chatSent map[string]time.Time
+}
+
+func NewBot(config Config) *bot {
+	if config.BaseURL == "" {
+		config.BaseURL = DefaultBaseURL
+	}
+
+	return &bot{
+		config:   config,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		sleep:    time.Sleep,
+		chatSent: map[string]time.Time{},
+	}
+}
+
+// Send posts the message to the chat, waiting for the rate limits and retrying when the API asks to.
+func (b *bot) Send(chatID string, message Message) error {
+	method := "sendMessage"
+	params := map[string]interface{}{
+		"chat_id":    chatID,
+		"parse_mode": "HTML",
+	}
+	if message.Photo != "" {
+		method = "sendPhoto"
+		params["photo"] = message.Photo
+		params["caption"] = message.Text
+	} else {
+		params["text"] = message.Text
+	}
+
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		b.wait(chatID)
+		err = b.call(method, params)
+		apiErr, ok := err.(*Error)
+		if !ok || apiErr.RetryAfter == 0 {
+			return err
+		}
+		b.sleep(time.Duration(apiErr.RetryAfter) * time.Second)
+	}
+
+	return err
+}
+
+// wait blocks until a message may be sent to the chat and reserves the slot.
+func (b *bot) wait(chatID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	next := b.lastSent.Add(b.config.GlobalInterval)
+	if chatNext := b.chatSent[chatID].Add(b.config.ChatInterval); chatNext.After(next) {
+		next = chatNext
+	}
+	if next.After(now) {
+		b.sleep(next.Sub(now))
+		now = next
+	}
+	b.lastSent = now
+	b.chatSent[chatID] = now
+}
+
+func (b *bot) call(method string, params map[string]interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(b.config.BaseURL, "/") + "/bot" + b.config.Token + "/" + method
+	res, err := b.client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the url of the error contains the bot token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("telegram request %s failed: %v", method, err)
+	}
+	defer res.Body.Close()
+
+	var result response
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram response %s with status code %d: %v", method, res.StatusCode, err)
+	}
+	if !result.Ok {
+		return &Error{Code: result.ErrorCode, Description: result.Description, RetryAfter: result.Parameters.RetryAfter}
+	}
+
+	return nil
+}
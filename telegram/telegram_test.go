@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRequest struct {
+	Path   string
+	Params map[string]interface{}
+}
+
+// fakeApi is a local stand-in of the Bot API answering with the queued responses.
+type fakeApi struct {
+	mu        sync.Mutex
+	requests  []fakeRequest
+	responses []string
+}
+
+func (api *fakeApi) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	params := map[string]interface{}{}
+	json.NewDecoder(req.Body).Decode(&params)
+	api.requests = append(api.requests, fakeRequest{req.URL.Path, params})
+
+	response := `{"ok": true, "result": {}}`
+	if len(api.responses) > 0 {
+		response, api.responses = api.responses[0], api.responses[1:]
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Write([]byte(response))
+}
+
+func TestSend(t *testing.T) {
+	api := &fakeApi{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	b := NewBot(Config{Token: "123:abc", BaseURL: server.URL + "/"})
+	assert.NoError(t, b.Send("@news", Message{Text: "<b>Заголовок</b>"}))
+	assert.NoError(t, b.Send("-100", Message{Text: "<b>Заголовок</b>", Photo: "http://test1.ru/1.jpeg"}))
+
+	assert.Equal(t, []fakeRequest{
+		{"/bot123:abc/sendMessage", map[string]interface{}{"chat_id": "@news", "parse_mode": "HTML", "text": "<b>Заголовок</b>"}},
+		{"/bot123:abc/sendPhoto", map[string]interface{}{"chat_id": "-100", "parse_mode": "HTML", "caption": "<b>Заголовок</b>", "photo": "http://test1.ru/1.jpeg"}},
+	}, api.requests)
+}
+
+func TestSendError(t *testing.T) {
+	api := &fakeApi{responses: []string{
+		`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 2", "parameters": {"retry_after": 2}}`,
+		`{"ok": true, "result": {}}`,
+		`{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`,
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	var slept []time.Duration
+	b := NewBot(Config{Token: "123:abc", BaseURL: server.URL})
+	b.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	assert.NoError(t, b.Send("@news", Message{Text: "test"}))
+	assert.Equal(t, []time.Duration{2 * time.Second}, slept)
+	assert.Len(t, api.requests, 2)
+
+	err := b.Send("@unknown", Message{Text: "test"})
+	assert.Equal(t, &Error{Code: 400, Description: "Bad Request: chat not found"}, err)
+	assert.EqualError(t, err, "telegram api error 400: Bad Request: chat not found")
+
+	server.Close()
+	err = b.Send("@news", Message{Text: "test"})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "123:abc")
+}
+
+func TestWait(t *testing.T) {
+	var slept []time.Duration
+	b := NewBot(Config{ChatInterval: time.Hour, GlobalInterval: time.Minute})
+	b.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	b.wait("1")
+	b.wait("2")
+	b.wait("1")
+	assert.Len(t, slept, 2)
+	assert.InDelta(t, time.Minute, slept[0], float64(time.Second))
+	assert.InDelta(t, time.Hour, slept[1], float64(time.Second))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+const apiTokenPrefix = "nwa_"
+
+var scopeLevels = map[string]int{
+	repository.ScopeRead:  1,
+	repository.ScopeWrite: 2,
+	repository.ScopeAdmin: 3,
+}
+
+// hasScope reports whether granted scope includes required one.
+func hasScope(granted string, required string) bool {
+	return scopeLevels[granted] > 0 && scopeLevels[granted] >= scopeLevels[required]
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+func (app *application) authenticateToken(handler http.HandlerFunc, res http.ResponseWriter, req *http.Request, value string) {
+	token, err := app.repository.GetApiTokenByHash(hashToken(value))
+	if err == repository.ErrNotFound {
+		writeJSONError(res, http.StatusUnauthorized, "invalid api token")
+
+		return
+	} else if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail get api token from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	user, err := app.repository.GetUser(token.UserID)
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail get user from repository")
+		writeJSONError(res, http.StatusInternalServerError, "internal error")
+
+		return
+	}
+
+	err = app.repository.TouchApiToken(token.ID, time.Now())
+	if err != nil {
+		app.requestLog(req).WithError(err).Error("Fail update api token in repository")
+	}
+
+	ctx := context.WithValue(req.Context(), userKey, &user)
+	ctx = context.WithValue(ctx, scopeKey, token.Scope)
+	handler(res, req.WithContext(ctx))
+}
+
+// scope returns access scope of the request: the api token scope or the scope of the session user.
+func (app *application) scope(req *http.Request) string {
+	if scope, ok := req.Context().Value(scopeKey).(string); ok {
+		return scope
+	}
+
+	user := app.user(req)
+	if user == nil {
+		return ""
+	} else if user.IsAdmin {
+		return repository.ScopeAdmin
+	}
+
+	return repository.ScopeWrite
+}
+
+// requireScope allows only requests authenticated with the scope, answering in json.
+// It is the only place accepting api tokens.
+func (app *application) requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	checked := app.checkScope(scope, handler)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		if token, ok := bearerToken(req); ok {
+			app.authenticateToken(checked, res, req, token)
+
+			return
+		}
+
+		checked(res, req)
+	}
+}
+
+// requireUserOrScope allows logged in users and api tokens with the scope.
+func (app *application) requireUserOrScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	user := app.requireUser(handler)
+	token := app.requireScope(scope, handler)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := bearerToken(req); ok {
+			token(res, req)
+
+			return
+		}
+
+		user(res, req)
+	}
+}
+
+// requireFeedScope is requireScope for feeds, which also accepts the token
+// in the token query parameter since feed readers cannot send headers.
+func (app *application) requireFeedScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	checked := app.checkScope(scope, handler)
+	scoped := app.requireScope(scope, handler)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		if _, ok := bearerToken(req); !ok {
+			if token := req.URL.Query().Get("token"); token != "" {
+				app.authenticateToken(checked, res, req, token)
+
+				return
+			}
+		}
+
+		scoped(res, req)
+	}
+}
+
+func (app *application) checkScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user := app.user(req)
+		if user == nil {
+			writeJSONError(res, http.StatusUnauthorized, "authentication required")
+
+			return
+		}
+		if !hasScope(app.scope(req), scope) || (scope == repository.ScopeAdmin && !user.IsAdmin) {
+			writeJSONError(res, http.StatusForbidden, "scope "+scope+" required")
+
+			return
+		}
+
+		handler(res, req)
+	}
+}
+
+func (app *application) tokensHandler(res http.ResponseWriter, req *http.Request) {
+	user := app.user(req)
+	var created string
+	var formError string
+
+	if req.Method == http.MethodPost {
+		name := strings.TrimSpace(req.FormValue("name"))
+		scope := req.FormValue("scope")
+		if name == "" {
+			formError = "Укажите название токена"
+		} else if scopeLevels[scope] == 0 || !hasScope(app.scope(req), scope) {
+			formError = "Недопустимые права токена"
+		} else {
+			value, err := newToken()
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail generate api token")
+
+				return
+			}
+			value = apiTokenPrefix + value
+			err = app.repository.AddApiToken(&repository.ApiToken{
+				UserID: user.ID,
+				Name:   name,
+				Hash:   hashToken(value),
+				Scope:  scope,
+			})
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail insert api token to repository")
+
+				return
+			}
+			created = value
+		}
+	}
+
+	tokens, err := app.repository.GetApiTokens(user.ID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get api tokens from repository")
+
+		return
+	}
+
+	if formError != "" {
+		res.WriteHeader(http.StatusBadRequest)
+	}
+	err = app.render(
+		res,
+		"tokens.tmpl",
+		struct {
+			CSRF    string
+			User    *repository.User
+			Tokens  []repository.ApiToken
+			Created string
+			Error   string
+		}{
+			app.csrfToken(req), user, tokens, created, formError,
+		},
+	)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail execute template")
+
+		return
+	}
+}
+
+func (app *application) tokenRevokeHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Redirect(res, req, "/tokens", http.StatusSeeOther)
+
+		return
+	}
+
+	err = app.repository.DeleteApiToken(app.user(req).ID, id)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail delete api token from repository")
+
+		return
+	}
+
+	http.Redirect(res, req, "/tokens", http.StatusSeeOther)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHasScope(t *testing.T) {
+	assert.True(t, hasScope(repository.ScopeAdmin, repository.ScopeRead))
+	assert.True(t, hasScope(repository.ScopeWrite, repository.ScopeWrite))
+	assert.False(t, hasScope(repository.ScopeRead, repository.ScopeWrite))
+	assert.False(t, hasScope("", repository.ScopeRead))
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_valid")).
+		Return(repository.ApiToken{ID: 5, UserID: 1, Scope: repository.ScopeRead}, nil)
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_revoked")).
+		Return(repository.ApiToken{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetUser", 1).
+		Return(repository.User{ID: 1, IsAdmin: true}, nil)
+	app.repository.(*mockedRepository).
+		On("TouchApiToken", 5, mock.Anything).
+		Return(nil)
+
+	handler := app.authenticate(app.requireScope(repository.ScopeRead, func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, repository.ScopeRead, app.scope(req))
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	req, _ := http.NewRequest("GET", "/api/news", nil)
+	req.Header.Set("Authorization", "Bearer nwa_valid")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "TouchApiToken", 1)
+
+	req, _ = http.NewRequest("GET", "/api/news", nil)
+	req.Header.Set("Authorization", "Bearer nwa_revoked")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.JSONEq(t, `{"error": "invalid api token"}`, rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/news", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	handler = app.authenticate(app.requireScope(repository.ScopeAdmin, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	req, _ = http.NewRequest("POST", "/api/sites", nil)
+	req.Header.Set("Authorization", "Bearer nwa_valid")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestTokensOnlyOnScopedRoutes(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_read")).
+		Return(repository.ApiToken{ID: 5, UserID: 1, Scope: repository.ScopeRead}, nil)
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_write")).
+		Return(repository.ApiToken{ID: 6, UserID: 1, Scope: repository.ScopeWrite}, nil)
+	app.repository.(*mockedRepository).
+		On("GetUser", 1).
+		Return(repository.User{ID: 1, IsAdmin: true}, nil)
+	app.repository.(*mockedRepository).
+		On("TouchApiToken", mock.Anything, mock.Anything).
+		Return(nil)
+	ok := func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}
+	send := func(handler http.HandlerFunc, token string) int {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		app.authenticate(handler).ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	// a token of an administrator does not log in to the site
+	assert.Equal(t, http.StatusUnauthorized, send(app.requireAdmin(ok), "nwa_read"))
+	assert.Equal(t, http.StatusUnauthorized, send(app.requireUser(app.tokensHandler), "nwa_read"))
+	assert.Equal(t, http.StatusOK, send(func(res http.ResponseWriter, req *http.Request) {
+		assert.Nil(t, app.user(req))
+	}, "nwa_read"))
+
+	assert.Equal(t, http.StatusForbidden, send(app.requireUserOrScope(repository.ScopeWrite, ok), "nwa_read"))
+	assert.Equal(t, http.StatusOK, send(app.requireUserOrScope(repository.ScopeWrite, ok), "nwa_write"))
+	app.repository.(*mockedRepository).AssertNotCalled(t, "GetSession", mock.Anything)
+}
+
+func TestRequireFeedScope(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_read")).
+		Return(repository.ApiToken{ID: 5, UserID: 1, Scope: repository.ScopeRead}, nil)
+	app.repository.(*mockedRepository).
+		On("GetApiTokenByHash", hashToken("nwa_revoked")).
+		Return(repository.ApiToken{}, repository.ErrNotFound)
+	app.repository.(*mockedRepository).
+		On("GetUser", 1).
+		Return(repository.User{ID: 1}, nil)
+	app.repository.(*mockedRepository).
+		On("TouchApiToken", 5, mock.Anything).
+		Return(nil)
+	ok := func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, 1, app.user(req).ID)
+		res.WriteHeader(http.StatusOK)
+	}
+	get := func(handler http.HandlerFunc, url string) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		app.authenticate(handler).ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get(app.requireFeedScope(repository.ScopeRead, ok), "/starred/rss?token=nwa_read"))
+	assert.Equal(t, http.StatusUnauthorized, get(app.requireFeedScope(repository.ScopeRead, ok), "/starred/rss?token=nwa_revoked"))
+	assert.Equal(t, http.StatusUnauthorized, get(app.requireFeedScope(repository.ScopeRead, ok), "/starred/rss"))
+	// other scoped routes accept the token only in the header
+	assert.Equal(t, http.StatusUnauthorized, get(app.requireScope(repository.ScopeRead, ok), "/api/news?token=nwa_read"))
+}
+
+func TestTokensHandler(t *testing.T) {
+	app := getApplication()
+	app.prepareTemplates()
+	user := &repository.User{ID: 1}
+
+	app.repository.(*mockedRepository).
+		On("GetApiTokens", 1).
+		Return([]repository.ApiToken{{ID: 1, Name: "script", Scope: repository.ScopeRead}}, nil)
+	app.repository.(*mockedRepository).
+		On("AddApiToken", mock.MatchedBy(func(token *repository.ApiToken) bool {
+			return token.UserID == 1 && token.Name == "export" && token.Scope == repository.ScopeWrite && len(token.Hash) == 64
+		})).
+		Return(nil)
+	handler := http.HandlerFunc(app.tokensHandler)
+
+	req, _ := http.NewRequest("GET", "/tokens", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "script")
+	assert.Contains(t, rr.Body.String(), "никогда")
+	assert.NotContains(t, rr.Body.String(), `<option value="admin">`)
+
+	reader := strings.NewReader("name=export&scope=write")
+	req, _ = http.NewRequest("POST", "/tokens", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<code>"+apiTokenPrefix)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddApiToken", 1)
+
+	reader = strings.NewReader("name=export&scope=admin")
+	req, _ = http.NewRequest("POST", "/tokens", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withUser(req, user))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddApiToken", 1)
+}
+
+func TestTokenRevokeHandler(t *testing.T) {
+	app := getApplication()
+	app.repository.(*mockedRepository).
+		On("DeleteApiToken", 1, 3).
+		Return(nil)
+
+	reader := strings.NewReader("id=3")
+	req, _ := http.NewRequest("POST", "/tokens/revoke", reader)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.tokenRevokeHandler).ServeHTTP(rr, withUser(req, &repository.User{ID: 1}))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "DeleteApiToken", 1)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/pipeline"
+	"github.com/onauryzbaev/go_news_final_/repository"
+)
+
+var telegramChatPattern = regexp.MustCompile(`^(-?\d+|@\w{5,})$`)
+
+// validateSite returns error messages by form field name, empty if the site is valid.
+func validateSite(site repository.Site) map[string]string {
+	errors := map[string]string{}
+
+	siteUrl, err := url.Parse(site.Url)
+	if site.Url == "" {
+		errors["url"] = "Укажите адрес страницы"
+	} else if err != nil || (siteUrl.Scheme != "http" && siteUrl.Scheme != "https") || siteUrl.Host == "" {
+		errors["url"] = "Адрес должен начинаться с http:// или https:// и содержать домен"
+	} else if strings.ContainsAny(siteUrl.Host, " \t") {
+		errors["url"] = "Некорректный домен"
+	}
+
+	if site.ContentPath != "" {
+		if _, err := cascadia.Compile(site.ContentPath); err != nil {
+			errors["content_path"] = "Некорректный селектор: " + err.Error()
+		}
+	}
+
+	if _, err := pipeline.Parse(site.Processors); err != nil {
+		errors["processors"] = "Некорректная обработка: " + err.Error()
+	}
+
+	source, ok := parser.Source(site.Type)
+	if !ok {
+		errors["type"] = "Неизвестный тип источника"
+
+		return errors
+	}
+	for _, field := range source.Schema() {
+		value := site.Option(field.Name)
+		if value == "" {
+			if field.Required && field.Kind == parser.FieldSelector {
+				errors[field.Name] = "Укажите селектор"
+			} else if field.Required {
+				errors[field.Name] = "Укажите значение"
+			}
+			continue
+		}
+		switch field.Kind {
+		case parser.FieldSelector:
+			if _, err := cascadia.Compile(value); err != nil {
+				errors[field.Name] = "Некорректный селектор: " + err.Error()
+			}
+		case parser.FieldRegex:
+			if _, err := regexp.Compile(value); err != nil {
+				errors[field.Name] = "Некорректное регулярное выражение: " + err.Error()
+			}
+		}
+	}
+
+	return errors
+}
+
+// validateAlertRule returns error messages by form field name, empty if the rule is valid.
+func validateAlertRule(rule repository.AlertRule) map[string]string {
+	errors := map[string]string{}
+
+	if rule.Name == "" {
+		errors["name"] = "Укажите название"
+	}
+
+	switch rule.Kind {
+	case repository.AlertKeyword, repository.AlertRegex, repository.AlertQuery:
+		if rule.Query == "" {
+			errors["query"] = "Укажите запрос"
+		} else if _, err := compileAlertRule(rule); err != nil {
+			errors["query"] = "Некорректный запрос: " + err.Error()
+		}
+	default:
+		errors["kind"] = "Неизвестный тип правила"
+	}
+
+	webhookUrl, err := url.Parse(rule.WebhookUrl)
+	if rule.WebhookUrl == "" {
+		if rule.TelegramChat == "" {
+			errors["webhook_url"] = "Укажите адрес вебхука или чат Telegram"
+		}
+	} else if err != nil || (webhookUrl.Scheme != "http" && webhookUrl.Scheme != "https") || webhookUrl.Host == "" {
+		errors["webhook_url"] = "Адрес должен начинаться с http:// или https:// и содержать домен"
+	}
+
+	if rule.TelegramChat != "" && !telegramChatPattern.MatchString(rule.TelegramChat) {
+		errors["telegram_chat"] = "Укажите числовой id чата или @имя канала"
+	}
+
+	return errors
+}
+
+// validateSiteFilter returns error messages by form field name, empty if the filter is valid.
+func validateSiteFilter(filter repository.SiteFilter) map[string]string {
+	errors := map[string]string{}
+
+	switch filter.Action {
+	case repository.FilterInclude, repository.FilterExclude:
+	default:
+		errors["action"] = "Неизвестное действие"
+	}
+
+	switch filter.Field {
+	case repository.FilterTitle, repository.FilterDescription, repository.FilterLink, repository.FilterText, repository.FilterCategory:
+	default:
+		errors["field"] = "Неизвестное поле"
+	}
+
+	switch filter.Kind {
+	case repository.AlertKeyword, repository.AlertRegex, repository.AlertQuery:
+		if filter.Query == "" {
+			errors["query"] = "Укажите запрос"
+		} else if _, err := compileMatcher(filter.Kind, filter.Query); err != nil {
+			errors["query"] = "Некорректный запрос: " + err.Error()
+		}
+	default:
+		errors["kind"] = "Неизвестный тип правила"
+	}
+
+	return errors
+}
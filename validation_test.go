@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSite(t *testing.T) {
+	assert.Empty(t, validateSite(repository.Site{Url: "https://test1.ru/rss", Type: parser.TypeRss}))
+	assert.Empty(t, validateSite(repository.Site{
+		Type:         parser.TypeHtml,
+		Url:          "http://test2.ru",
+		NewsItemPath: ".news article",
+		TitlePath:    "h3",
+		LinkPath:     "h3 a",
+		ImagePath:    "img[src]",
+	}))
+
+	assert.Contains(t, validateSite(repository.Site{Type: parser.TypeRss}), "url")
+	assert.Contains(t, validateSite(repository.Site{Url: "ftp://test1.ru", Type: parser.TypeRss}), "url")
+	assert.Contains(t, validateSite(repository.Site{Url: "javascript:alert(1)", Type: parser.TypeRss}), "url")
+	assert.Contains(t, validateSite(repository.Site{Url: "/news", Type: parser.TypeRss}), "url")
+
+	errors := validateSite(repository.Site{
+		Type:         parser.TypeHtml,
+		Url:          "http://test2.ru",
+		NewsItemPath: "article[",
+		LinkPath:     "a",
+		DatePath:     "i..date",
+	})
+	assert.Len(t, errors, 3)
+	assert.Contains(t, errors, "news_item_path")
+	assert.Equal(t, "Укажите селектор", errors["title_path"])
+	assert.Contains(t, errors, "date_path")
+
+	assert.Empty(t, validateSite(repository.Site{Url: "https://test1.ru/rss", Type: parser.TypeRss, FetchContent: true, ContentPath: ".article-body"}))
+	assert.Contains(t, validateSite(repository.Site{Url: "https://test1.ru/rss", Type: parser.TypeRss, ContentPath: "div["}), "content_path")
+	assert.Contains(t, validateSite(repository.Site{Type: parser.TypeHtml, Url: "http://test2.ru", NewsItemPath: "article", TitlePath: "h3", LinkPath: "a", CategoryPath: ".tags["}), "category_path")
+	assert.Empty(t, validateSite(repository.Site{Url: "https://test1.ru/rss", Type: parser.TypeRss, Processors: "strip_html\ntruncate description 300"}))
+	assert.Contains(t, validateSite(repository.Site{Url: "https://test1.ru/rss", Type: parser.TypeRss, Processors: "truncate description"}), "processors")
+
+	assert.Equal(t, map[string]string{"type": "Неизвестный тип источника"}, validateSite(repository.Site{Url: "https://test1.ru", Type: "ftp"}))
+	assert.Equal(t, map[string]string{"title_field": "Укажите значение", "link_field": "Укажите значение"}, validateSite(repository.Site{Url: "https://test1.ru/api", Type: parser.TypeJson}))
+	sitemap := repository.Site{Url: "https://test1.ru/sitemap.xml", Type: parser.TypeSitemap}
+	assert.Empty(t, validateSite(sitemap))
+	sitemap.SetOption("link_pattern", "/news/(")
+	assert.Contains(t, validateSite(sitemap)["link_pattern"], "Некорректное регулярное выражение")
+}
+
+func TestValidateAlertRule(t *testing.T) {
+	assert.Empty(t, validateAlertRule(repository.AlertRule{
+		Name:       "Нефть",
+		Kind:       repository.AlertQuery,
+		Query:      `нефть -"прогноз погоды"`,
+		WebhookUrl: "https://hooks.test/1",
+	}))
+
+	errors := validateAlertRule(repository.AlertRule{Kind: repository.AlertRegex, Query: "[", WebhookUrl: "hooks.test"})
+	assert.Len(t, errors, 3)
+	assert.Contains(t, errors, "name")
+	assert.Contains(t, errors, "query")
+	assert.Contains(t, errors, "webhook_url")
+
+	errors = validateAlertRule(repository.AlertRule{Name: "test", Kind: "sql", Query: "test", WebhookUrl: "http://hooks.test"})
+	assert.Equal(t, map[string]string{"kind": "Неизвестный тип правила"}, errors)
+}
+
+func TestValidateAlertRuleTelegram(t *testing.T) {
+	rule := repository.AlertRule{Name: "Нефть", Kind: repository.AlertKeyword, Query: "нефть", TelegramChat: "@oil_news"}
+	assert.Empty(t, validateAlertRule(rule))
+
+	rule.TelegramChat = "-1001234"
+	assert.Empty(t, validateAlertRule(rule))
+
+	rule.TelegramChat = "oil news"
+	assert.Equal(t, map[string]string{"telegram_chat": "Укажите числовой id чата или @имя канала"}, validateAlertRule(rule))
+
+	rule.TelegramChat = ""
+	assert.Contains(t, validateAlertRule(rule), "webhook_url")
+}
+
+func TestValidateSiteFilter(t *testing.T) {
+	filter := repository.SiteFilter{
+		Action: repository.FilterExclude,
+		Field:  repository.FilterTitle,
+		Kind:   repository.AlertKeyword,
+		Query:  "реклама, спонсор",
+	}
+	assert.Empty(t, validateSiteFilter(filter))
+
+	invalid := filter
+	invalid.Action = "drop"
+	invalid.Field = "author"
+	assert.Len(t, validateSiteFilter(invalid), 2)
+
+	invalid = filter
+	invalid.Kind = repository.AlertRegex
+	invalid.Query = "(реклама"
+	assert.Contains(t, validateSiteFilter(invalid), "query")
+
+	invalid.Query = ""
+	assert.Equal(t, "Укажите запрос", validateSiteFilter(invalid)["query"])
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// websubLease is the lease requested from hubs, hubs may grant up to websubMaxLease.
+	websubLease    = 10 * 24 * time.Hour
+	websubMaxLease = 3 * websubLease
+	// websubRenewBefore is how long before the lease expiry the subscription is renewed.
+	websubRenewBefore = 24 * time.Hour
+	// websubPollInterval is how often sites pushed by a hub are still polled in case pushes are lost.
+	websubPollInterval = time.Hour
+	websubMaxBody      = 10 << 20
+)
+
+// websubHashes are the signature methods of the X-Hub-Signature header.
+var websubHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// pushedSites returns ids of the sites with an active WebSub subscription.
+func (app *application) pushedSites() map[int]bool {
+	pushed := map[int]bool{}
+	if app.publicURL == "" {
+		return pushed
+	}
+
+	subs, err := app.repository.GetWebSubSubscriptions()
+	if err != nil {
+		app.log.WithError(err).Error("Failed get WebSub subscriptions from repository")
+
+		return pushed
+	}
+	now := time.Now()
+	for _, sub := range subs {
+		if sub.Active(now) {
+			pushed[sub.SiteID] = true
+		}
+	}
+
+	return pushed
+}
+
+// pollDue reports whether the pushed site has not been polled for websubPollInterval.
+func (app *application) pollDue(siteID int, now time.Time) bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	return now.Sub(app.polled[siteID]) >= websubPollInterval
+}
+
+func (app *application) setPolled(siteID int, now time.Time) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if app.polled == nil {
+		app.polled = map[int]time.Time{}
+	}
+	app.polled[siteID] = now
+}
+
+// needsSubscribe reports whether the subscription to the hub should be requested or renewed.
+// A pending or just verified request is not repeated for websubPollInterval.
+func needsSubscribe(sub repository.WebSubSubscription, found bool, hub string, topic string, now time.Time) bool {
+	if !found || sub.Hub != hub || sub.Topic != topic {
+		return true
+	}
+	if now.Sub(sub.UpdatedAt) < websubPollInterval {
+		return false
+	}
+
+	return !sub.Active(now.Add(websubRenewBefore))
+}
+
+// subscribeWebSub subscribes to the hub advertised by the site feed, renewing the lease before it expires.
+// The site is polled as usual until the hub verifies the subscription.
+func (app *application) subscribeWebSub(site repository.Site, info parser.FetchInfo, siteLog *logrus.Entry) {
+	if app.publicURL == "" {
+		return
+	}
+
+	topic := info.Topic
+	if topic == "" {
+		topic = site.Url
+	}
+	sub, err := app.repository.GetWebSubSubscription(site.ID)
+	if err != nil && err != repository.ErrNotFound {
+		siteLog.WithError(err).Error("Failed get WebSub subscription from repository")
+
+		return
+	}
+	found := err == nil
+	if !needsSubscribe(sub, found, info.Hub, topic, time.Now()) {
+		return
+	}
+
+	if !found {
+		sub = repository.WebSubSubscription{SiteID: site.ID}
+		sub.Secret, err = newToken()
+		if err != nil {
+			siteLog.WithError(err).Error("Failed generate WebSub secret")
+
+			return
+		}
+	}
+	if sub.Hub != info.Hub || sub.Topic != topic {
+		sub.Verified = false
+	}
+	sub.Hub = info.Hub
+	sub.Topic = topic
+	sub.RequestedAt = time.Now()
+	// the subscription is saved first, the hub verifies it right after the request
+	err = app.repository.SaveWebSubSubscription(&sub)
+	if err != nil {
+		siteLog.WithError(err).Error("Failed save WebSub subscription to repository")
+
+		return
+	}
+
+	hubLog := siteLog.WithFields(logrus.Fields{"hub": sub.Hub, "topic": sub.Topic})
+	err = app.requestWebSub(sub)
+	if err != nil {
+		hubLog.WithError(err).Warn("Failed subscribe to WebSub hub")
+
+		return
+	}
+	hubLog.Info("Requested WebSub subscription")
+}
+
+func (app *application) requestWebSub(sub repository.WebSubSubscription) error {
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {sub.Topic},
+		"hub.callback":      {fmt.Sprintf("%s/websub/%d", app.publicURL, sub.SiteID)},
+		"hub.lease_seconds": {strconv.Itoa(int(websubLease.Seconds()))},
+		"hub.secret":        {sub.Secret},
+	}
+	response, err := app.webhookClient.PostForm(sub.Hub, form)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("hub responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// websubPending reports whether the subscription was requested from the hub and waits for verification.
+// Requests are repeated after websubPollInterval, so the hub is expected to verify them before.
+func websubPending(sub repository.WebSubSubscription, now time.Time) bool {
+	return !sub.RequestedAt.IsZero() && now.Sub(sub.RequestedAt) < websubPollInterval
+}
+
+// validHubSignature checks the X-Hub-Signature header of the pushed content: method=hex.
+func validHubSignature(secret string, body []byte, signature string) bool {
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	newHash, ok := websubHashes[parts[0]]
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// websubHandler serves the WebSub callback /websub/{siteID}:
+// GET verifies the subscription intent, POST receives the content pushed by the hub.
+func (app *application) websubHandler(res http.ResponseWriter, req *http.Request) {
+	siteID, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/websub/"))
+	if err != nil || app.publicURL == "" {
+		res.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	sub, err := app.repository.GetWebSubSubscription(siteID)
+	if err != nil && err != repository.ErrNotFound {
+		res.WriteHeader(http.StatusInternalServerError)
+		app.requestLog(req).WithError(err).Error("Fail get WebSub subscription from repository")
+
+		return
+	}
+	found := err == nil
+
+	switch req.Method {
+	case http.MethodGet:
+		app.websubVerify(res, req, sub, found)
+	case http.MethodPost:
+		if !found {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		app.websubContent(res, req, sub)
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *application) websubVerify(res http.ResponseWriter, req *http.Request, sub repository.WebSubSubscription, found bool) {
+	query := req.URL.Query()
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		// only requests sent by the app are confirmed, anyone can call the callback with the public topic
+		if !found || query.Get("hub.topic") != sub.Topic || !websubPending(sub, time.Now()) {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		lease := websubLease
+		if seconds, err := strconv.Atoi(query.Get("hub.lease_seconds")); err == nil && seconds > 0 {
+			lease = websubMaxLease
+			if seconds < int(websubMaxLease/time.Second) {
+				lease = time.Duration(seconds) * time.Second
+			}
+		}
+		sub.Verified = true
+		sub.RequestedAt = time.Time{}
+		sub.LeaseExpiresAt = time.Now().Add(lease)
+		err := app.repository.SaveWebSubSubscription(&sub)
+		if err != nil {
+			res.WriteHeader(http.StatusInternalServerError)
+			app.requestLog(req).WithError(err).Error("Fail save WebSub subscription to repository")
+
+			return
+		}
+		app.requestLog(req).WithFields(logrus.Fields{"site_id": sub.SiteID, "lease": lease}).Info("Verified WebSub subscription")
+	case "unsubscribe":
+		// only subscriptions which are not kept any more can be cancelled
+		if found {
+			res.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+	case "denied":
+		if found {
+			err := app.repository.DeleteWebSubSubscription(sub.SiteID)
+			if err != nil {
+				res.WriteHeader(http.StatusInternalServerError)
+				app.requestLog(req).WithError(err).Error("Fail delete WebSub subscription from repository")
+
+				return
+			}
+			app.requestLog(req).WithFields(logrus.Fields{"site_id": sub.SiteID, "reason": query.Get("hub.reason")}).Warn("WebSub subscription denied")
+		}
+
+		return
+	default:
+		res.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.Write([]byte(query.Get("hub.challenge")))
+}
+
+func (app *application) websubContent(res http.ResponseWriter, req *http.Request, sub repository.WebSubSubscription) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, websubMaxBody))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	siteLog := app.requestLog(req).WithField("site_id", sub.SiteID)
+	// content with a missing or invalid signature is acknowledged but ignored
+	if !validHubSignature(sub.Secret, body, req.Header.Get("X-Hub-Signature")) {
+		siteLog.Warn("Ignored WebSub content with invalid signature")
+		res.WriteHeader(http.StatusAccepted)
+
+		return
+	}
+
+	site, err := app.repository.GetSite(sub.SiteID)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		siteLog.WithError(err).Error("Fail get site from repository")
+
+		return
+	}
+	news, err := app.parser.ParseFeed(site, body)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		siteLog.WithError(err).Warn("Fail parse WebSub content")
+
+		return
+	}
+
+	found := len(news)
+	news, filtered, err := app.filterSiteNews(site, news)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		siteLog.WithError(err).Error("Fail get site filters from repository")
+
+		return
+	}
+
+	inserted := app.insertNews(site, news, siteLog)
+	app.alert(inserted)
+	app.broadcast(inserted)
+	siteLog.WithFields(logrus.Fields{"found": found, "filtered": filtered, "inserted": len(inserted)}).Info("Complete WebSub content")
+
+	res.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onauryzbaev/go_news_final_/parser"
+	"github.com/onauryzbaev/go_news_final_/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNeedsSubscribe(t *testing.T) {
+	now := time.Now()
+	sub := repository.WebSubSubscription{
+		Hub:            "https://hub.ru",
+		Topic:          "https://news.ru/rss",
+		Verified:       true,
+		LeaseExpiresAt: now.Add(5 * 24 * time.Hour),
+		UpdatedAt:      now.Add(-2 * time.Hour),
+	}
+
+	assert.True(t, needsSubscribe(repository.WebSubSubscription{}, false, sub.Hub, sub.Topic, now))
+	assert.False(t, needsSubscribe(sub, true, sub.Hub, sub.Topic, now))
+	assert.True(t, needsSubscribe(sub, true, "https://other.hub.ru", sub.Topic, now))
+	assert.True(t, needsSubscribe(sub, true, sub.Hub, "https://news.ru/other.rss", now))
+
+	expiring := sub
+	expiring.LeaseExpiresAt = now.Add(time.Hour)
+	assert.True(t, needsSubscribe(expiring, true, sub.Hub, sub.Topic, now))
+
+	pending := sub
+	pending.Verified = false
+	assert.True(t, needsSubscribe(pending, true, sub.Hub, sub.Topic, now))
+	pending.UpdatedAt = now.Add(-time.Minute)
+	assert.False(t, needsSubscribe(pending, true, sub.Hub, sub.Topic, now))
+}
+
+func TestValidHubSignature(t *testing.T) {
+	body := []byte("<rss></rss>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.True(t, validHubSignature("secret", body, signature))
+	assert.False(t, validHubSignature("other", body, signature))
+	assert.False(t, validHubSignature("secret", []byte("<rss/>"), signature))
+	assert.False(t, validHubSignature("secret", body, "md5="+hex.EncodeToString(mac.Sum(nil))))
+	assert.False(t, validHubSignature("secret", body, "sha256=zz"))
+	assert.False(t, validHubSignature("secret", body, ""))
+}
+
+func TestSubscribeWebSub(t *testing.T) {
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		res.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	app := getApplication()
+	app.publicURL = "https://newsagg.ru"
+	app.webhookClient = hub.Client()
+	site := repository.Site{ID: 3, Url: "https://news.ru/rss", Type: parser.TypeRss}
+
+	app.repository.(*mockedRepository).
+		On("GetWebSubSubscription", 3).
+		Return(repository.WebSubSubscription{}, repository.ErrNotFound).Once()
+	app.repository.(*mockedRepository).
+		On("SaveWebSubSubscription", mock.MatchedBy(func(sub *repository.WebSubSubscription) bool {
+			return sub.SiteID == 3 && sub.Hub == hub.URL && sub.Topic == "https://news.ru/feed" && len(sub.Secret) == 64 && !sub.Verified && !sub.RequestedAt.IsZero()
+		})).
+		Return(nil).Once()
+
+	app.subscribeWebSub(site, parser.FetchInfo{Hub: hub.URL, Topic: "https://news.ru/feed"}, app.log.WithField("site_id", 3))
+	app.repository.(*mockedRepository).AssertExpectations(t)
+	assert.Equal(t, "subscribe", form.Get("hub.mode"))
+	assert.Equal(t, "https://news.ru/feed", form.Get("hub.topic"))
+	assert.Equal(t, "https://newsagg.ru/websub/3", form.Get("hub.callback"))
+	assert.Equal(t, "864000", form.Get("hub.lease_seconds"))
+	assert.Len(t, form.Get("hub.secret"), 64)
+
+	// verified subscription far from expiry is kept
+	form = nil
+	app.repository.(*mockedRepository).
+		On("GetWebSubSubscription", 3).
+		Return(repository.WebSubSubscription{
+			SiteID:         3,
+			Hub:            hub.URL,
+			Topic:          site.Url,
+			Verified:       true,
+			LeaseExpiresAt: time.Now().Add(5 * 24 * time.Hour),
+		}, nil).Once()
+	app.subscribeWebSub(site, parser.FetchInfo{Hub: hub.URL}, app.log.WithField("site_id", 3))
+	assert.Nil(t, form)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "SaveWebSubSubscription", 1)
+}
+
+func TestParseSitesSkipsPushedSites(t *testing.T) {
+	app := getApplication()
+	app.publicURL = "https://newsagg.ru"
+	site := repository.Site{ID: 1, Url: "https://news.ru/rss", Type: parser.TypeRss}
+
+	app.repository.(*mockedRepository).On("GetSites").Return([]repository.Site{site}, nil)
+	app.repository.(*mockedRepository).On("GetAlertRules").Return([]repository.AlertRule{}, nil)
+	app.repository.(*mockedRepository).On("AddParseRun", mock.Anything).Return(nil)
+	app.repository.(*mockedRepository).On("GetSiteFilters", site.ID).Return([]repository.SiteFilter{}, nil)
+	app.repository.(*mockedRepository).
+		On("GetWebSubSubscriptions").
+		Return([]repository.WebSubSubscription{{SiteID: 1, Verified: true, LeaseExpiresAt: time.Now().Add(time.Hour)}}, nil)
+	app.parser.(*mockedParser).On("Parse", site).Return([]repository.NewsItem{}, parser.FetchInfo{StatusCode: http.StatusOK}, nil)
+
+	app.parseSites()
+	app.parseSites()
+	app.parser.(*mockedParser).AssertNumberOfCalls(t, "Parse", 1)
+
+	app.setPolled(site.ID, time.Now().Add(-websubPollInterval))
+	app.parseSites()
+	app.parser.(*mockedParser).AssertNumberOfCalls(t, "Parse", 2)
+}
+
+func TestWebsubHandlerVerify(t *testing.T) {
+	app := getApplication()
+	app.publicURL = "https://newsagg.ru"
+	sub := repository.WebSubSubscription{SiteID: 3, Hub: "https://hub.ru", Topic: "https://news.ru/rss", Secret: "secret", RequestedAt: time.Now().Add(-time.Minute)}
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 3).Return(sub, nil)
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 4).Return(repository.WebSubSubscription{}, repository.ErrNotFound)
+	verified := repository.WebSubSubscription{SiteID: 5, Hub: "https://hub.ru", Topic: "https://news.ru/rss", Secret: "secret", Verified: true}
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 5).Return(verified, nil)
+	stale := repository.WebSubSubscription{SiteID: 6, Hub: "https://hub.ru", Topic: "https://news.ru/rss", Secret: "secret", RequestedAt: time.Now().Add(-2 * websubPollInterval)}
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 6).Return(stale, nil)
+	pending := repository.WebSubSubscription{SiteID: 7, Hub: "https://hub.ru", Topic: "https://news.ru/rss", Secret: "secret", RequestedAt: time.Now()}
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 7).Return(pending, nil)
+	app.repository.(*mockedRepository).
+		On("SaveWebSubSubscription", mock.MatchedBy(func(sub *repository.WebSubSubscription) bool {
+			lease := time.Until(sub.LeaseExpiresAt)
+			return sub.SiteID == 3 && sub.Verified && sub.RequestedAt.IsZero() && lease > 59*time.Minute && lease <= time.Hour
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).
+		On("SaveWebSubSubscription", mock.MatchedBy(func(sub *repository.WebSubSubscription) bool {
+			lease := time.Until(sub.LeaseExpiresAt)
+			return sub.SiteID == 7 && sub.Verified && lease > websubMaxLease-time.Minute && lease <= websubMaxLease
+		})).
+		Return(nil)
+	app.repository.(*mockedRepository).On("DeleteWebSubSubscription", 3).Return(nil)
+
+	verify := func(path string, query url.Values) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		app.websubHandler(res, httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil))
+
+		return res
+	}
+
+	res := verify("/websub/3", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"abc"}, "hub.lease_seconds": {"3600"}})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "abc", res.Body.String())
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "SaveWebSubSubscription", 1)
+
+	res = verify("/websub/3", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://news.ru/other"}, "hub.challenge": {"abc"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	res = verify("/websub/4", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"abc"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	res = verify("/websub/x", url.Values{"hub.mode": {"subscribe"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	// the subscription is confirmed only while the app waits for the verification
+	res = verify("/websub/5", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"abc"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Empty(t, res.Body.String())
+	res = verify("/websub/6", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"abc"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Empty(t, res.Body.String())
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "SaveWebSubSubscription", 1)
+
+	res = verify("/websub/7", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"abc"}, "hub.lease_seconds": {"9223372036854775807"}})
+	assert.Equal(t, http.StatusOK, res.Code)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "SaveWebSubSubscription", 2)
+
+	res = verify("/websub/4", url.Values{"hub.mode": {"unsubscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"def"}})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "def", res.Body.String())
+	res = verify("/websub/3", url.Values{"hub.mode": {"unsubscribe"}, "hub.topic": {sub.Topic}, "hub.challenge": {"def"}})
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	res = verify("/websub/3", url.Values{"hub.mode": {"denied"}, "hub.topic": {sub.Topic}, "hub.reason": {"spam"}})
+	assert.Equal(t, http.StatusOK, res.Code)
+	app.repository.(*mockedRepository).AssertCalled(t, "DeleteWebSubSubscription", 3)
+}
+
+func TestWebsubHandlerContent(t *testing.T) {
+	app := getApplication()
+	app.publicURL = "https://newsagg.ru"
+	site := repository.Site{ID: 3, Url: "https://news.ru/rss", Type: parser.TypeRss}
+	sub := repository.WebSubSubscription{SiteID: 3, Topic: site.Url, Secret: "secret", Verified: true}
+	body := "<rss><channel><item><link>https://news.ru/1</link></item></channel></rss>"
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(body))
+
+	app.repository.(*mockedRepository).On("GetWebSubSubscription", 3).Return(sub, nil)
+	app.repository.(*mockedRepository).On("GetSite", 3).Return(site, nil)
+	app.repository.(*mockedRepository).On("GetSiteFilters", 3).Return([]repository.SiteFilter{}, nil)
+	app.repository.(*mockedRepository).On("GetAlertRules").Return([]repository.AlertRule{}, nil)
+	app.repository.(*mockedRepository).On("HasNewsItem", mock.Anything).Return(false, nil)
+	app.repository.(*mockedRepository).
+		On("AddNewsItem", mock.MatchedBy(func(item *repository.NewsItem) bool { return item.SiteID == 3 && item.Link == "https://news.ru/1" })).
+		Return(nil)
+	app.parser.(*mockedParser).
+		On("ParseFeed", site, []byte(body)).
+		Return([]repository.NewsItem{{Link: "https://news.ru/1"}}, nil)
+
+	push := func(signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/websub/3", strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", signature)
+		res := httptest.NewRecorder()
+		app.websubHandler(res, req)
+
+		return res
+	}
+
+	res := push("sha256=0000")
+	assert.Equal(t, http.StatusAccepted, res.Code)
+	app.parser.(*mockedParser).AssertNotCalled(t, "ParseFeed", site, []byte(body))
+
+	res = push("sha256=" + hex.EncodeToString(mac.Sum(nil)))
+	assert.Equal(t, http.StatusAccepted, res.Code)
+	app.parser.(*mockedParser).AssertNumberOfCalls(t, "ParseFeed", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "AddNewsItem", 1)
+	app.repository.(*mockedRepository).AssertNumberOfCalls(t, "GetAlertRules", 1)
+}